@@ -0,0 +1,346 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Customer is a customer account, keyed on e-mail address. There is no
+// password: customers log in with a link mailed to that address. The
+// account is created the first time such a link is used.
+type Customer struct {
+	ID          uint   `gorm:"primaryKey"`
+	Email       string `gorm:"not null;uniqueIndex"` // lower case
+	Naam        string
+	Bedrijf     string
+	Telefoon    string
+	Adres       string
+	LastLoginAt *time.Time // login links issued before this are used up
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+const (
+	tokenAccountLogin = "account-inloggen"
+	accountLinkTTL    = 15 * time.Minute
+	maxLoginMails     = 3 // per address per hour
+)
+
+var customerSessions = sessionKind{
+	Name:   "klant",
+	Cookie: "klant_sessie",
+	Path:   "/account",
+	Idle:   14 * 24 * time.Hour,
+}
+
+// accountGroup returns the router group for the customer portal.
+func accountGroup(r *gin.Engine) *gin.RouterGroup {
+	return r.Group("/account", adminHeaders, sameOriginPosts)
+}
+
+// normalizeEmail is the form of an address accounts are keyed on.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// knownCustomer reports whether we have anything on record for the address,
+// so login links are only sent to customers.
+func knownCustomer(email string) (bool, error) {
+	var n int64
+	for _, model := range []any{&Contact{}, &Ticket{}} {
+		if err := byEmail(db.Model(model), email).Count(&n).Error; err != nil || n > 0 {
+			return n > 0, err
+		}
+	}
+	for _, model := range []any{&Customer{}, &Appointment{}} {
+		if err := db.Model(model).Where("lower(email) = ?", email).Count(&n).Error; err != nil {
+			return false, err
+		}
+		if n > 0 {
+			return true, nil
+		}
+	}
+	err := db.Model(&Quote{}).Where("lower(email) = ? AND status <> ?", email, quoteDraft).Count(&n).Error
+	return n > 0, err
+}
+
+// currentCustomer returns the logged-in customer.
+func currentCustomer(c *gin.Context) (Customer, bool) {
+	s, ok := customerSessions.current(c)
+	if !ok {
+		return Customer{}, false
+	}
+	var customer Customer
+	if err := db.First(&customer, s.UserID).Error; err != nil {
+		return Customer{}, false
+	}
+	return customer, true
+}
+
+// requireCustomer sends visitors without a session to the login page.
+func requireCustomer(c *gin.Context) {
+	customer, ok := currentCustomer(c)
+	if !ok {
+		c.Redirect(http.StatusSeeOther, "/account/inloggen")
+		c.Abort()
+		return
+	}
+	c.Set("customer", customer)
+}
+
+func renderAccountLogin(c *gin.Context, status int, data gin.H) {
+	data["Minutes"] = int(accountLinkTTL.Minutes())
+	c.HTML(status, "account-inloggen", privatePage("/account/inloggen", "Inloggen", "account-inloggen", data))
+}
+
+// accountLoginHandler handles GET /account/inloggen.
+func accountLoginHandler(c *gin.Context) {
+	if _, ok := currentCustomer(c); ok {
+		c.Redirect(http.StatusSeeOther, "/account")
+		return
+	}
+	renderAccountLogin(c, http.StatusOK, gin.H{})
+}
+
+// accountLoginPostHandler handles POST /account/inloggen: it mails a login
+// link if the address belongs to a customer. The answer is the same either
+// way, so the form does not reveal who our customers are.
+func accountLoginPostHandler(c *gin.Context) {
+	email := normalizeEmail(c.PostForm("email"))
+	if !validEmail(email) {
+		renderAccountLogin(c, http.StatusBadRequest, gin.H{"Error": "Vul een geldig e-mailadres in.", "Email": email})
+		return
+	}
+	known, err := knownCustomer(email)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if known {
+		var recent int64
+		err := db.Model(&OutboxMessage{}).
+			Where("lower(\"to\") = ? AND subject = ? AND created_at > ?", email, accountLoginSubject(), time.Now().Add(-time.Hour).UTC()).
+			Count(&recent).Error
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		if recent < maxLoginMails {
+			if err := queueMail(db, accountLoginMail(email)); err != nil {
+				c.AbortWithError(http.StatusInternalServerError, err)
+				return
+			}
+		}
+	}
+	renderAccountLogin(c, http.StatusOK, gin.H{"Sent": email})
+}
+
+// loginToken returns a login token for email. It carries the time it was
+// issued, so that logging in uses up all earlier links.
+func loginToken(email string) string {
+	return signToken(tokenAccountLogin, strconv.FormatInt(time.Now().UnixMilli(), 10)+"|"+email, accountLinkTTL)
+}
+
+// verifyLoginToken returns the address and issue time of a login token.
+func verifyLoginToken(token string) (string, time.Time, error) {
+	subject, err := verifyToken(tokenAccountLogin, token)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	issued, email, ok := strings.Cut(subject, "|")
+	ms, err := strconv.ParseInt(issued, 10, 64)
+	if !ok || err != nil {
+		return "", time.Time{}, errTokenInvalid
+	}
+	return email, time.UnixMilli(ms), nil
+}
+
+func accountLoginSubject() string {
+	return "Inloggen bij " + company.Name
+}
+
+func accountLoginMail(email string) *OutboxMessage {
+	return &OutboxMessage{
+		To:      email,
+		Subject: accountLoginSubject(),
+		Text: fmt.Sprintf(`Beste klant,
+
+Met deze link logt u in op uw klantportaal bij %s:
+%s
+
+De link is %d minuten geldig en werkt één keer. Heeft u niet gevraagd om in te loggen? Dan kunt u deze e-mail negeren.
+
+Met vriendelijke groet,
+%s
+`, company.Name, site.URL("/account/inloggen/link?token="+loginToken(email)), int(accountLinkTTL.Minutes()), company.Name),
+	}
+}
+
+// accountLinkHandler handles GET and POST /account/inloggen/link?token=....
+// Opening the link shows a button; only posting it logs in, because mail
+// scanners open links in e-mails too.
+func accountLinkHandler(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		token = c.PostForm("token")
+	}
+	email, issued, err := verifyLoginToken(token)
+	if err != nil {
+		renderAccountLogin(c, http.StatusForbidden, gin.H{"Error": "Deze inloglink is ongeldig of verlopen. Vraag hieronder een nieuwe aan."})
+		return
+	}
+	if c.Request.Method == http.MethodGet {
+		renderAccountLogin(c, http.StatusOK, gin.H{"Token": token, "Email": email})
+		return
+	}
+
+	var customer Customer
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("email = ?", email).First(&customer).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			customer = newCustomer(tx, email)
+			err = tx.Create(&customer).Error
+		}
+		if err != nil {
+			return err
+		}
+		if customer.LastLoginAt != nil && !issued.After(*customer.LastLoginAt) {
+			return errLinkUsed
+		}
+		now := time.Now().UTC()
+		customer.LastLoginAt = &now
+		return tx.Model(&customer).Update("last_login_at", now).Error
+	})
+	if errors.Is(err, errLinkUsed) {
+		renderAccountLogin(c, http.StatusForbidden, gin.H{"Error": "Deze inloglink is al gebruikt. Vraag hieronder een nieuwe aan."})
+		return
+	}
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if err := deleteExpiredSessions(); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if err := customerSessions.start(c, customer.ID); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/account")
+}
+
+var errLinkUsed = errors.New("login link already used")
+
+// newCustomer starts an account with the details the customer last gave us.
+func newCustomer(tx *gorm.DB, email string) Customer {
+	customer := Customer{Email: email}
+	var contact Contact
+	if byEmail(tx, email).Order("created_at DESC").Limit(1).Find(&contact).RowsAffected > 0 {
+		customer.Naam, customer.Bedrijf, customer.Telefoon = contact.Naam, contact.Bedrijf, contact.Telefoon
+	}
+	var appointment Appointment
+	if tx.Where("lower(email) = ?", email).Order("created_at DESC").Limit(1).Find(&appointment).RowsAffected > 0 {
+		if customer.Naam == "" {
+			customer.Naam, customer.Telefoon = appointment.Naam, appointment.Telefoon
+		}
+		customer.Adres = appointment.Adres
+	}
+	return customer
+}
+
+// accountLogoutHandler handles POST /account/uitloggen.
+func accountLogoutHandler(c *gin.Context) {
+	if err := customerSessions.end(c); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/account/inloggen")
+}
+
+// AccountAppointment is an appointment as listed in the portal.
+type AccountAppointment struct {
+	Appointment
+	Type       AppointmentType
+	CancelLink string // empty once it can no longer be cancelled
+}
+
+// accountHandler handles GET /account: everything we have on record for
+// the customer's address.
+func accountHandler(c *gin.Context) {
+	customer := c.MustGet("customer").(Customer)
+	var contacts []Contact
+	var tickets []Ticket
+	var quotes []Quote
+	var appointments []Appointment
+	err := errors.Join(
+		byEmail(db.Preload("Attachments"), customer.Email).Order("created_at DESC").Find(&contacts).Error,
+		byEmail(db, customer.Email).Order("created_at DESC").Find(&tickets).Error,
+		expireQuotes(db),
+		db.Preload("Lines").Where("lower(email) = ? AND status <> ?", customer.Email, quoteDraft).Order("created_at DESC").Find(&quotes).Error,
+		db.Where("lower(email) = ?", customer.Email).Order("starts_at DESC").Find(&appointments).Error,
+	)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	ticketLinks := make(map[uint]string)
+	for _, t := range tickets {
+		ticketLinks[t.ID] = ticketLink(t)
+	}
+	quoteLinks := make(map[uint]string)
+	for _, q := range quotes {
+		quoteLinks[q.ID] = quoteLink(q)
+	}
+	var listed []AccountAppointment
+	for _, a := range appointments {
+		item := AccountAppointment{Appointment: a}
+		item.Type, _ = booking.appointmentType(a.Type)
+		if a.Status != appointmentCancelled && a.StartsAt.After(time.Now()) {
+			item.CancelLink = appointmentLink(tokenAppointmentCancel, a)
+		}
+		listed = append(listed, item)
+	}
+	c.HTML(http.StatusOK, "account", privatePage("/account", "Mijn account", "account", gin.H{
+		"Customer":     customer,
+		"Contacts":     contacts,
+		"Tickets":      tickets,
+		"TicketLinks":  ticketLinks,
+		"Quotes":       quotes,
+		"QuoteLinks":   quoteLinks,
+		"Appointments": listed,
+	}))
+}
+
+// accountProfileHandler handles GET and POST /account/profiel.
+func accountProfileHandler(c *gin.Context) {
+	customer := c.MustGet("customer").(Customer)
+	data := gin.H{"Customer": customer}
+	if c.Request.Method == http.MethodPost {
+		customer.Naam = strings.TrimSpace(c.PostForm("naam"))
+		customer.Bedrijf = strings.TrimSpace(c.PostForm("bedrijf"))
+		customer.Telefoon = strings.TrimSpace(c.PostForm("telefoon"))
+		customer.Adres = strings.TrimSpace(c.PostForm("adres"))
+		data["Customer"] = customer
+		if customer.Naam == "" {
+			data["Error"] = "Vul uw naam in."
+			c.HTML(http.StatusBadRequest, "account-profiel", privatePage("/account/profiel", "Mijn gegevens", "account-profiel", data))
+			return
+		}
+		err := db.Model(&customer).Select("naam", "bedrijf", "telefoon", "adres").Updates(&customer).Error
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		data["Notice"] = "Uw gegevens zijn opgeslagen."
+	}
+	c.HTML(http.StatusOK, "account-profiel", privatePage("/account/profiel", "Mijn gegevens", "account-profiel", data))
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+)
+
+// testPages renders every page as its template name.
+type testPages struct{}
+
+func (testPages) Instance(name string, _ any) render.Render {
+	return render.Data{ContentType: "text/html; charset=utf-8", Data: []byte(name)}
+}
+
+// testRequest returns a context for a request to target with the given
+// cookies, rendering pages with testPages.
+func testRequest(method, target string, cookies ...*http.Cookie) (*gin.Context, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	c, r := gin.CreateTestContext(w)
+	r.HTMLRender = testPages{}
+	c.Request = httptest.NewRequest(method, target, nil)
+	for _, cookie := range cookies {
+		c.Request.AddCookie(cookie)
+	}
+	return c, w
+}
+
+// followLoginLink opens (GET) or uses (POST) a login link and returns the
+// response.
+func followLoginLink(method, token string) *httptest.ResponseRecorder {
+	c, w := testRequest(method, "/account/inloggen/link?token="+token)
+	accountLinkHandler(c)
+	c.Writer.WriteHeaderNow() // as gin does after the handlers
+	return w
+}
+
+func sessionCookie(w *httptest.ResponseRecorder) *http.Cookie {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == customerSessions.Cookie && cookie.Value != "" {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestLoginLinkSingleUse(t *testing.T) {
+	testDB(t, &Customer{}, &Session{}, &Contact{}, &Attachment{}, &Appointment{})
+	testSecret(t)
+	earlier := loginToken("jan@example.nl")
+	time.Sleep(2 * time.Millisecond) // tokens carry their issue time in milliseconds
+	link := loginToken("jan@example.nl")
+
+	// mail scanners that open the link do not use it up
+	if w := followLoginLink("GET", link); w.Code != http.StatusOK || sessionCookie(w) != nil {
+		t.Fatalf("opening the link: status %d, session %v", w.Code, sessionCookie(w))
+	}
+	w := followLoginLink("POST", link)
+	if w.Code != http.StatusSeeOther || sessionCookie(w) == nil {
+		t.Fatalf("logging in: status %d, session %v", w.Code, sessionCookie(w))
+	}
+	if w := followLoginLink("POST", link); w.Code != http.StatusForbidden || sessionCookie(w) != nil {
+		t.Errorf("using the link again: status %d, session %v", w.Code, sessionCookie(w))
+	}
+	if w := followLoginLink("POST", earlier); w.Code != http.StatusForbidden || sessionCookie(w) != nil {
+		t.Errorf("using an earlier link: status %d, session %v", w.Code, sessionCookie(w))
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	if w := followLoginLink("POST", loginToken("jan@example.nl")); w.Code != http.StatusSeeOther {
+		t.Errorf("a new link: status %d", w.Code)
+	}
+	var customers []Customer
+	if err := db.Find(&customers).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(customers) != 1 || customers[0].Email != "jan@example.nl" || customers[0].LastLoginAt == nil {
+		t.Errorf("customers: %+v", customers)
+	}
+}
+
+func TestLoginLinkExpired(t *testing.T) {
+	testDB(t, &Customer{}, &Session{}, &Contact{}, &Attachment{}, &Appointment{})
+	testSecret(t)
+	issued := time.Now().Add(-accountLinkTTL - time.Minute)
+	subject := strconv.FormatInt(issued.UnixMilli(), 10) + "|jan@example.nl"
+	payload := base64.RawURLEncoding.EncodeToString([]byte(subject)) + "." + strconv.FormatInt(issued.Add(accountLinkTTL).Unix(), 36)
+	expired := payload + "." + tokenMAC(tokenAccountLogin, payload)
+	if _, _, err := verifyLoginToken(expired); !errors.Is(err, errTokenExpired) {
+		t.Fatalf("verifyLoginToken: err = %v, want %v", err, errTokenExpired)
+	}
+
+	for _, token := range []string{expired, "", alterMiddle(loginToken("jan@example.nl")), signToken("afspraak", subject, time.Hour)} {
+		if w := followLoginLink("POST", token); w.Code != http.StatusForbidden || sessionCookie(w) != nil {
+			t.Errorf("link %q: status %d, session %v", token, w.Code, sessionCookie(w))
+		}
+	}
+	var n int64
+	if err := db.Model(&Customer{}).Count(&n).Error; err != nil || n != 0 {
+		t.Errorf("%d customers, %v, want none", n, err)
+	}
+}
+
+func TestCustomerSessions(t *testing.T) {
+	testDB(t, &Customer{}, &Session{}, &Contact{}, &Attachment{}, &Appointment{})
+	testSecret(t)
+	w := followLoginLink("POST", loginToken("jan@example.nl"))
+	cookie := sessionCookie(w)
+	if cookie == nil {
+		t.Fatalf("no session after logging in: status %d", w.Code)
+	}
+	sessionOf := func(cookie *http.Cookie) (Session, bool) {
+		c, _ := testRequest("GET", "/account", cookie)
+		return customerSessions.current(c)
+	}
+	current := func() (Session, bool) { return sessionOf(cookie) }
+	s, ok := current()
+	if !ok {
+		t.Fatal("session not found")
+	}
+	if _, ok := sessionOf(&http.Cookie{Name: cookie.Name, Value: alterMiddle(cookie.Value)}); ok {
+		t.Error("session found with another token")
+	}
+
+	// in use, the session is extended
+	lastSeen := time.Now().UTC().Add(-time.Hour)
+	if err := db.Model(&s).Updates(map[string]any{"last_seen_at": lastSeen, "expires_at": lastSeen.Add(customerSessions.Idle)}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := current(); !ok || !s.ExpiresAt.After(lastSeen.Add(customerSessions.Idle)) {
+		t.Errorf("session not extended: %v, expires %s", ok, s.ExpiresAt)
+	}
+
+	// idle for too long, it has expired
+	expired := time.Now().UTC().Add(-time.Minute)
+	if err := db.Model(&s).Updates(map[string]any{"last_seen_at": expired.Add(-customerSessions.Idle), "expires_at": expired}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := current(); ok {
+		t.Error("expired session still valid")
+	}
+	if err := deleteExpiredSessions(); err != nil {
+		t.Fatal(err)
+	}
+	var n int64
+	if err := db.Model(&Session{}).Count(&n).Error; err != nil || n != 0 {
+		t.Errorf("%d sessions after deleting expired ones, %v", n, err)
+	}
+
+	// logging out ends the session
+	time.Sleep(2 * time.Millisecond)
+	if cookie = sessionCookie(followLoginLink("POST", loginToken("jan@example.nl"))); cookie == nil {
+		t.Fatal("no session after logging in again")
+	}
+	c, _ := testRequest("POST", "/account/uitloggen", cookie)
+	if err := customerSessions.end(c); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := current(); ok {
+		t.Error("session still valid after logging out")
+	}
+}
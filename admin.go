@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminGroup returns the router group for the admin area under /admin. It
+// holds the login pages; the rest of the admin area goes in a group with
+// requireAdmin.
+func adminGroup(r *gin.Engine) *gin.RouterGroup {
+	return r.Group("/admin", adminHeaders, sameOriginPosts)
+}
+
+// adminHeaders keeps admin pages out of caches and search engines.
+func adminHeaders(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Robots-Tag", "noindex, nofollow")
+	c.Header("X-Frame-Options", "DENY")
+}
+
+// sameOriginPosts rejects state-changing requests that come from another
+// site. Browsers may send the session cookie along with such requests, so
+// without this check another site could submit forms on our behalf.
+func sameOriginPosts(c *gin.Context) {
+	if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+		return
+	}
+	origin := c.GetHeader("Origin")
+	if origin == "" {
+		origin = c.GetHeader("Referer")
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host != c.Request.Host {
+		c.AbortWithStatus(http.StatusForbidden)
+	}
+}
+
+// AdminPage is the data passed to the admin templates.
+type AdminPage struct {
+	Title   string
+	Section string     // highlights the current item in the admin menu
+	Flash   string     // message shown once after a redirect
+	User    *AdminUser // nil on the login pages
+	Data    any
+}
+
+// renderAdmin renders one of the admin/ templates.
+func renderAdmin(c *gin.Context, status int, page, section, title string, data any) {
+	c.HTML(status, "admin/"+page, AdminPage{
+		Title:   title,
+		Section: section,
+		Flash:   c.Query("melding"),
+		User:    adminPageUser(c),
+		Data:    data,
+	})
+}
+
+// redirectAdmin redirects after a successful form post, with a message
+// for the next page.
+func redirectAdmin(c *gin.Context, path, flash string) {
+	if flash != "" {
+		path += "?melding=" + url.QueryEscape(flash)
+	}
+	c.Redirect(http.StatusSeeOther, path)
+}
+
+// adminContactsHandler handles GET /admin/contacten: the latest contact form
+// submissions, with the quotes and tickets made for them.
+func adminContactsHandler(c *gin.Context) {
+	var contacts []Contact
+	if err := db.Preload("Attachments").Order("created_at DESC").Limit(200).Find(&contacts).Error; err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	var quotes []Quote
+	if err := db.Where("contact_id IS NOT NULL").Find(&quotes).Error; err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	quotesByContact := make(map[uint][]Quote)
+	for _, q := range quotes {
+		quotesByContact[*q.ContactID] = append(quotesByContact[*q.ContactID], q)
+	}
+	var tickets []Ticket
+	if err := db.Where("contact_id IS NOT NULL").Find(&tickets).Error; err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	ticketByContact := make(map[uint]Ticket)
+	for _, t := range tickets {
+		ticketByContact[*t.ContactID] = t
+	}
+	var consents []ConsentRecord
+	if err := db.Where("contact_id IS NOT NULL").Order("id").Find(&consents).Error; err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	consentsByContact := make(map[uint][]ConsentRecord)
+	for _, r := range consents {
+		consentsByContact[*r.ContactID] = append(consentsByContact[*r.ContactID], r)
+	}
+	renderAdmin(c, http.StatusOK, "contacten", "contacten", "Contactaanvragen", gin.H{
+		"Contacts": contacts,
+		"Quotes":   quotesByContact,
+		"Tickets":  ticketByContact,
+		"Consents": consentsByContact,
+	})
+}
@@ -0,0 +1,540 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// adminSessions are the sessions of logged-in admin users. The cookie is
+// only sent with requests from the site itself, and lapses after two hours
+// without activity.
+var adminSessions = sessionKind{
+	Name:     "beheer",
+	Cookie:   "beheer_sessie",
+	Path:     "/admin",
+	Idle:     2 * time.Hour,
+	SameSite: http.SameSiteStrictMode,
+}
+
+const (
+	// tokenAdminLogin signs the cookie that carries a user from the
+	// password to the second step of logging in.
+	tokenAdminLogin    = "beheer-inloggen"
+	adminLoginCookie   = "beheer_inloggen"
+	adminLoginStepTTL  = 10 * time.Minute
+	adminMaxFailures   = 5 // consecutive failures before the account is locked
+	adminLockout       = 15 * time.Minute
+	adminMaxIPFailures = 10 // failures from one IP address per adminLockout
+)
+
+// AdminLoginAttempt is one password or code entered at the admin login,
+// for throttling by IP address and for looking into break-in attempts.
+type AdminLoginAttempt struct {
+	ID        uint   `gorm:"primaryKey"`
+	Username  string `gorm:"not null"`
+	IP        string `gorm:"not null;index"`
+	Success   bool
+	CreatedAt time.Time `gorm:"index"`
+}
+
+// adminUser returns the logged-in admin user of the request, as set by
+// requireAdmin.
+func adminUser(c *gin.Context) (AdminUser, bool) {
+	v, ok := c.Get("admin")
+	if !ok {
+		return AdminUser{}, false
+	}
+	u, ok := v.(AdminUser)
+	return u, ok
+}
+
+// currentAdmin returns the admin user of the session, if it is still
+// allowed in.
+func currentAdmin(c *gin.Context) (AdminUser, bool) {
+	s, ok := adminSessions.current(c)
+	if !ok {
+		return AdminUser{}, false
+	}
+	var u AdminUser
+	if err := db.First(&u, s.UserID).Error; err != nil || u.DisabledAt != nil || !u.TOTPEnabled {
+		return AdminUser{}, false
+	}
+	return u, true
+}
+
+// requireAdmin sends visitors without a session to the login page. Users
+// with a generated password cannot go anywhere but their account page
+// until they have chosen one of their own.
+func requireAdmin(c *gin.Context) {
+	u, ok := currentAdmin(c)
+	if !ok {
+		c.Redirect(http.StatusSeeOther, "/admin/inloggen")
+		c.Abort()
+		return
+	}
+	c.Set("admin", u)
+	if u.PasswordReset && !strings.HasPrefix(c.Request.URL.Path, "/admin/account") {
+		redirectAdmin(c, "/admin/account", "Kies eerst een eigen wachtwoord.")
+		c.Abort()
+	}
+}
+
+// requireRole allows only users with the given role.
+func requireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if u, _ := adminUser(c); u.Role != role {
+			c.AbortWithStatus(http.StatusForbidden)
+		}
+	}
+}
+
+// renderAdminLogin renders the login page at step wachtwoord, code or
+// koppelen (setting up the authenticator app).
+func renderAdminLogin(c *gin.Context, status int, step string, data gin.H) {
+	data["Step"] = step
+	data["MaxFailures"] = adminMaxFailures
+	data["Lockout"] = int(adminLockout.Minutes())
+	renderAdmin(c, status, "inloggen", "inloggen", "Inloggen", data)
+}
+
+// adminLoginHandler handles GET /admin/inloggen.
+func adminLoginHandler(c *gin.Context) {
+	if _, ok := currentAdmin(c); ok {
+		c.Redirect(http.StatusSeeOther, "/admin/")
+		return
+	}
+	renderAdminLogin(c, http.StatusOK, "wachtwoord", gin.H{})
+}
+
+// errLoginFailed is the answer to every failed login, so the form does not
+// reveal which usernames exist or which accounts are locked.
+var errLoginFailed = errors.New("login failed")
+
+// adminLoginPostHandler handles POST /admin/inloggen, the password step.
+func adminLoginPostHandler(c *gin.Context) {
+	username := strings.ToLower(strings.TrimSpace(c.PostForm("gebruikersnaam")))
+	if throttled(c) {
+		return
+	}
+	var u AdminUser
+	err := db.Where("username = ?", username).First(&u).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// take as long as for an existing user
+		checkPassword(dummyPasswordHash, c.PostForm("wachtwoord"))
+		err = errLoginFailed
+	} else if err == nil {
+		var ok bool
+		if ok, err = checkPassword(u.PasswordHash, c.PostForm("wachtwoord")); err == nil && (!ok || !loginAllowed(u)) {
+			err = errLoginFailed
+		}
+	}
+	if err := loginAttempt(c, username, u, err == nil); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if errors.Is(err, errLoginFailed) {
+		renderAdminLogin(c, http.StatusUnauthorized, "wachtwoord", gin.H{"Error": true, "Username": username})
+		return
+	}
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	setAdminLoginCookie(c, signID(tokenAdminLogin, u.ID, adminLoginStepTTL), int(adminLoginStepTTL.Seconds()))
+	if u.TOTPEnabled {
+		c.Redirect(http.StatusSeeOther, "/admin/inloggen/code")
+	} else {
+		c.Redirect(http.StatusSeeOther, "/admin/inloggen/koppelen")
+	}
+}
+
+func loginAllowed(u AdminUser) bool {
+	return u.DisabledAt == nil && !u.Locked()
+}
+
+// throttled answers the request and reports true if too many logins failed
+// from its IP address lately.
+func throttled(c *gin.Context) bool {
+	var n int64
+	err := db.Model(&AdminLoginAttempt{}).
+		Where("ip = ? AND NOT success AND created_at > ?", c.ClientIP(), time.Now().Add(-adminLockout).UTC()).
+		Count(&n).Error
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return true
+	}
+	if n >= adminMaxIPFailures {
+		renderAdminLogin(c, http.StatusTooManyRequests, "wachtwoord", gin.H{"Throttled": true})
+		return true
+	}
+	return false
+}
+
+// loginAttempt records an attempt to log in as u (or an unknown username).
+// After adminMaxFailures failures in a row the account is locked for a
+// while; a success starts the count over.
+func loginAttempt(c *gin.Context, username string, u AdminUser, success bool) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&AdminLoginAttempt{Username: username, IP: c.ClientIP(), Success: success}).Error; err != nil {
+			return err
+		}
+		if u.ID == 0 || success {
+			return nil
+		}
+		updates := map[string]any{"failed_logins": gorm.Expr("failed_logins + 1")}
+		if u.FailedLogins+1 >= adminMaxFailures {
+			updates = map[string]any{"failed_logins": 0, "locked_until": time.Now().Add(adminLockout).UTC()}
+		}
+		return tx.Model(&u).UpdateColumns(updates).Error
+	})
+}
+
+func setAdminLoginCookie(c *gin.Context, value string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     adminLoginCookie,
+		Value:    value,
+		Path:     "/admin/inloggen",
+		MaxAge:   maxAge,
+		Secure:   secureCookies(),
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// pendingAdmin returns the user who passed the password step, if they may
+// still log in.
+func pendingAdmin(c *gin.Context) (AdminUser, bool) {
+	token, err := c.Cookie(adminLoginCookie)
+	if err != nil {
+		return AdminUser{}, false
+	}
+	id, err := verifyID(tokenAdminLogin, token)
+	if err != nil {
+		return AdminUser{}, false
+	}
+	var u AdminUser
+	if err := db.First(&u, id).Error; err != nil || !loginAllowed(u) {
+		return AdminUser{}, false
+	}
+	return u, true
+}
+
+// restartLogin sends the user back to the password step.
+func restartLogin(c *gin.Context) {
+	setAdminLoginCookie(c, "", -1)
+	renderAdminLogin(c, http.StatusUnauthorized, "wachtwoord", gin.H{"Expired": true})
+}
+
+// adminLoginCodeHandler handles GET and POST /admin/inloggen/code: the code
+// from the authenticator app, or one of the recovery codes.
+func adminLoginCodeHandler(c *gin.Context) {
+	u, ok := pendingAdmin(c)
+	if !ok || !u.TOTPEnabled {
+		restartLogin(c)
+		return
+	}
+	if c.Request.Method == http.MethodGet {
+		renderAdminLogin(c, http.StatusOK, "code", gin.H{})
+		return
+	}
+	if throttled(c) {
+		return
+	}
+	code := strings.TrimSpace(c.PostForm("code"))
+	method := "app"
+	var recovery bool
+	step := checkTOTP(u.TOTPSecret, code, u.TOTPLastStep, time.Now())
+	// The code is claimed in the transaction that logs the user in, so two
+	// requests with the same code cannot both succeed.
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var ok bool
+		var err error
+		switch {
+		case step != 0:
+			ok, err = claimTOTPStep(tx, u.ID, step)
+			u.TOTPLastStep = step
+		case len(code) > totpDigits:
+			ok, err = useRecoveryCode(tx, u.ID, code)
+			recovery, method = ok, "herstelcode"
+		}
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errLoginFailed
+		}
+		return finishLogin(tx, c, &u, auditChanges{"Methode": {nil, method}})
+	})
+	if err != nil && !errors.Is(err, errLoginFailed) {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if err := loginAttempt(c, u.Username, u, err == nil); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if err != nil {
+		renderAdminLogin(c, http.StatusUnauthorized, "code", gin.H{"Error": true})
+		return
+	}
+	if err := startAdminSession(c, u); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if recovery {
+		left, err := unusedRecoveryCodes(u.ID)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		redirectAdmin(c, "/admin/account", fmt.Sprintf("U bent ingelogd met een herstelcode; u heeft er nog %d over.", left))
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/admin/")
+}
+
+// finishLogin records the login of u. The session is started once the
+// transaction has been committed, see startAdminSession.
+func finishLogin(tx *gorm.DB, c *gin.Context, u *AdminUser, changes auditChanges) error {
+	now := time.Now().UTC()
+	u.LastLoginAt, u.FailedLogins, u.LockedUntil = &now, 0, nil
+	err := tx.Model(u).Select("totp_secret", "totp_enabled", "last_login_at", "failed_logins", "locked_until").
+		Updates(u).Error
+	if err != nil {
+		return err
+	}
+	c.Set("admin", *u)
+	return recordAudit(tx, c, "beheerder.ingelogd", "beheerder", fmt.Sprint(u.ID), changes)
+}
+
+// startAdminSession logs u in, replacing the cookie of the login steps.
+func startAdminSession(c *gin.Context, u AdminUser) error {
+	if err := deleteExpiredSessions(); err != nil {
+		return err
+	}
+	setAdminLoginCookie(c, "", -1)
+	return adminSessions.start(c, u.ID)
+}
+
+// adminEnrolHandler handles GET and POST /admin/inloggen/koppelen, where a
+// user without two-factor authentication sets up the authenticator app.
+// The secret is stored before it is confirmed, so reloading the page shows
+// the same QR code.
+func adminEnrolHandler(c *gin.Context) {
+	u, ok := pendingAdmin(c)
+	if !ok || u.TOTPEnabled {
+		restartLogin(c)
+		return
+	}
+	if u.TOTPSecret == "" {
+		secret, err := newTOTPSecret()
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		u.TOTPSecret = secret
+		if err := db.Model(&u).Select("totp_secret").Updates(&u).Error; err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+	}
+	qrCode, err := qrDataURI(totpURI(u, u.TOTPSecret))
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	data := gin.H{"QRCode": qrCode, "Secret": groupSecret(u.TOTPSecret)}
+	if c.Request.Method == http.MethodGet {
+		renderAdminLogin(c, http.StatusOK, "koppelen", data)
+		return
+	}
+	if throttled(c) {
+		return
+	}
+	step := checkTOTP(u.TOTPSecret, c.PostForm("code"), u.TOTPLastStep, time.Now())
+	var codes []string
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if step == 0 {
+			return errLoginFailed
+		}
+		ok, err := claimTOTPStep(tx, u.ID, step)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errLoginFailed
+		}
+		u.TOTPEnabled, u.TOTPLastStep = true, step
+		if codes, err = newRecoveryCodes(tx, u.ID); err != nil {
+			return err
+		}
+		if err := finishLogin(tx, c, &u, auditChanges{"Methode": {nil, "app"}}); err != nil {
+			return err
+		}
+		return recordAudit(tx, c, "account.tweestaps", "beheerder", fmt.Sprint(u.ID), auditChanges{"TOTPEnabled": {false, true}})
+	})
+	if err != nil && !errors.Is(err, errLoginFailed) {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if err := loginAttempt(c, u.Username, u, err == nil); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if err != nil {
+		data["Error"] = true
+		renderAdminLogin(c, http.StatusUnauthorized, "koppelen", data)
+		return
+	}
+	if err := startAdminSession(c, u); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	renderRecoveryCodes(c, u, codes)
+}
+
+// groupSecret splits a base32 secret into groups of four, for typing it
+// over into an app.
+func groupSecret(secret string) string {
+	var groups []string
+	for len(secret) > 4 {
+		groups = append(groups, secret[:4])
+		secret = secret[4:]
+	}
+	return strings.Join(append(groups, secret), " ")
+}
+
+func renderRecoveryCodes(c *gin.Context, u AdminUser, codes []string) {
+	next := "/admin/"
+	if u.PasswordReset {
+		next = "/admin/account"
+	}
+	renderAdmin(c, http.StatusOK, "herstelcodes", "account", "Herstelcodes", gin.H{"Codes": codes, "Next": next})
+}
+
+// adminLogoutHandler handles POST /admin/uitloggen.
+func adminLogoutHandler(c *gin.Context) {
+	if err := adminSessions.end(c); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	redirectAdmin(c, "/admin/inloggen", "U bent uitgelogd.")
+}
+
+// adminAccountHandler handles GET /admin/account: the user's own password
+// and recovery codes.
+func adminAccountHandler(c *gin.Context) {
+	renderAdminAccount(c, http.StatusOK, gin.H{})
+}
+
+func renderAdminAccount(c *gin.Context, status int, data gin.H) {
+	u, _ := adminUser(c)
+	left, err := unusedRecoveryCodes(u.ID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	data["Account"] = u
+	data["RecoveryCodes"] = left
+	data["MinLength"] = minPasswordLength
+	renderAdmin(c, status, "account", "account", "Mijn account", data)
+}
+
+// checkCurrentPassword renders the account page with an error and returns
+// false unless the form holds the user's current password.
+func checkCurrentPassword(c *gin.Context, u AdminUser) bool {
+	ok, err := checkPassword(u.PasswordHash, c.PostForm("huidig"))
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return false
+	}
+	if !ok {
+		renderAdminAccount(c, http.StatusUnauthorized, gin.H{"Error": "Uw huidige wachtwoord klopt niet."})
+	}
+	return ok
+}
+
+// adminPasswordHandler handles POST /admin/account/wachtwoord. Changing the
+// password ends the user's other sessions.
+func adminPasswordHandler(c *gin.Context) {
+	u, _ := adminUser(c)
+	if !checkCurrentPassword(c, u) {
+		return
+	}
+	password := c.PostForm("nieuw")
+	if err := validPassword(u, password); err != nil {
+		renderAdminAccount(c, http.StatusBadRequest, gin.H{"Error": "Het nieuwe wachtwoord is niet geschikt: " + err.Error() + "."})
+		return
+	}
+	if password != c.PostForm("herhaal") {
+		renderAdminAccount(c, http.StatusBadRequest, gin.H{"Error": "De twee nieuwe wachtwoorden zijn niet gelijk."})
+		return
+	}
+	if same, _ := checkPassword(u.PasswordHash, password); same {
+		renderAdminAccount(c, http.StatusBadRequest, gin.H{"Error": "Kies een ander wachtwoord dan het huidige."})
+		return
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	wasReset := u.PasswordReset
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&u).Select("password_hash", "password_reset").
+			Updates(map[string]any{"password_hash": hash, "password_reset": false}).Error
+		if err != nil {
+			return err
+		}
+		if err := adminSessions.endAll(tx, u.ID); err != nil {
+			return err
+		}
+		return recordAudit(tx, c, "account.wachtwoord", "beheerder", fmt.Sprint(u.ID), auditChanges{"PasswordReset": {wasReset, false}})
+	})
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if err := adminSessions.start(c, u.ID); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	redirectAdmin(c, "/admin/account", "Uw wachtwoord is gewijzigd. U bent op andere apparaten uitgelogd.")
+}
+
+// adminRecoveryCodesHandler handles POST /admin/account/herstelcodes: new
+// recovery codes, which replace the old ones.
+func adminRecoveryCodesHandler(c *gin.Context) {
+	u, _ := adminUser(c)
+	if !checkCurrentPassword(c, u) {
+		return
+	}
+	var codes []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if codes, err = newRecoveryCodes(tx, u.ID); err != nil {
+			return err
+		}
+		return recordAudit(tx, c, "account.herstelcodes", "beheerder", fmt.Sprint(u.ID), auditChanges{"Herstelcodes": {nil, len(codes)}})
+	})
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	renderRecoveryCodes(c, u, codes)
+}
+
+// adminPageUser is the user shown in the admin layout, nil on the login
+// pages.
+func adminPageUser(c *gin.Context) *AdminUser {
+	if u, ok := adminUser(c); ok {
+		return &u
+	}
+	return nil
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/argon2"
+	"gorm.io/gorm"
+)
+
+// AdminUser is a member of staff with access to the admin area. Logging in
+// takes the password and a code from an authenticator app (TOTP); a user
+// without one sets it up right after their first password login.
+type AdminUser struct {
+	ID           uint   `gorm:"primaryKey"`
+	Username     string `gorm:"not null;uniqueIndex"` // lower case
+	Naam         string `gorm:"not null"`
+	Role         string `gorm:"not null"`
+	PasswordHash string `json:"-" gorm:"not null"` // argon2id, see hashPassword
+
+	// PasswordReset is set for passwords made up by the system, which the
+	// user has to replace before doing anything else.
+	PasswordReset bool
+
+	TOTPSecret   string `json:"-" gorm:"serializer:encrypted"` // base32; set during enrolment
+	TOTPEnabled  bool   // the secret has been confirmed with a code
+	TOTPLastStep int64  `json:"-"` // last time step accepted, so a code cannot be used twice
+
+	FailedLogins int // consecutive failed attempts, see adminLockout
+	LockedUntil  *time.Time
+	LastLoginAt  *time.Time
+	DisabledAt   *time.Time
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// Admin roles. Owners can do everything; staff cannot handle privacy
+// requests, read the audit log, send campaigns or manage users.
+const (
+	roleOwner = "eigenaar"
+	roleStaff = "medewerker"
+)
+
+var adminRoles = []string{roleOwner, roleStaff}
+
+// Owner reports whether the user has the owner role.
+func (u AdminUser) Owner() bool {
+	return u.Role == roleOwner
+}
+
+// DisplayName is the name shown to customers and in ticket threads.
+func (u AdminUser) DisplayName() string {
+	if u.Naam != "" {
+		return u.Naam
+	}
+	return u.Username
+}
+
+// Locked reports whether the account is locked after failed logins.
+func (u AdminUser) Locked() bool {
+	return u.LockedUntil != nil && u.LockedUntil.After(time.Now())
+}
+
+// Argon2id parameters, the second recommendation of the OWASP password
+// storage cheat sheet: 19 MiB, two passes, one thread.
+const (
+	argonMemory  = 19 * 1024
+	argonTime    = 2
+	argonThreads = 1
+	argonKeyLen  = 32
+)
+
+// minPasswordLength is the length the OWASP guidelines ask for when a
+// password is the first of two factors.
+const minPasswordLength = 12
+
+var (
+	errWeakPassword = fmt.Errorf("kies een wachtwoord van minstens %d tekens", minPasswordLength)
+	errBadHash      = errors.New("unsupported password hash")
+)
+
+// hashPassword returns the argon2id hash of password in the usual encoding:
+// $argon2id$v=19$m=...,t=...,p=...$salt$key.
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argonMemory, argonTime, argonThreads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// checkPassword reports whether password matches hash. The parameters are
+// read from the hash, so hashes made with older settings keep working.
+func checkPassword(hash, password string) (bool, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" || parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return false, errBadHash
+	}
+	var memory, passes uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &passes, &threads); err != nil {
+		return false, errBadHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, errBadHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, errBadHash
+	}
+	got := argon2.IDKey([]byte(password), salt, passes, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(got, key) == 1, nil
+}
+
+// dummyPasswordHash is checked against for unknown users, so a login
+// attempt takes as long whether or not the user exists.
+var dummyPasswordHash, _ = hashPassword("geen gebruiker")
+
+// validPassword checks a password chosen by a user.
+func validPassword(u AdminUser, password string) error {
+	if len([]rune(password)) < minPasswordLength {
+		return errWeakPassword
+	}
+	if strings.EqualFold(password, u.Username) {
+		return errors.New("kies een wachtwoord dat niet gelijk is aan uw gebruikersnaam")
+	}
+	return nil
+}
+
+// generatePassword makes up a password for a new user or a reset, to be
+// passed on in person and replaced at the first login.
+func generatePassword() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+var usernamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{1,31}$`)
+
+// seedAdminUser creates the first owner from ADMIN_USER and ADMIN_PASSWORD,
+// the credentials of the admin area before it had user accounts. Once any
+// user exists the variables are not used any more.
+func seedAdminUser() error {
+	var n int64
+	if err := db.Model(&AdminUser{}).Count(&n).Error; err != nil {
+		return err
+	}
+	user, password := os.Getenv("ADMIN_USER"), os.Getenv("ADMIN_PASSWORD")
+	if n > 0 {
+		if password != "" {
+			log.Print("ADMIN_PASSWORD is no longer used now that admin users exist; remove it")
+		}
+		return nil
+	}
+	if password == "" {
+		log.Print("no admin users yet: set ADMIN_USER and ADMIN_PASSWORD to create the first owner")
+		return nil
+	}
+	if user == "" {
+		user = "admin"
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	u := AdminUser{Username: strings.ToLower(user), Naam: user, Role: roleOwner, PasswordHash: hash}
+	if err := db.Create(&u).Error; err != nil {
+		return err
+	}
+	log.Printf("admin: owner %q created from ADMIN_USER and ADMIN_PASSWORD; set up two-factor authentication at the first login", u.Username)
+	return nil
+}
+
+// activeOwners counts the owners who can still log in, to keep at least one.
+func activeOwners(tx *gorm.DB) (int64, error) {
+	var n int64
+	err := tx.Model(&AdminUser{}).Where("role = ? AND disabled_at IS NULL", roleOwner).Count(&n).Error
+	return n, err
+}
+
+var errLastOwner = errors.New("er moet minstens één actieve eigenaar blijven")
+
+// adminUsersHandler handles GET /admin/gebruikers.
+func adminUsersHandler(c *gin.Context) {
+	renderAdminUsers(c, http.StatusOK, gin.H{})
+}
+
+func renderAdminUsers(c *gin.Context, status int, data gin.H) {
+	var users []AdminUser
+	if err := db.Order("disabled_at IS NOT NULL, username").Find(&users).Error; err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	data["Users"] = users
+	data["Roles"] = adminRoles
+	renderAdmin(c, status, "gebruikers", "gebruikers", "Gebruikers", data)
+}
+
+// adminCreateUserHandler handles POST /admin/gebruikers. The new user gets
+// a generated password, shown once, which they replace at their first login.
+func adminCreateUserHandler(c *gin.Context) {
+	u := AdminUser{
+		Username:      strings.ToLower(strings.TrimSpace(c.PostForm("gebruikersnaam"))),
+		Naam:          strings.TrimSpace(c.PostForm("naam")),
+		Role:          c.PostForm("rol"),
+		PasswordReset: true,
+	}
+	switch {
+	case !usernamePattern.MatchString(u.Username):
+		renderAdminUsers(c, http.StatusBadRequest, gin.H{"Error": "Kies een gebruikersnaam van 2 tot 32 kleine letters, cijfers, punten of streepjes.", "New": u})
+		return
+	case u.Naam == "":
+		renderAdminUsers(c, http.StatusBadRequest, gin.H{"Error": "Vul de naam in.", "New": u})
+		return
+	case !slices.Contains(adminRoles, u.Role):
+		renderAdminUsers(c, http.StatusBadRequest, gin.H{"Error": "Kies een rol.", "New": u})
+		return
+	}
+	password, err := generatePassword()
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if u.PasswordHash, err = hashPassword(password); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var n int64
+		if err := tx.Model(&AdminUser{}).Where("username = ?", u.Username).Count(&n).Error; err != nil {
+			return err
+		}
+		if n > 0 {
+			return errUsernameTaken
+		}
+		if err := tx.Create(&u).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, "beheerder.aangemaakt", "beheerder", fmt.Sprint(u.ID), auditDiff(nil, auditFields(u)))
+	})
+	switch {
+	case errors.Is(err, errUsernameTaken):
+		renderAdminUsers(c, http.StatusConflict, gin.H{"Error": "Die gebruikersnaam is al in gebruik.", "New": u})
+	case err != nil:
+		c.AbortWithError(http.StatusInternalServerError, err)
+	default:
+		renderAdminUsers(c, http.StatusOK, gin.H{"Created": u, "Password": password})
+	}
+}
+
+var errUsernameTaken = errors.New("username taken")
+
+// adminUpdateUserHandler handles POST /admin/gebruikers/:id. actie is one
+// of rol, uitschakelen, inschakelen, ontgrendelen, tweestaps (the user sets
+// up two-factor authentication again) and wachtwoord (a new generated
+// password).
+func adminUpdateUserHandler(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	var u AdminUser
+	if err := db.First(&u, id).Error; err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	before := auditFields(u)
+	var action, flash, password string
+	now := time.Now().UTC()
+	switch c.PostForm("actie") {
+	case "rol":
+		role := c.PostForm("rol")
+		if !slices.Contains(adminRoles, role) {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		u.Role = role
+		action, flash = "beheerder.gewijzigd", "Rol van "+u.Username+" gewijzigd in "+role+"."
+	case "uitschakelen":
+		u.DisabledAt = &now
+		action, flash = "beheerder.gewijzigd", u.Username+" kan niet meer inloggen."
+	case "inschakelen":
+		u.DisabledAt = nil
+		action, flash = "beheerder.gewijzigd", u.Username+" kan weer inloggen."
+	case "ontgrendelen":
+		u.FailedLogins, u.LockedUntil = 0, nil
+		action, flash = "beheerder.gewijzigd", u.Username+" is ontgrendeld."
+	case "tweestaps":
+		u.TOTPSecret, u.TOTPEnabled, u.TOTPLastStep = "", false, 0
+		action, flash = "beheerder.tweestaps", u.Username+" stelt bij de volgende keer inloggen de authenticator-app opnieuw in."
+	case "wachtwoord":
+		var err error
+		if password, err = generatePassword(); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		if u.PasswordHash, err = hashPassword(password); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		u.PasswordReset = true
+		action = "beheerder.wachtwoord"
+	default:
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Select("*").Omit("created_at").Save(&u).Error; err != nil {
+			return err
+		}
+		if n, err := activeOwners(tx); err != nil {
+			return err
+		} else if n == 0 {
+			return errLastOwner
+		}
+		// whoever lost access or a factor is logged out everywhere
+		if u.DisabledAt != nil || action == "beheerder.tweestaps" || action == "beheerder.wachtwoord" {
+			if err := adminSessions.endAll(tx, u.ID); err != nil {
+				return err
+			}
+		}
+		return recordAudit(tx, c, action, "beheerder", fmt.Sprint(u.ID), auditDiff(before, auditFields(u)))
+	})
+	switch {
+	case errors.Is(err, errLastOwner):
+		renderAdminUsers(c, http.StatusConflict, gin.H{"Error": "Er moet minstens één actieve eigenaar blijven."})
+	case err != nil:
+		c.AbortWithError(http.StatusInternalServerError, err)
+	case password != "":
+		renderAdminUsers(c, http.StatusOK, gin.H{"Created": u, "Password": password})
+	default:
+		redirectAdmin(c, "/admin/gebruikers", flash)
+	}
+}
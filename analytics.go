@@ -0,0 +1,512 @@
+package main
+
+import (
+	"cmp"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Visits are only counted for visitors who allow analytics in the cookie
+// banner, and without setting cookies or storing IP addresses. Each hit carries a visitor hash of the IP address and user agent with a
+// salt that is made per day and deleted once the day is rolled up, after
+// which the hashes of different days cannot be linked, nor traced back to
+// an address. The raw hits only live until then: what stays are the daily
+// totals in AnalyticsDay.
+
+// AnalyticsHit is a page view or conversion of today or yesterday.
+type AnalyticsHit struct {
+	ID       uint   `gorm:"primaryKey"`
+	Day      string `gorm:"not null;index"` // YYYY-MM-DD in Dutch time
+	Kind     string `gorm:"not null"`
+	Path     string
+	Referrer string // host of the referring site, if another one
+	Campaign string // UTM source, medium and campaign
+	Device   string
+	Visitor  string `gorm:"not null"`
+}
+
+// Hit kinds.
+const (
+	hitPageView   = "weergave"
+	hitConversion = "conversie" // contact form sent
+)
+
+// AnalyticsSalt is the salt of the visitor hashes of one day.
+type AnalyticsSalt struct {
+	Day  string `gorm:"primaryKey"`
+	Salt []byte `gorm:"not null"`
+}
+
+// AnalyticsDay is the total of one day for one value of a dimension, e.g.
+// the page /contact. Visitors are counted per day: summed over a period a
+// returning visitor counts once per day.
+type AnalyticsDay struct {
+	ID          uint   `gorm:"primaryKey"`
+	Day         string `gorm:"not null;uniqueIndex:idx_analytics_day"`
+	Dimension   string `gorm:"not null;uniqueIndex:idx_analytics_day"`
+	Value       string `gorm:"not null;uniqueIndex:idx_analytics_day"`
+	Views       int    `gorm:"not null"`
+	Visitors    int    `gorm:"not null"`
+	Conversions int    `gorm:"not null"` // visitors who sent the contact form
+}
+
+// Dimensions of AnalyticsDay. Source, campaign and device are those of the
+// first page a visitor saw that day.
+const (
+	dimensionTotal    = "totaal"
+	dimensionPage     = "pagina"
+	dimensionSource   = "bron"
+	dimensionCampaign = "campagne"
+	dimensionDevice   = "apparaat"
+)
+
+// sourceDirect is the source of visits without a referring site.
+const sourceDirect = "(direct)"
+
+// botPattern matches the user agents of crawlers, link previews and
+// scripts, which are not counted.
+var botPattern = regexp.MustCompile(`(?i)bot|crawl|spider|slurp|preview|monitor|curl|wget|python|go-http-client|headless`)
+
+// deviceClass tells phones, tablets and computers apart by user agent.
+// Android tablets are the Android browsers that do not say Mobile.
+func deviceClass(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	android := strings.Contains(ua, "android")
+	switch {
+	case strings.Contains(ua, "ipad") || strings.Contains(ua, "tablet") || android && !strings.Contains(ua, "mobi"):
+		return "tablet"
+	case strings.Contains(ua, "mobi") || strings.Contains(ua, "iphone") || android:
+		return "mobiel"
+	default:
+		return "computer"
+	}
+}
+
+// analyticsDate returns the day t falls on in the Netherlands.
+func analyticsDate(t time.Time) string {
+	return t.In(openingHoursLocation).Format(time.DateOnly)
+}
+
+// analyticsSalts caches the salt of the days hits are recorded for.
+var analyticsSalts = struct {
+	sync.Mutex
+	byDay map[string][]byte
+}{byDay: make(map[string][]byte)}
+
+// analyticsSalt returns the salt of day, making it on first use. It is kept
+// in the database so a restart does not count everyone twice.
+func analyticsSalt(day string) ([]byte, error) {
+	analyticsSalts.Lock()
+	defer analyticsSalts.Unlock()
+	if salt, ok := analyticsSalts.byDay[day]; ok {
+		return salt, nil
+	}
+	s := AnalyticsSalt{Day: day, Salt: make([]byte, 32)}
+	if _, err := rand.Read(s.Salt); err != nil {
+		return nil, err
+	}
+	if err := db.Where(AnalyticsSalt{Day: day}).FirstOrCreate(&s).Error; err != nil {
+		return nil, err
+	}
+	analyticsSalts.byDay[day] = s.Salt
+	return s.Salt, nil
+}
+
+// forgetAnalyticsSalts drops the cached salts of the days before day.
+func forgetAnalyticsSalts(day string) {
+	analyticsSalts.Lock()
+	defer analyticsSalts.Unlock()
+	for d := range analyticsSalts.byDay {
+		if d < day {
+			delete(analyticsSalts.byDay, d)
+		}
+	}
+}
+
+// analyticsHits queues hits for runAnalytics, so requests do not wait for
+// the database.
+var analyticsHits = make(chan AnalyticsHit, 1000)
+
+// analyticsMiddleware records a page view for every page served to a
+// person who allows analytics.
+func analyticsMiddleware(c *gin.Context) {
+	c.Next()
+	if c.Request.Method != http.MethodGet || c.Writer.Status() != http.StatusOK ||
+		!strings.HasPrefix(c.Writer.Header().Get("Content-Type"), "text/html") ||
+		strings.HasPrefix(c.Request.URL.Path, "/admin") ||
+		c.GetHeader("Sec-Purpose") != "" || c.GetHeader("Purpose") == "prefetch" {
+		return
+	}
+	trackHit(c, hitPageView)
+}
+
+// trackHit queues a hit of kind for the request, unless the visitor did
+// not allow analytics in the cookie banner. Hits are dropped rather than
+// slowing the site down when the queue is full.
+func trackHit(c *gin.Context, kind string) {
+	userAgent := c.Request.UserAgent()
+	if userAgent == "" || botPattern.MatchString(userAgent) || !cookieConsent(c).Allows(cookiesAnalytics) {
+		return
+	}
+	now := time.Now()
+	day := analyticsDate(now)
+	salt, err := analyticsSalt(day)
+	if err != nil {
+		log.Printf("analytics: %v", err)
+		return
+	}
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(c.ClientIP() + "\x00" + userAgent))
+	hit := AnalyticsHit{
+		Day:     day,
+		Kind:    kind,
+		Path:    clipText(c.Request.URL.Path, 200),
+		Device:  deviceClass(userAgent),
+		Visitor: hex.EncodeToString(h.Sum(nil)[:16]),
+	}
+	if ref, err := url.Parse(c.GetHeader("Referer")); err == nil && ref.Hostname() != "" &&
+		ref.Host != c.Request.Host && site.URL("") != ref.Scheme+"://"+ref.Host {
+		hit.Referrer = strings.TrimPrefix(strings.ToLower(ref.Hostname()), "www.")
+	}
+	var utm []string
+	for _, param := range []string{"utm_source", "utm_medium", "utm_campaign"} {
+		if v := strings.TrimSpace(c.Query(param)); v != "" {
+			utm = append(utm, clipText(strings.ToLower(v), 60))
+		}
+	}
+	hit.Campaign = strings.Join(utm, " / ")
+	select {
+	case analyticsHits <- hit:
+	default:
+	}
+}
+
+// clipText cuts s to at most n bytes, on a rune boundary.
+func clipText(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// runAnalytics stores queued hits every few seconds and rolls up the days
+// that have ended.
+func runAnalytics() {
+	tick := time.NewTicker(5 * time.Second)
+	var batch []AnalyticsHit
+	var lastRollup time.Time
+	for {
+		select {
+		case hit := <-analyticsHits:
+			if batch = append(batch, hit); len(batch) < 100 {
+				continue
+			}
+		case <-tick.C:
+		}
+		if len(batch) > 0 {
+			if err := db.Create(&batch).Error; err != nil {
+				log.Printf("analytics: %v", err)
+			}
+			batch = nil
+		}
+		if time.Since(lastRollup) >= time.Hour {
+			if err := rollupAnalytics(time.Now()); err != nil {
+				log.Printf("analytics: %v", err)
+			}
+			lastRollup = time.Now()
+		}
+	}
+}
+
+// rollupAnalytics turns the hits of the days that have ended into daily
+// totals and deletes the hits and salts of those days. Hits still queued
+// around midnight are given a few minutes to come in; any later stragglers
+// are added to the totals.
+func rollupAnalytics(now time.Time) error {
+	before := analyticsDate(now.Add(-10 * time.Minute))
+	var days []string
+	if err := db.Model(&AnalyticsHit{}).Where("day < ?", before).Distinct().Order("day").Pluck("day", &days).Error; err != nil {
+		return err
+	}
+	for _, day := range days {
+		var hits []AnalyticsHit
+		if err := db.Where("day = ?", day).Order("id").Find(&hits).Error; err != nil {
+			return err
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			rows := summarizeHits(day, hits)
+			err := tx.Clauses(clause.OnConflict{
+				Columns: []clause.Column{{Name: "day"}, {Name: "dimension"}, {Name: "value"}},
+				DoUpdates: clause.Assignments(map[string]any{
+					"views":       gorm.Expr("views + excluded.views"),
+					"visitors":    gorm.Expr("visitors + excluded.visitors"),
+					"conversions": gorm.Expr("conversions + excluded.conversions"),
+				}),
+			}).CreateInBatches(rows, 100).Error
+			if err != nil {
+				return err
+			}
+			return tx.Where("day = ? AND id <= ?", day, hits[len(hits)-1].ID).Delete(&AnalyticsHit{}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("roll up %s: %w", day, err)
+		}
+		log.Printf("analytics: %s rolled up, %d hits", day, len(hits))
+	}
+	forgetAnalyticsSalts(before)
+	return db.Where("day < ?", before).Delete(&AnalyticsSalt{}).Error
+}
+
+// summarizeHits computes the totals of one day.
+func summarizeHits(day string, hits []AnalyticsHit) []AnalyticsDay {
+	type visit struct {
+		source, campaign, device string
+		pages                    map[string]int
+		converted                bool
+	}
+	var order []string
+	visits := make(map[string]*visit)
+	for _, hit := range hits {
+		v := visits[hit.Visitor]
+		if v == nil {
+			v = &visit{pages: make(map[string]int)}
+			visits[hit.Visitor] = v
+			order = append(order, hit.Visitor)
+		}
+		if hit.Kind == hitConversion {
+			v.converted = true
+			continue
+		}
+		if len(v.pages) == 0 {
+			v.source, v.campaign, v.device = hit.Referrer, hit.Campaign, hit.Device
+			if v.source == "" {
+				v.source = sourceDirect
+			}
+		}
+		v.pages[hit.Path]++
+	}
+
+	rows := make(map[[2]string]*AnalyticsDay)
+	add := func(dimension, value string, views int, converted bool) {
+		key := [2]string{dimension, value}
+		r := rows[key]
+		if r == nil {
+			r = &AnalyticsDay{Day: day, Dimension: dimension, Value: value}
+			rows[key] = r
+		}
+		r.Views += views
+		r.Visitors++
+		if converted {
+			r.Conversions++
+		}
+	}
+	for _, id := range order {
+		v := visits[id]
+		views := 0
+		for path, n := range v.pages {
+			views += n
+			add(dimensionPage, path, n, v.converted)
+		}
+		add(dimensionTotal, "", views, v.converted)
+		if views == 0 {
+			continue // a form sent without a page view, e.g. by a script
+		}
+		add(dimensionSource, v.source, views, v.converted)
+		add(dimensionDevice, v.device, views, v.converted)
+		if v.campaign != "" {
+			add(dimensionCampaign, v.campaign, views, v.converted)
+		}
+	}
+	result := make([]AnalyticsDay, 0, len(rows))
+	for _, r := range rows {
+		result = append(result, *r)
+	}
+	slices.SortFunc(result, func(a, b AnalyticsDay) int {
+		if a.Dimension != b.Dimension {
+			return cmp.Compare(a.Dimension, b.Dimension)
+		}
+		return cmp.Compare(a.Value, b.Value)
+	})
+	return result
+}
+
+// AnalyticsRow is a line of the dashboard.
+type AnalyticsRow struct {
+	Value       string
+	Views       int
+	Visitors    int
+	Conversions int
+	Bar         int // views as a percentage of the largest row, for the chart
+}
+
+// Rate returns the share of visitors who sent the contact form.
+func (r AnalyticsRow) Rate() string {
+	if r.Visitors == 0 {
+		return "–"
+	}
+	return strings.Replace(strconv.FormatFloat(100*float64(r.Conversions)/float64(r.Visitors), 'f', 1, 64), ".", ",", 1) + "%"
+}
+
+// AnalyticsReport is what the dashboard shows for a period.
+type AnalyticsReport struct {
+	Days      int
+	From, To  string
+	Total     AnalyticsRow
+	Daily     []AnalyticsRow // oldest first, Value is the date
+	Pages     []AnalyticsRow
+	Sources   []AnalyticsRow
+	Campaigns []AnalyticsRow
+	Devices   []AnalyticsRow
+}
+
+// analyticsPeriods are the periods the dashboard offers, in days.
+var analyticsPeriods = []int{7, 30, 90, 365}
+
+// analyticsTopRows is how many pages, sources and campaigns are listed.
+const analyticsTopRows = 20
+
+// analyticsReport adds up the days of the period ending today. Today is
+// not rolled up yet, so it is summarized from its hits.
+func analyticsReport(days int, now time.Time) (AnalyticsReport, error) {
+	rep := AnalyticsReport{Days: days, To: analyticsDate(now), From: analyticsDate(now.AddDate(0, 0, 1-days))}
+	var rows []AnalyticsDay
+	if err := db.Where("day >= ? AND day <= ?", rep.From, rep.To).Find(&rows).Error; err != nil {
+		return rep, err
+	}
+	var hits []AnalyticsHit
+	if err := db.Where("day >= ?", rep.From).Order("id").Find(&hits).Error; err != nil {
+		return rep, err
+	}
+	byDay := make(map[string][]AnalyticsHit)
+	for _, hit := range hits {
+		byDay[hit.Day] = append(byDay[hit.Day], hit)
+	}
+	for day, hits := range byDay {
+		rows = append(rows, summarizeHits(day, hits)...)
+	}
+
+	sums := make(map[string]map[string]*AnalyticsRow)
+	for _, r := range rows {
+		key := r.Value
+		if r.Dimension == dimensionTotal {
+			key = r.Day
+		}
+		if sums[r.Dimension] == nil {
+			sums[r.Dimension] = make(map[string]*AnalyticsRow)
+		}
+		s := sums[r.Dimension][key]
+		if s == nil {
+			s = &AnalyticsRow{Value: key}
+			sums[r.Dimension][key] = s
+		}
+		s.Views += r.Views
+		s.Visitors += r.Visitors
+		s.Conversions += r.Conversions
+	}
+
+	for d := now.AddDate(0, 0, 1-days); analyticsDate(d) <= rep.To; d = d.AddDate(0, 0, 1) {
+		day := AnalyticsRow{Value: analyticsDate(d)}
+		if s := sums[dimensionTotal][day.Value]; s != nil {
+			day = *s
+		}
+		rep.Daily = append(rep.Daily, day)
+		rep.Total.Views += day.Views
+		rep.Total.Visitors += day.Visitors
+		rep.Total.Conversions += day.Conversions
+	}
+	withBars(rep.Daily)
+	rep.Pages = topRows(sums[dimensionPage], analyticsTopRows)
+	rep.Sources = topRows(sums[dimensionSource], analyticsTopRows)
+	rep.Campaigns = topRows(sums[dimensionCampaign], analyticsTopRows)
+	rep.Devices = topRows(sums[dimensionDevice], 0)
+	return rep, nil
+}
+
+// topRows returns the n rows with the most views, or all of them for n 0.
+func topRows(sums map[string]*AnalyticsRow, n int) []AnalyticsRow {
+	var rows []AnalyticsRow
+	for _, s := range sums {
+		rows = append(rows, *s)
+	}
+	slices.SortFunc(rows, func(a, b AnalyticsRow) int {
+		if a.Views != b.Views {
+			return cmp.Compare(b.Views, a.Views)
+		}
+		return cmp.Compare(a.Value, b.Value)
+	})
+	if n > 0 && len(rows) > n {
+		rows = rows[:n]
+	}
+	withBars(rows)
+	return rows
+}
+
+func withBars(rows []AnalyticsRow) {
+	most := 0
+	for _, r := range rows {
+		most = max(most, r.Views)
+	}
+	for i := range rows {
+		if most > 0 {
+			rows[i].Bar = 100 * rows[i].Views / most
+		}
+	}
+}
+
+// analyticsTable is one of the tables on the dashboard.
+type analyticsTable struct {
+	Title, Label, Empty string
+	Rows                []AnalyticsRow
+}
+
+var errAnalyticsPeriod = errors.New("onbekende periode")
+
+// adminAnalyticsHandler handles GET /admin/statistieken.
+func adminAnalyticsHandler(c *gin.Context) {
+	days := 30
+	if v := c.Query("dagen"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || !slices.Contains(analyticsPeriods, n) {
+			c.AbortWithError(http.StatusBadRequest, errAnalyticsPeriod)
+			return
+		}
+		days = n
+	}
+	rep, err := analyticsReport(days, time.Now())
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	renderAdmin(c, http.StatusOK, "statistieken", "statistieken", "Statistieken", gin.H{
+		"Report":  rep,
+		"Periods": analyticsPeriods,
+		"Tables": []analyticsTable{
+			{"Per dag", "Datum", "", rep.Daily},
+			{"Populairste pagina's", "Pagina", "Nog geen paginaweergaven.", rep.Pages},
+			{"Bronnen", "Verwijzende site", "Nog geen bezoekers.", rep.Sources},
+			{"Campagnes", "utm_source / utm_medium / utm_campaign", "Geen bezoekers via links met UTM-parameters.", rep.Campaigns},
+			{"Apparaten", "Apparaat", "Nog geen bezoekers.", rep.Devices},
+		},
+	})
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testAnalyticsSalts empties the salt cache, before and after the test.
+func testAnalyticsSalts(t *testing.T) {
+	forgetAnalyticsSalts("9999-12-31")
+	t.Cleanup(func() { forgetAnalyticsSalts("9999-12-31") })
+}
+
+// testTrackHit calls trackHit for a page view of /diensten by a visitor
+// with the given consent, address and browser, and returns the hit queued.
+func testTrackHit(t *testing.T, cc CookieConsent, ip, userAgent string) (AnalyticsHit, bool) {
+	t.Helper()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/diensten", nil)
+	c.Request.RemoteAddr = ip + ":1234"
+	c.Request.Header.Set("User-Agent", userAgent)
+	c.Set(cookieConsentCookie, cc)
+	trackHit(c, hitPageView)
+	select {
+	case hit := <-analyticsHits:
+		return hit, true
+	default:
+		return AnalyticsHit{}, false
+	}
+}
+
+const testUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Firefox/131.0"
+
+func TestTrackHitNeedsConsent(t *testing.T) {
+	testDB(t, &AnalyticsSalt{})
+	allowed := CookieConsent{Given: true, Categories: []string{cookiesAnalytics}}
+	tests := []struct {
+		name      string
+		consent   CookieConsent
+		userAgent string
+		counted   bool
+	}{
+		{"analytics allowed", allowed, testUserAgent, true},
+		{"no choice made", CookieConsent{}, testUserAgent, false},
+		{"necessary only", CookieConsent{Given: true}, testUserAgent, false},
+		{"marketing only", CookieConsent{Given: true, Categories: []string{cookiesMarketing}}, testUserAgent, false},
+		{"crawler", allowed, "Googlebot/2.1 (+http://www.google.com/bot.html)", false},
+	}
+	for _, tt := range tests {
+		if _, counted := testTrackHit(t, tt.consent, "192.0.2.1", tt.userAgent); counted != tt.counted {
+			t.Errorf("%s: counted %v, want %v", tt.name, counted, tt.counted)
+		}
+	}
+}
+
+func TestVisitorHash(t *testing.T) {
+	testDB(t, &AnalyticsSalt{})
+	testAnalyticsSalts(t)
+	allowed := CookieConsent{Given: true, Categories: []string{cookiesAnalytics}}
+	visitor := func(ip, userAgent string) string {
+		hit, ok := testTrackHit(t, allowed, ip, userAgent)
+		if !ok {
+			t.Fatalf("hit of %s not counted", ip)
+		}
+		return hit.Visitor
+	}
+	phone := "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) Mobile/15E148 Safari/604.1"
+	jan := visitor("192.0.2.1", testUserAgent)
+	if len(jan) != 32 || strings.Contains(jan, "192") {
+		t.Errorf("visitor hash %q", jan)
+	}
+	if again := visitor("192.0.2.1", testUserAgent); again != jan {
+		t.Error("the same visitor counts as another")
+	}
+	if visitor("192.0.2.2", testUserAgent) == jan || visitor("192.0.2.1", phone) == jan {
+		t.Error("another address or browser counts as the same visitor")
+	}
+
+	// with the salt of another day the hashes cannot be linked
+	forgetAnalyticsSalts("9999-12-31")
+	if err := db.Where("1 = 1").Delete(&AnalyticsSalt{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if visitor("192.0.2.1", testUserAgent) == jan {
+		t.Error("the same hash under a new salt")
+	}
+}
+
+func TestAnalyticsSaltRotation(t *testing.T) {
+	testDB(t, &AnalyticsSalt{}, &AnalyticsHit{}, &AnalyticsDay{})
+	testAnalyticsSalts(t)
+	yesterday, err := analyticsSalt("2026-10-17")
+	if err != nil {
+		t.Fatal(err)
+	}
+	today, err := analyticsSalt("2026-10-18")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(today) != 32 || bytes.Equal(today, yesterday) {
+		t.Fatalf("salts %x and %x", yesterday, today)
+	}
+
+	// a restart keeps the salt of the day, so nobody is counted twice
+	forgetAnalyticsSalts("9999-12-31")
+	if again, err := analyticsSalt("2026-10-18"); err != nil || !bytes.Equal(again, today) {
+		t.Errorf("salt after a restart: %x, %v, want %x", again, err, today)
+	}
+
+	// once yesterday is rolled up its salt is gone for good
+	if err := rollupAnalytics(time.Date(2026, time.October, 18, 12, 0, 0, 0, openingHoursLocation)); err != nil {
+		t.Fatal(err)
+	}
+	var days []string
+	if err := db.Model(&AnalyticsSalt{}).Order("day").Pluck("day", &days).Error; err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(days, " ") != "2026-10-18" {
+		t.Errorf("salts kept for %v, want only today", days)
+	}
+	if again, err := analyticsSalt("2026-10-17"); err != nil || bytes.Equal(again, yesterday) {
+		t.Errorf("salt of yesterday recovered after the roll-up: %x, %v", again, err)
+	}
+}
+
+func TestSummarizeHits(t *testing.T) {
+	hits := []AnalyticsHit{
+		{Kind: hitPageView, Path: "/", Referrer: "google.com", Device: "mobiel", Visitor: "a"},
+		{Kind: hitPageView, Path: "/contact", Referrer: "", Device: "mobiel", Visitor: "a"},
+		{Kind: hitConversion, Path: "/contact", Visitor: "a"},
+		{Kind: hitPageView, Path: "/", Campaign: "nieuwsbrief / email / oktober", Device: "computer", Visitor: "b"},
+		{Kind: hitPageView, Path: "/", Device: "computer", Visitor: "b"},
+		{Kind: hitConversion, Path: "/contact", Visitor: "c"}, // no page view
+	}
+	row := func(dimension, value string, views, visitors, conversions int) AnalyticsDay {
+		return AnalyticsDay{Day: "2026-10-17", Dimension: dimension, Value: value, Views: views, Visitors: visitors, Conversions: conversions}
+	}
+	want := []AnalyticsDay{
+		row(dimensionDevice, "computer", 2, 1, 0),
+		row(dimensionDevice, "mobiel", 2, 1, 1),
+		row(dimensionSource, sourceDirect, 2, 1, 0),
+		row(dimensionSource, "google.com", 2, 1, 1),
+		row(dimensionCampaign, "nieuwsbrief / email / oktober", 2, 1, 0),
+		row(dimensionPage, "/", 3, 2, 1),
+		row(dimensionPage, "/contact", 1, 1, 1),
+		row(dimensionTotal, "", 4, 3, 2),
+	}
+	got := summarizeHits("2026-10-17", hits)
+	if len(got) != len(want) {
+		t.Fatalf("summarizeHits = %+v, want %+v", got, want)
+	}
+	for _, w := range want {
+		found := false
+		for _, g := range got {
+			found = found || reflect.DeepEqual(g, w)
+		}
+		if !found {
+			t.Errorf("missing %+v in %+v", w, got)
+		}
+	}
+}
+
+func TestRollupAnalytics(t *testing.T) {
+	testDB(t, &AnalyticsSalt{}, &AnalyticsHit{}, &AnalyticsDay{})
+	testAnalyticsSalts(t)
+	hit := func(day, path, visitor string) AnalyticsHit {
+		return AnalyticsHit{Day: day, Kind: hitPageView, Path: path, Device: "computer", Visitor: visitor}
+	}
+	hits := []AnalyticsHit{
+		hit("2026-10-16", "/", "a"),
+		hit("2026-10-17", "/", "a"),
+		hit("2026-10-17", "/", "b"),
+		hit("2026-10-17", "/diensten", "b"),
+		hit("2026-10-18", "/", "a"),
+	}
+	if err := db.Create(&hits).Error; err != nil {
+		t.Fatal(err)
+	}
+	total := func(day string) (AnalyticsDay, bool) {
+		var r AnalyticsDay
+		err := db.Where("day = ? AND dimension = ?", day, dimensionTotal).First(&r).Error
+		return r, err == nil
+	}
+	left := func() int64 {
+		var n int64
+		if err := db.Model(&AnalyticsHit{}).Count(&n).Error; err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	// just after midnight yesterday's last hits may still be queued
+	if err := rollupAnalytics(time.Date(2026, time.October, 18, 0, 5, 0, 0, openingHoursLocation)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := total("2026-10-17"); ok || left() != 4 {
+		t.Errorf("yesterday rolled up within the grace period; %d hits left", left())
+	}
+	if r, ok := total("2026-10-16"); !ok || r.Views != 1 || r.Visitors != 1 {
+		t.Errorf("2026-10-16: %+v, %v", r, ok)
+	}
+
+	now := time.Date(2026, time.October, 18, 12, 0, 0, 0, openingHoursLocation)
+	if err := rollupAnalytics(now); err != nil {
+		t.Fatal(err)
+	}
+	if r, ok := total("2026-10-17"); !ok || r.Views != 3 || r.Visitors != 2 {
+		t.Errorf("2026-10-17: %+v, %v", r, ok)
+	}
+	if _, ok := total("2026-10-18"); ok || left() != 1 {
+		t.Errorf("today rolled up; %d hits left", left())
+	}
+
+	// a straggler is added to the totals
+	if err := db.Create(&[]AnalyticsHit{hit("2026-10-17", "/", "c")}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := rollupAnalytics(now); err != nil {
+		t.Fatal(err)
+	}
+	if r, ok := total("2026-10-17"); !ok || r.Views != 4 || r.Visitors != 3 {
+		t.Errorf("2026-10-17 after a straggler: %+v, %v", r, ok)
+	}
+	var page AnalyticsDay
+	if err := db.Where("day = ? AND dimension = ? AND value = ?", "2026-10-17", dimensionPage, "/").First(&page).Error; err != nil {
+		t.Fatal(err)
+	}
+	if page.Views != 3 || page.Visitors != 3 {
+		t.Errorf("page / on 2026-10-17: %+v", page)
+	}
+}
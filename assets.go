@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+)
+
+// staticAsset is a single file from the static directory together with its
+// fingerprint and precompressed variants.
+type staticAsset struct {
+	name        string // path relative to static/, e.g. css/style.css
+	hashedName  string // fingerprinted path, e.g. css/style.3f2a1b9c0d1e.css
+	hash        string
+	contentType string
+	body        []byte
+	gzip        []byte
+	brotli      []byte
+}
+
+// assetManifest is built once at startup from the static files. It hands out
+// fingerprinted URLs through the asset template function and serves those
+// URLs with long-lived immutable caching.
+type assetManifest struct {
+	fsys fs.FS
+	dev  bool
+
+	byName   map[string]*staticAsset
+	byHashed map[string]*staticAsset
+
+	missingMu sync.Mutex
+	missing   map[string]bool
+}
+
+// fingerprintLength is the number of hex characters of the SHA-256 digest
+// inserted into asset file names.
+const fingerprintLength = 12
+
+// buildAssets hashes and precompresses every file in fsys. In development
+// mode nothing is fingerprinted and files are served from disk as they are.
+func buildAssets(fsys fs.FS, dev bool) (*assetManifest, error) {
+	m := &assetManifest{
+		fsys:     fsys,
+		dev:      dev,
+		byName:   make(map[string]*staticAsset),
+		byHashed: make(map[string]*staticAsset),
+		missing:  make(map[string]bool),
+	}
+	if dev {
+		return m, nil
+	}
+
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		body, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(body)
+		a := &staticAsset{
+			name:        name,
+			hash:        hex.EncodeToString(sum[:])[:fingerprintLength],
+			contentType: mime.TypeByExtension(path.Ext(name)),
+			body:        body,
+		}
+		a.hashedName = fingerprint(name, a.hash)
+		if a.contentType == "" {
+			a.contentType = http.DetectContentType(body)
+		}
+		if compressible(a.contentType) {
+			a.gzip = gzipBytes(body)
+			a.brotli = brotliBytes(body)
+		}
+		m.byName[a.name] = a
+		m.byHashed[a.hashedName] = a
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// fingerprint inserts hash before the extension of name.
+func fingerprint(name, hash string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hash + ext
+}
+
+func compressible(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	case mediaType == "application/javascript", mediaType == "application/json",
+		mediaType == "image/svg+xml", mediaType == "application/xml":
+		return true
+	}
+	return false
+}
+
+// gzipBytes and brotliBytes return the compressed form of b, or nil when
+// compressing does not make it smaller.
+func gzipBytes(b []byte) []byte {
+	var buf bytes.Buffer
+	w, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	w.Write(b)
+	w.Close()
+	if buf.Len() >= len(b) {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+func brotliBytes(b []byte) []byte {
+	var buf bytes.Buffer
+	w := brotli.NewWriterLevel(&buf, brotli.BestCompression)
+	w.Write(b)
+	w.Close()
+	if buf.Len() >= len(b) {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// Has reports whether name exists in the static directory.
+func (m *assetManifest) Has(name string) bool {
+	name = strings.TrimPrefix(name, "/")
+	if m.dev {
+		_, err := fs.Stat(m.fsys, name)
+		return err == nil
+	}
+	_, ok := m.byName[name]
+	return ok
+}
+
+// Hash returns the content fingerprint of name. It is not available in
+// development mode, where nothing is fingerprinted.
+func (m *assetManifest) Hash(name string) (string, bool) {
+	a, ok := m.byName[strings.TrimPrefix(name, "/")]
+	if !ok {
+		return "", false
+	}
+	return a.hash, true
+}
+
+// URL returns the fingerprinted URL for a file in the static directory. Unknown
+// files fall back to their plain /static/ URL so a typo degrades to an
+// uncached (or missing) file instead of a broken page.
+func (m *assetManifest) URL(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	if m.dev {
+		return "/static/" + name
+	}
+	a, ok := m.byName[name]
+	if !ok {
+		m.missingMu.Lock()
+		if !m.missing[name] {
+			m.missing[name] = true
+			log.Printf("assets: no static file %q", name)
+		}
+		m.missingMu.Unlock()
+		return "/static/" + name
+	}
+	return "/static/" + a.hashedName
+}
+
+// serve handles GET /static/*filepath. Fingerprinted URLs are cached for a
+// year; plain URLs are still served but must be revalidated by the browser.
+func (m *assetManifest) serve(c *gin.Context) {
+	name := strings.TrimPrefix(c.Param("filepath"), "/")
+	if m.dev {
+		c.Header("Cache-Control", "no-cache")
+		c.FileFromFS(name, noListingFS{http.FS(m.fsys)})
+		return
+	}
+
+	a, ok := m.byHashed[name]
+	if ok {
+		c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	} else if a, ok = m.byName[name]; ok {
+		c.Header("Cache-Control", "no-cache")
+	} else {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	body, encoding := a.body, ""
+	accept := c.GetHeader("Accept-Encoding")
+	switch {
+	case a.brotli != nil && acceptsEncoding(accept, "br"):
+		body, encoding = a.brotli, "br"
+	case a.gzip != nil && acceptsEncoding(accept, "gzip"):
+		body, encoding = a.gzip, "gzip"
+	}
+
+	header := c.Writer.Header()
+	if a.gzip != nil || a.brotli != nil {
+		header.Add("Vary", "Accept-Encoding")
+	}
+	etag := a.hash
+	if encoding != "" {
+		header.Set("Content-Encoding", encoding)
+		etag += "-" + encoding
+	}
+	header.Set("ETag", `"`+etag+`"`)
+	header.Set("Content-Type", a.contentType)
+	http.ServeContent(c.Writer, c.Request, a.name, time.Time{}, bytes.NewReader(body))
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header allows coding.
+func acceptsEncoding(header, coding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), coding) {
+			continue
+		}
+		params = strings.ReplaceAll(params, " ", "")
+		return params != "q=0" && params != "q=0.0" && params != "q=0.00" && params != "q=0.000"
+	}
+	return false
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Attachment is an uploaded file, kept in store under StorageKey. It
+// belongs to a contact request, a ticket message or both: files sent with
+// a support request also show up in the ticket's thread.
+type Attachment struct {
+	ID              uint   `gorm:"primaryKey"`
+	ContactID       *uint  `gorm:"index"`
+	TicketMessageID *uint  `gorm:"index"`
+	Filename        string `gorm:"not null"`
+	ContentType     string `gorm:"not null"`
+	Size            int64  `gorm:"not null"`
+	StorageKey      string `gorm:"not null;uniqueIndex"`
+	CreatedAt       time.Time
+}
+
+// Limits for uploads, per form submission.
+const (
+	maxAttachments    = 5
+	maxAttachmentSize = 10 << 20
+	maxUploadBody     = maxAttachments*maxAttachmentSize + 1<<20 // request body, with room for the text
+)
+
+// attachmentTypes are the sniffed content types we accept. The type the
+// browser sends is ignored.
+var attachmentTypes = map[string]bool{
+	"image/png":                 true,
+	"image/jpeg":                true,
+	"image/gif":                 true,
+	"image/webp":                true,
+	"application/pdf":           true,
+	"text/plain; charset=utf-8": true,
+	"application/zip":           true,
+}
+
+// limitBody caps the size of request bodies; larger uploads fail while the
+// form is parsed.
+func limitBody(n int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, n)
+	}
+}
+
+// upload is a checked file from a form, ready to be stored.
+type upload struct {
+	Attachment
+	data []byte
+}
+
+// formUploads reads the files posted in the "bijlagen" field. Content types
+// are sniffed and metadata is stripped from images.
+func formUploads(c *gin.Context) ([]upload, error) {
+	form, err := c.MultipartForm()
+	if err != nil {
+		if errors.Is(err, http.ErrNotMultipart) {
+			return nil, nil
+		}
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, fmt.Errorf("de bijlagen zijn samen te groot")
+		}
+		return nil, errors.New("de bijlagen konden niet worden gelezen")
+	}
+	files := form.File["bijlagen"]
+	if len(files) > maxAttachments {
+		return nil, fmt.Errorf("u kunt maximaal %d bijlagen toevoegen", maxAttachments)
+	}
+	var uploads []upload
+	for _, fh := range files {
+		if fh.Size > maxAttachmentSize {
+			return nil, fmt.Errorf("%s is groter dan %d MB", fh.Filename, maxAttachmentSize>>20)
+		}
+		data, err := readUpload(fh)
+		if err != nil {
+			return nil, err
+		}
+		contentType := http.DetectContentType(data)
+		if !attachmentTypes[contentType] {
+			return nil, fmt.Errorf("%s: alleen afbeeldingen, PDF-, tekst- en zipbestanden zijn toegestaan", fh.Filename)
+		}
+		if data, err = stripMetadata(contentType, data); err != nil {
+			return nil, fmt.Errorf("%s: de afbeelding kon niet worden gelezen", fh.Filename)
+		}
+		uploads = append(uploads, upload{
+			Attachment: Attachment{
+				Filename:    safeFilename(fh.Filename),
+				ContentType: contentType,
+				Size:        int64(len(data)),
+			},
+			data: data,
+		})
+	}
+	return uploads, nil
+}
+
+func readUpload(fh *multipart.FileHeader) ([]byte, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(io.LimitReader(f, maxAttachmentSize+1))
+}
+
+// storeUploads puts the files in store and returns their attachment
+// records, to be saved by the caller. Files stored before an error are
+// removed again.
+func storeUploads(ctx context.Context, uploads []upload) ([]Attachment, error) {
+	var attachments []Attachment
+	for _, u := range uploads {
+		a := u.Attachment
+		a.StorageKey = newStorageKey("bijlagen")
+		if err := store.Put(ctx, a.StorageKey, bytes.NewReader(u.data), a.Size, a.ContentType); err != nil {
+			discardAttachments(attachments)
+			return nil, err
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, nil
+}
+
+// discardAttachments removes stored files whose records were not saved.
+func discardAttachments(attachments []Attachment) {
+	for _, a := range attachments {
+		if err := store.Delete(context.Background(), a.StorageKey); err != nil {
+			log.Printf("attachments: delete %s: %v", a.StorageKey, err)
+		}
+	}
+}
+
+// safeFilename strips directories and characters that do not belong in a
+// Content-Disposition header.
+func safeFilename(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == '"' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "" {
+		name = "bijlage"
+	}
+	return name
+}
+
+// serveAttachment sends an attachment from store; only images and PDFs are
+// shown inline, and the sandbox keeps uploaded files from running scripts.
+func serveAttachment(c *gin.Context, a Attachment) {
+	f, err := store.Open(c, a.StorageKey)
+	if err != nil {
+		c.AbortWithError(http.StatusNotFound, err)
+		return
+	}
+	defer f.Close()
+	disposition := "attachment"
+	if strings.HasPrefix(a.ContentType, "image/") || a.ContentType == "application/pdf" {
+		disposition = "inline"
+	}
+	c.DataFromReader(http.StatusOK, a.Size, a.ContentType, f, map[string]string{
+		"Content-Disposition":     fmt.Sprintf(`%s; filename="%s"`, disposition, a.Filename),
+		"X-Content-Type-Options":  "nosniff",
+		"Content-Security-Policy": "sandbox",
+		"Cache-Control":           "private, no-store",
+	})
+}
+
+// adminAttachmentHandler handles GET /admin/bijlagen/:id.
+func adminAttachmentHandler(c *gin.Context) {
+	var a Attachment
+	if err := db.First(&a, c.Param("id")).Error; err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	serveAttachment(c, a)
+}
+
+// stripMetadata removes EXIF, XMP and similar metadata from images, which
+// can hold the location a photo was taken and the camera's serial number.
+// Other files are returned as they are.
+func stripMetadata(contentType string, data []byte) ([]byte, error) {
+	switch contentType {
+	case "image/jpeg":
+		return stripJPEG(data)
+	case "image/png":
+		return stripPNG(data)
+	case "image/webp":
+		return stripWebP(data)
+	}
+	return data, nil
+}
+
+// stripJPEG drops the APP1 (EXIF, XMP) and APP13 (IPTC) segments and keeps
+// the image data as it is. A photo that relies on its EXIF orientation is
+// rotated and re-encoded instead, so it still shows the right way up.
+func stripJPEG(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errors.New("not a JPEG file")
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+	orientation := 1
+	for i := 2; ; {
+		if i+4 > len(data) || data[i] != 0xFF {
+			return nil, errors.New("malformed JPEG segment")
+		}
+		marker := data[i+1]
+		if marker == 0xDA { // start of scan: the rest is image data
+			out.Write(data[i:])
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, errors.New("malformed JPEG segment")
+		}
+		switch marker {
+		case 0xE1:
+			if o := exifOrientation(data[i+4 : end]); o != 0 {
+				orientation = o
+			}
+		case 0xED:
+		default:
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+	if orientation < 2 || orientation > 8 {
+		return out.Bytes(), nil
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, orient(img, orientation), &jpeg.Options{Quality: 92}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// exifOrientation reads the Orientation tag from an APP1 payload, or
+// returns 0.
+func exifOrientation(payload []byte) int {
+	tiff, ok := bytes.CutPrefix(payload, []byte("Exif\x00\x00"))
+	if !ok || len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 0
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for n := 0; n < entries; n++ {
+		e := ifd + 2 + 12*n
+		if e+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[e:]) == 0x0112 {
+			return int(order.Uint16(tiff[e+8:]))
+		}
+	}
+	return 0
+}
+
+// orient applies an EXIF orientation (2-8) to img.
+func orient(img image.Image, orientation int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			dst.Set(x, y, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
+
+// pngMetadataChunks are the ancillary PNG chunks that carry metadata.
+var pngMetadataChunks = map[string]bool{"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true}
+
+// stripPNG drops the metadata chunks from a PNG file.
+func stripPNG(data []byte) ([]byte, error) {
+	const signature = "\x89PNG\r\n\x1a\n"
+	if !bytes.HasPrefix(data, []byte(signature)) {
+		return nil, errors.New("not a PNG file")
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.WriteString(signature)
+	for i := len(signature); i < len(data); {
+		if i+12 > len(data) {
+			return nil, errors.New("malformed PNG chunk")
+		}
+		end := i + 12 + int(binary.BigEndian.Uint32(data[i:]))
+		if end > len(data) || end < i {
+			return nil, errors.New("malformed PNG chunk")
+		}
+		if !pngMetadataChunks[string(data[i+4:i+8])] {
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+	return out.Bytes(), nil
+}
+
+// stripWebP drops the EXIF and XMP chunks from a WebP file and clears their
+// flags in the VP8X header.
+func stripWebP(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errors.New("not a WebP file")
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:12])
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, errors.New("malformed WebP chunk")
+		}
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size%2 // chunks are padded to an even size
+		if end > len(data) || end < i {
+			return nil, errors.New("malformed WebP chunk")
+		}
+		switch string(data[i : i+4]) {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := bytes.Clone(data[i:end])
+			if len(chunk) > 8 {
+				chunk[8] &^= 0x08 | 0x04 // EXIF and XMP present flags
+			}
+			out.Write(chunk)
+		default:
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+	b := out.Bytes()
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(b)-8))
+	return b, nil
+}
+
+// migrateTicketAttachments moves the files of the ticket_attachments table,
+// which kept them in the database, into store.
+func migrateTicketAttachments() error {
+	if !db.Migrator().HasTable("ticket_attachments") {
+		return nil
+	}
+	type ticketAttachment struct {
+		ID              uint
+		TicketMessageID uint
+		Filename        string
+		ContentType     string
+		Size            int64
+		Data            []byte
+	}
+	var old []ticketAttachment
+	if err := db.Table("ticket_attachments").Find(&old).Error; err != nil {
+		return err
+	}
+	var uploads []upload
+	for _, a := range old {
+		messageID := a.TicketMessageID
+		uploads = append(uploads, upload{
+			Attachment: Attachment{
+				TicketMessageID: &messageID,
+				Filename:        a.Filename,
+				ContentType:     a.ContentType,
+				Size:            a.Size,
+			},
+			data: a.Data,
+		})
+	}
+	attachments, err := storeUploads(context.Background(), uploads)
+	if err != nil {
+		return err
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if len(attachments) > 0 {
+			if err := tx.Create(&attachments).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Migrator().DropTable("ticket_attachments")
+	})
+	if err != nil {
+		discardAttachments(attachments)
+		return err
+	}
+	log.Printf("attachments: moved %d ticket attachments to storage", len(attachments))
+	return nil
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// AuditEvent records one administrative action: who did what to which
+// record, and what changed. The log is append-only. Every event carries the
+// hash of the one before it, so a changed or removed event breaks the chain
+// from that point on; see verifyAuditChain.
+type AuditEvent struct {
+	ID        uint      `gorm:"primaryKey"`
+	CreatedAt time.Time `gorm:"not null;index"`
+	Actor     string    `gorm:"not null;index"` // admin user, or auditActorSubject
+	Action    string    `gorm:"not null;index"` // one of auditActions
+	Entity    string    `gorm:"not null;index:idx_audit_entity"`
+	EntityID  string    `gorm:"index:idx_audit_entity"`
+	Changes   string    // JSON object of field: [before, after]
+	IP        string
+	PrevHash  string `gorm:"not null;uniqueIndex"` // empty for the first event
+	Hash      string `gorm:"not null"`
+}
+
+// auditAction is a kind of event as listed in the admin filter.
+type auditAction struct {
+	Name  string
+	Label string
+}
+
+var auditActions = []auditAction{
+	{"offerte.aangemaakt", "Offerte aangemaakt"},
+	{"offerte.gewijzigd", "Offerte gewijzigd"},
+	{"offerte.verzonden", "Offerte verzonden"},
+	{"ticket.beantwoord", "Ticket beantwoord"},
+	{"ticket.notitie", "Notitie bij ticket"},
+	{"ticket.status", "Ticketstatus gewijzigd"},
+	{"ticket.prioriteit", "Ticketprioriteit gewijzigd"},
+	{"nieuwsbrief.bevestigingen", "Bevestigingsmails verstuurd"},
+	{"campagne.aangemaakt", "Campagne aangemaakt"},
+	{"campagne.gewijzigd", "Campagne gewijzigd"},
+	{"campagne.test", "Testmail van campagne"},
+	{"campagne.verzonden", "Campagne verzonden"},
+	{"gegevens.inzage", "Gegevens geëxporteerd"},
+	{"gegevens.verwijderd", "Gegevens verwijderd"},
+	{"beheerder.ingelogd", "Ingelogd"},
+	{"beheerder.aangemaakt", "Gebruiker aangemaakt"},
+	{"beheerder.gewijzigd", "Gebruiker gewijzigd"},
+	{"beheerder.tweestaps", "Authenticator-app van gebruiker gereset"},
+	{"beheerder.wachtwoord", "Wachtwoord van gebruiker gereset"},
+	{"account.tweestaps", "Authenticator-app ingesteld"},
+	{"account.wachtwoord", "Eigen wachtwoord gewijzigd"},
+	{"account.herstelcodes", "Nieuwe herstelcodes"},
+}
+
+// Entities in the audit log. A data subject is identified by the keyed hash
+// of their address, as in DataRequest, so the log keeps no address that
+// was erased. For the same reason the log keeps no values of personal
+// fields; see auditValueFields.
+var auditEntities = []auditAction{
+	{"offerte", "Offerte"},
+	{"ticket", "Ticket"},
+	{"campagne", "Campagne"},
+	{"nieuwsbrief", "Nieuwsbrief"},
+	{"betrokkene", "Betrokkene"},
+	{"beheerder", "Gebruiker"},
+}
+
+// auditActorSubject is the actor of self-service data requests: the owner
+// of the address, proven by the link mailed to it.
+const auditActorSubject = "betrokkene"
+
+func auditLabel(list []auditAction, name string) string {
+	for _, a := range list {
+		if a.Name == name {
+			return a.Label
+		}
+	}
+	return name
+}
+
+// ActionLabel returns the action as shown in the admin.
+func (e AuditEvent) ActionLabel() string {
+	return auditLabel(auditActions, e.Action)
+}
+
+// EntityLabel returns the kind of record as shown in the admin.
+func (e AuditEvent) EntityLabel() string {
+	return auditLabel(auditEntities, e.Entity)
+}
+
+// Link returns the admin page of the record, if it has one.
+func (e AuditEvent) Link() string {
+	switch e.Entity {
+	case "offerte":
+		return "/admin/offertes/" + e.EntityID
+	case "ticket":
+		return "/admin/tickets/" + e.EntityID
+	case "campagne":
+		return "/admin/nieuwsbrief/campagnes/" + e.EntityID
+	case "beheerder":
+		return "/admin/gebruikers#gebruiker-" + e.EntityID
+	}
+	return ""
+}
+
+// auditChange is one changed field, formatted for display. Hidden is set
+// for fields of which only the name was logged.
+type auditChange struct {
+	Field, Before, After string
+	Hidden               bool
+}
+
+// Diff returns the changed fields in alphabetical order.
+func (e AuditEvent) Diff() []auditChange {
+	var changes auditChanges
+	if json.Unmarshal([]byte(e.Changes), &changes) != nil {
+		return nil
+	}
+	var diff []auditChange
+	for field, values := range changes {
+		if values == [2]any{} {
+			diff = append(diff, auditChange{Field: field, Hidden: true})
+			continue
+		}
+		diff = append(diff, auditChange{field, auditValue(values[0]), auditValue(values[1]), false})
+	}
+	slices.SortFunc(diff, func(a, b auditChange) int { return strings.Compare(a.Field, b.Field) })
+	return diff
+}
+
+func auditValue(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return "—"
+	case string:
+		return x
+	default:
+		data, _ := json.Marshal(x)
+		return string(data)
+	}
+}
+
+// auditChanges maps a field to its value before and after the action. A
+// value that did not exist before, or no longer exists after, is nil.
+type auditChanges map[string][2]any
+
+// auditValueFields are the fields of which the log keeps the values. The
+// log can never be erased, so any other field, which may hold a name, an
+// address or free text, is logged by name only, with both values nil.
+var auditValueFields = map[string]bool{
+	// offerte
+	"Number": true, "ContactID": true, "Status": true, "ValidUntil": true,
+	"SentAt": true, "AcceptedAt": true,
+	// ticket
+	"Priority": true, "ReactieVoor": true, "Bericht": true, "Bijlagen": true,
+	// campagne and nieuwsbrief
+	"Subject": true, "Preheader": true, "Body": true, "Recipients": true,
+	"TestSentAt": true, "Bevestigingsmails": true,
+	// betrokkene: counts of records only
+	"Samenvatting": true,
+	// beheerder
+	"Username": true, "Role": true, "PasswordReset": true, "TOTPEnabled": true,
+	"FailedLogins": true, "LockedUntil": true, "LastLoginAt": true,
+	"DisabledAt": true, "Methode": true, "Herstelcodes": true,
+}
+
+// redact returns the changes with the values of fields outside
+// auditValueFields left out.
+func (changes auditChanges) redact() auditChanges {
+	redacted := make(auditChanges, len(changes))
+	for field, values := range changes {
+		if !auditValueFields[field] {
+			values = [2]any{}
+		}
+		redacted[field] = values
+	}
+	return redacted
+}
+
+// auditFields returns the fields of a record as compared by auditDiff.
+// Related records (nested objects) are left out; lists of items, such as
+// quote lines, are compared without their IDs, which change on every save.
+func auditFields(record any) map[string]any {
+	var fields map[string]any
+	// records are plain data, which always converts to JSON
+	data, _ := json.Marshal(record)
+	json.Unmarshal(data, &fields)
+	for name, value := range fields {
+		switch v := value.(type) {
+		case map[string]any:
+			delete(fields, name)
+		case []any:
+			for _, item := range v {
+				if m, ok := item.(map[string]any); ok {
+					for key := range m {
+						if strings.HasSuffix(key, "ID") {
+							delete(m, key)
+						}
+					}
+				}
+			}
+		}
+	}
+	delete(fields, "ID")
+	delete(fields, "CreatedAt")
+	delete(fields, "UpdatedAt")
+	return fields
+}
+
+// auditDiff returns the fields that differ between two auditFields
+// results. before is nil for a new record, of which the fields left empty
+// are left out.
+func auditDiff(before, after map[string]any) auditChanges {
+	changes := auditChanges{}
+	for name, value := range after {
+		if _, ok := before[name]; !ok && emptyJSON(value) {
+			continue
+		}
+		if !equalJSON(before[name], value) {
+			changes[name] = [2]any{before[name], value}
+		}
+	}
+	for name, old := range before {
+		if _, ok := after[name]; !ok && old != nil {
+			changes[name] = [2]any{old, nil}
+		}
+	}
+	return changes
+}
+
+func emptyJSON(v any) bool {
+	switch x := v.(type) {
+	case nil:
+		return true
+	case string:
+		return x == ""
+	case float64:
+		return x == 0
+	case bool:
+		return !x
+	case []any:
+		return len(x) == 0
+	}
+	return false
+}
+
+func equalJSON(a, b any) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return string(x) == string(y)
+}
+
+// auditActor returns who performs the request: the admin user, or for the
+// self-service pages the data subject, whose IP address is not logged.
+func auditActor(c *gin.Context) string {
+	if u, ok := adminUser(c); ok {
+		return u.Username
+	}
+	return auditActorSubject
+}
+
+// hash returns the hash of the event, which covers the hash of the event
+// before it.
+func (e AuditEvent) hash() string {
+	data, _ := json.Marshal([]string{
+		e.PrevHash, e.CreatedAt.UTC().Format(time.RFC3339Nano), e.Actor, e.Action,
+		e.Entity, e.EntityID, e.Changes, e.IP,
+	})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// recordAudit appends an event for the request c to the log. Call it in the
+// transaction making the change, so the change is not made if it cannot be
+// logged.
+func recordAudit(tx *gorm.DB, c *gin.Context, action, entity, entityID string, changes auditChanges) error {
+	if !slices.ContainsFunc(auditActions, func(a auditAction) bool { return a.Name == action }) {
+		return fmt.Errorf("unknown audit action %q", action)
+	}
+	data, err := json.Marshal(changes.redact())
+	if err != nil {
+		return err
+	}
+	// Transactions take the write lock when they begin, so no other event
+	// can be appended between reading the last hash and adding this one;
+	// the unique index on PrevHash guards against it all the same.
+	return tx.Transaction(func(tx *gorm.DB) error {
+		var last AuditEvent
+		if err := tx.Select("hash").Order("id DESC").Limit(1).Find(&last).Error; err != nil {
+			return err
+		}
+		e := AuditEvent{
+			CreatedAt: time.Now().UTC(),
+			Actor:     auditActor(c),
+			Action:    action,
+			Entity:    entity,
+			EntityID:  entityID,
+			Changes:   string(data),
+			PrevHash:  last.Hash,
+		}
+		if e.Actor != auditActorSubject {
+			e.IP = c.ClientIP()
+		}
+		e.Hash = e.hash()
+		return tx.Create(&e).Error
+	})
+}
+
+// protectAuditLog makes the database refuse changes to logged events, so
+// the log cannot be edited through the application even by mistake.
+func protectAuditLog() error {
+	for _, op := range []string{"UPDATE", "DELETE"} {
+		err := db.Exec(fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS audit_events_no_%s BEFORE %s ON audit_events
+			BEGIN SELECT RAISE(ABORT, 'audit events are append-only'); END`, op, op)).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// auditChainStatus is the outcome of verifyAuditChain.
+type auditChainStatus struct {
+	Events   int
+	Head     string // hash of the last event; noting it elsewhere also reveals removal of events at the end
+	BrokenAt uint   // first event that does not match the chain, 0 if it is intact
+}
+
+// verifyAuditChain recomputes the chain from the first event on.
+func verifyAuditChain() (auditChainStatus, error) {
+	var status auditChainStatus
+	var lastID uint
+	for {
+		var batch []AuditEvent
+		if err := db.Where("id > ?", lastID).Order("id").Limit(500).Find(&batch).Error; err != nil {
+			return status, err
+		}
+		if len(batch) == 0 {
+			return status, nil
+		}
+		for _, e := range batch {
+			if e.PrevHash != status.Head || e.hash() != e.Hash {
+				status.BrokenAt = e.ID
+				return status, nil
+			}
+			status.Head = e.Hash
+			status.Events++
+		}
+		lastID = batch[len(batch)-1].ID
+	}
+}
+
+const auditPageSize = 100
+
+var errAuditFilter = errors.New("ongeldig filter")
+
+// adminAuditHandler handles GET /admin/logboek: the audit log, newest first,
+// filtered on actor, action, record, data subject and period. ?voor= pages
+// back from an event ID.
+func adminAuditHandler(c *gin.Context) {
+	query := db.Order("id DESC").Limit(auditPageSize + 1)
+	filter := url.Values{}
+	for param, column := range map[string]string{"door": "actor", "actie": "action", "soort": "entity", "nummer": "entity_id"} {
+		if v := c.Query(param); v != "" {
+			query = query.Where(column+" = ?", v)
+			filter.Set(param, v)
+		}
+	}
+	if email := normalizeEmail(c.Query("email")); email != "" {
+		query = query.Where("entity = ? AND entity_id = ?", "betrokkene", dataRequestHash(email))
+		filter.Set("email", email)
+	}
+	for param, op := range map[string]string{"van": ">=", "tot": "<"} {
+		v := c.Query(param)
+		if v == "" {
+			continue
+		}
+		day, err := time.ParseInLocation(time.DateOnly, v, openingHoursLocation)
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, errAuditFilter)
+			return
+		}
+		if op == "<" {
+			day = day.AddDate(0, 0, 1)
+		}
+		query = query.Where("created_at "+op+" ?", day.UTC())
+		filter.Set(param, v)
+	}
+	if v := c.Query("voor"); v != "" {
+		before, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, errAuditFilter)
+			return
+		}
+		query = query.Where("id < ?", before)
+	}
+
+	var events []AuditEvent
+	if err := query.Find(&events).Error; err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	var older string
+	if len(events) > auditPageSize {
+		events = events[:auditPageSize]
+		filter.Set("voor", strconv.FormatUint(uint64(events[len(events)-1].ID), 10))
+		older = "/admin/logboek?" + filter.Encode()
+		filter.Del("voor")
+	}
+	var actors []string
+	if err := db.Model(&AuditEvent{}).Distinct("actor").Order("actor").Pluck("actor", &actors).Error; err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	chain, err := verifyAuditChain()
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	renderAdmin(c, http.StatusOK, "logboek", "logboek", "Logboek", gin.H{
+		"Events":   events,
+		"Older":    older,
+		"Chain":    chain,
+		"Actors":   actors,
+		"Actions":  auditActions,
+		"Entities": auditEntities,
+		"Filter":   filter,
+	})
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// testDB replaces db with an empty database holding the given tables,
+// with encrypted fields under a test key.
+func testDB(t *testing.T, tables ...any) {
+	t.Helper()
+	testDataKeys(t, "test:"+base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	schema.RegisterSerializer("encrypted", encryptedSerializer{})
+	path := filepath.Join(t.TempDir(), "test.db")
+	test, err := gorm.Open(sqlite.Open(path+"?_txlock=immediate&_busy_timeout=5000"), &gorm.Config{
+		NowFunc: func() time.Time { return time.Now().UTC() },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := test.AutoMigrate(tables...); err != nil {
+		t.Fatal(err)
+	}
+	saved := db
+	db = test
+	t.Cleanup(func() { db = saved })
+}
+
+// testDataKeys replaces dataKeys with the keys of spec, as in DATA_KEYS.
+func testDataKeys(t *testing.T, spec string) {
+	t.Helper()
+	keys, err := parseDataKeys(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := dataKeys
+	dataKeys = keys
+	t.Cleanup(func() { dataKeys = saved })
+}
+
+func testAdminContext(username string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/admin", nil)
+	if username != "" {
+		c.Set("admin", AdminUser{Username: username})
+	}
+	return c
+}
+
+func TestAuditChangesRedact(t *testing.T) {
+	changes := auditDiff(
+		auditFields(Quote{Number: "OF-2026-0001", Naam: "Jan Jansen", Email: "jan@example.nl", Status: "concept"}),
+		auditFields(Quote{Number: "OF-2026-0001", Naam: "Piet Pietersen", Email: "piet@example.nl", Status: "verzonden"}),
+	).redact()
+	tests := []struct {
+		field  string
+		values [2]any
+	}{
+		{"Naam", [2]any{}},
+		{"Email", [2]any{}},
+		{"Status", [2]any{"concept", "verzonden"}},
+	}
+	for _, tt := range tests {
+		values, ok := changes[tt.field]
+		if !ok {
+			t.Errorf("%s: change not logged", tt.field)
+			continue
+		}
+		if values != tt.values {
+			t.Errorf("%s = %v, want %v", tt.field, values, tt.values)
+		}
+	}
+	if _, ok := changes["Number"]; ok {
+		t.Error("unchanged Number logged")
+	}
+}
+
+func TestRecordAuditKeepsNoPersonalData(t *testing.T) {
+	testDB(t, &AuditEvent{})
+	err := recordAudit(db, testAdminContext(""), "gegevens.inzage", "betrokkene", "hash",
+		auditChanges{"Samenvatting": {nil, "1 offerte"}, "Email": {nil, "jan@example.nl"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e AuditEvent
+	if err := db.First(&e).Error; err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"Email":[null,null],"Samenvatting":[null,"1 offerte"]}`; e.Changes != want {
+		t.Errorf("Changes = %s, want %s", e.Changes, want)
+	}
+	if e.IP != "" {
+		t.Errorf("IP of the data subject logged: %q", e.IP)
+	}
+	diff := e.Diff()
+	if len(diff) != 2 || diff[0].Field != "Email" || !diff[0].Hidden || diff[1].Hidden {
+		t.Errorf("Diff() = %+v", diff)
+	}
+}
+
+func TestVerifyAuditChain(t *testing.T) {
+	testDB(t, &AuditEvent{})
+	c := testAdminContext("jan")
+	for _, status := range []string{"open", "wachtend", "gesloten"} {
+		if err := recordAudit(db, c, "ticket.status", "ticket", "1", auditChanges{"Status": {nil, status}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	status, err := verifyAuditChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Events != 3 || status.BrokenAt != 0 {
+		t.Fatalf("intact chain: %+v", status)
+	}
+
+	if err := protectAuditLog(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Model(&AuditEvent{}).Where("id = ?", 2).Update("actor", "piet").Error; err == nil {
+		t.Error("audit event updated despite the triggers")
+	}
+
+	// tamper with the database directly, as someone with the file could
+	if err := db.Exec("DROP TRIGGER audit_events_no_UPDATE").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Model(&AuditEvent{}).Where("id = ?", 2).Update("changes", `{"Status":[null,"open"]}`).Error; err != nil {
+		t.Fatal(err)
+	}
+	status, err = verifyAuditChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.BrokenAt != 2 || status.Events != 1 {
+		t.Errorf("tampered chain: %+v, want broken at event 2", status)
+	}
+}
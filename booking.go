@@ -0,0 +1,813 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Appointment is a visit or meeting booked through /afspraak.
+type Appointment struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	Type        string    `json:"type" gorm:"not null"`
+	Technician  string    `json:"technician" gorm:"not null;index:idx_appointments_technician_start"`
+	StartsAt    time.Time `json:"starts_at" gorm:"not null;index:idx_appointments_technician_start"`
+	EndsAt      time.Time `json:"ends_at" gorm:"not null"`
+	Naam        string    `json:"naam" gorm:"not null"`
+	Email       string    `json:"email" gorm:"not null"`
+	Telefoon    string    `json:"telefoon"`
+	Adres       string    `json:"adres"`
+	Bericht     string    `json:"bericht"`
+	Status      string    `json:"status" gorm:"not null;index"`
+	Sequence    int       `json:"-"` // bumped on every change, for calendar clients
+	ConfirmedAt *time.Time
+	CancelledAt *time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// Appointment statuses. A requested appointment holds its slot for
+// booking.HoldUnconfirmed; if it is not confirmed by then the slot is
+// released again.
+const (
+	appointmentRequested = "aangevraagd"
+	appointmentConfirmed = "bevestigd"
+	appointmentCancelled = "geannuleerd"
+)
+
+// Token purposes for the links in appointment e-mails.
+const (
+	tokenAppointmentConfirm  = "afspraak-bevestigen"
+	tokenAppointmentCancel   = "afspraak-annuleren"
+	tokenAppointmentCalendar = "afspraak-agenda"
+	tokenTechnicianFeed      = "afspraak-feed"
+)
+
+// AppointmentType is something customers can book, such as a home visit.
+type AppointmentType struct {
+	Slug        string         `json:"slug"`
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Duration    configDuration `json:"duration"`
+	Service     string         `json:"service"` // slug in services, if any
+	OnSite      bool           `json:"on_site"` // at the customer's address
+}
+
+// Length is the duration in Dutch, e.g. "1 uur en 30 minuten".
+func (t AppointmentType) Length() string {
+	return humanDuration(t.Duration.Duration)
+}
+
+// Technician is someone appointments are booked with.
+type Technician struct {
+	ID           string         `json:"id"`
+	Name         string         `json:"name"`
+	Types        []string       `json:"types"`
+	Availability []Availability `json:"availability"`
+}
+
+// Availability is a weekly window in which a technician takes appointments.
+type Availability struct {
+	Days  Weekdays `json:"days"`
+	From  string   `json:"from"`  // HH:MM
+	Until string   `json:"until"` // HH:MM
+}
+
+// bookingConfig is loaded from config/booking.json or the -booking file.
+type bookingConfig struct {
+	Types       []AppointmentType `json:"appointment_types"`
+	Technicians []Technician      `json:"technicians"`
+	// SlotInterval is the step between the start times offered.
+	SlotInterval configDuration `json:"slot_interval"`
+	// TravelBuffer is kept free around on-site appointments.
+	TravelBuffer configDuration `json:"travel_buffer"`
+	// MinNotice is how far ahead appointments must be booked.
+	MinNotice configDuration `json:"min_notice"`
+	// HorizonDays is how many days ahead appointments can be booked.
+	HorizonDays int `json:"horizon_days"`
+	// HoldUnconfirmed is how long a requested appointment holds its slot
+	// while waiting for the customer to confirm it.
+	HoldUnconfirmed configDuration `json:"hold_unconfirmed"`
+}
+
+// configDuration is a time.Duration written as "1h30m" in configuration.
+type configDuration struct {
+	time.Duration
+}
+
+func (d *configDuration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	d.Duration = v
+	return err
+}
+
+var booking bookingConfig
+
+// initBooking loads the appointment types and technician availability and
+// stops the application when they are inconsistent.
+func initBooking(path string) {
+	b := defaultBookingConfig
+	if path != "" {
+		var err error
+		if b, err = os.ReadFile(path); err != nil {
+			log.Fatalf("Failed to read booking configuration: %v", err)
+		}
+	}
+	dec := json.NewDecoder(strings.NewReader(string(b)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&booking); err != nil {
+		log.Fatalf("Invalid booking configuration: %v", err)
+	}
+	if err := booking.validate(); err != nil {
+		log.Fatalf("Invalid booking configuration:\n%v", err)
+	}
+}
+
+func (c bookingConfig) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	types := make(map[string]bool)
+	for i, t := range c.Types {
+		check(t.Slug != "" && !types[t.Slug], "appointment_types[%d] needs a unique slug", i)
+		check(t.Name != "", "appointment_types[%d] needs a name", i)
+		check(t.Duration.Duration > 0, "appointment_types[%d] needs a duration", i)
+		if t.Service != "" {
+			_, ok := serviceBySlug(t.Service)
+			check(ok, "appointment_types[%d]: unknown service %q", i, t.Service)
+		}
+		types[t.Slug] = true
+	}
+	technicians := make(map[string]bool)
+	for i, tech := range c.Technicians {
+		check(tech.ID != "" && !technicians[tech.ID], "technicians[%d] needs a unique id", i)
+		check(tech.Name != "", "technicians[%d] needs a name", i)
+		for _, slug := range tech.Types {
+			check(types[slug], "technicians[%d]: unknown appointment type %q", i, slug)
+		}
+		for j, a := range tech.Availability {
+			check(len(a.Days) > 0 && clockPattern.MatchString(a.From) && clockPattern.MatchString(a.Until) && a.From < a.Until,
+				"technicians[%d].availability[%d] needs days and from before until, as HH:MM", i, j)
+		}
+		technicians[tech.ID] = true
+	}
+	check(c.SlotInterval.Duration >= 5*time.Minute, "slot_interval must be at least 5m")
+	check(c.TravelBuffer.Duration >= 0, "travel_buffer cannot be negative")
+	check(c.HorizonDays > 0, "horizon_days must be positive")
+	check(c.HoldUnconfirmed.Duration > 0, "hold_unconfirmed must be positive")
+	return errors.Join(errs...)
+}
+
+func (c bookingConfig) appointmentType(slug string) (AppointmentType, bool) {
+	for _, t := range c.Types {
+		if t.Slug == slug {
+			return t, true
+		}
+	}
+	return AppointmentType{}, false
+}
+
+func (c bookingConfig) technician(id string) (Technician, bool) {
+	for _, t := range c.Technicians {
+		if t.ID == id {
+			return t, true
+		}
+	}
+	return Technician{}, false
+}
+
+// techniciansFor returns the technicians who take appointments of type slug.
+func (c bookingConfig) techniciansFor(slug string) []Technician {
+	var techs []Technician
+	for _, t := range c.Technicians {
+		for _, s := range t.Types {
+			if s == slug {
+				techs = append(techs, t)
+			}
+		}
+	}
+	return techs
+}
+
+// activeAppointments returns the appointments that occupy time between from
+// and to: confirmed ones, and requested ones still within their hold.
+func activeAppointments(tx *gorm.DB, from, to time.Time) ([]Appointment, error) {
+	var appointments []Appointment
+	err := tx.Where("starts_at < ? AND ends_at > ?", to.UTC(), from.UTC()).
+		Where("status = ? OR (status = ? AND created_at > ?)",
+			appointmentConfirmed, appointmentRequested, time.Now().UTC().Add(-booking.HoldUnconfirmed.Duration)).
+		Find(&appointments).Error
+	return appointments, err
+}
+
+// conflicts reports whether a new appointment of type t from start to end
+// would overlap one of booked, travel time included.
+func conflicts(t AppointmentType, start, end time.Time, booked []Appointment) bool {
+	for _, a := range booked {
+		buffer := time.Duration(0)
+		if other, _ := booking.appointmentType(a.Type); t.OnSite || other.OnSite {
+			buffer = booking.TravelBuffer.Duration
+		}
+		if start.Before(a.EndsAt.Add(buffer)) && end.After(a.StartsAt.Add(-buffer)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Slot is a start time offered for booking.
+type Slot struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Label string    `json:"label"`
+}
+
+// availableSlots returns the start times between from and to at which at
+// least one technician is free for an appointment of type t.
+func availableSlots(t AppointmentType, from, to time.Time, booked []Appointment) []Slot {
+	byTechnician := make(map[string][]Appointment)
+	for _, a := range booked {
+		byTechnician[a.Technician] = append(byTechnician[a.Technician], a)
+	}
+	earliest := time.Now().Add(booking.MinNotice.Duration)
+	seen := make(map[time.Time]bool)
+	var slots []Slot
+	for day := startOfDay(from); day.Before(to); day = day.AddDate(0, 0, 1) {
+		if _, closed := company.closedReason(day); closed {
+			continue
+		}
+		var daySlots []Slot
+		for _, tech := range booking.techniciansFor(t.Slug) {
+			for _, window := range tech.windows(day) {
+				for start := window.opens; !start.Add(t.Duration.Duration).After(window.closes); start = start.Add(booking.SlotInterval.Duration) {
+					end := start.Add(t.Duration.Duration)
+					if start.Before(earliest) || seen[start] || conflicts(t, start, end, byTechnician[tech.ID]) {
+						continue
+					}
+					seen[start] = true
+					daySlots = append(daySlots, Slot{Start: start, End: end, Label: start.Format("15:04")})
+				}
+			}
+		}
+		sort.Slice(daySlots, func(i, j int) bool { return daySlots[i].Start.Before(daySlots[j].Start) })
+		slots = append(slots, daySlots...)
+	}
+	return slots
+}
+
+// windows returns the technician's availability on day.
+func (t Technician) windows(day time.Time) []openPeriod {
+	at := func(clock string) time.Time {
+		c, _ := time.Parse("15:04", clock) // validated on startup
+		return time.Date(day.Year(), day.Month(), day.Day(), c.Hour(), c.Minute(), 0, 0, openingHoursLocation)
+	}
+	var windows []openPeriod
+	for _, a := range t.Availability {
+		for _, d := range a.Days {
+			if d == day.Weekday() {
+				windows = append(windows, openPeriod{at(a.From), at(a.Until)})
+			}
+		}
+	}
+	return windows
+}
+
+// appointmentSlotsHandler handles GET /api/afspraak/slots?type=<slug>.
+func appointmentSlotsHandler(c *gin.Context) {
+	t, ok := booking.appointmentType(c.Query("type"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Onbekend type afspraak"})
+		return
+	}
+	from := startOfDay(time.Now())
+	to := from.AddDate(0, 0, booking.HorizonDays+1)
+	booked, err := activeAppointments(db, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	type day struct {
+		Date  string `json:"date"`
+		Label string `json:"label"`
+		Slots []Slot `json:"slots"`
+	}
+	var days []day
+	for _, s := range availableSlots(t, from, to, booked) {
+		date := s.Start.Format(time.DateOnly)
+		if len(days) == 0 || days[len(days)-1].Date != date {
+			days = append(days, day{Date: date, Label: dutchDate(s.Start)})
+		}
+		days[len(days)-1].Slots = append(days[len(days)-1].Slots, s)
+	}
+	c.JSON(http.StatusOK, gin.H{"type": t, "days": days})
+}
+
+var errSlotTaken = errors.New("dit tijdstip is zojuist door iemand anders geboekt, kies een ander tijdstip")
+
+// appointmentPostHandler handles POST /afspraak. The slot is checked and
+// reserved in a single write transaction, so two customers can never book
+// the same technician at the same time.
+func appointmentPostHandler(c *gin.Context) {
+	var request struct {
+		Type     string    `json:"type"`
+		Start    time.Time `json:"start"`
+		Naam     string    `json:"naam"`
+		Email    string    `json:"email"`
+		Telefoon string    `json:"telefoon"`
+		Adres    string    `json:"adres"`
+		Bericht  string    `json:"bericht"`
+		Privacy  bool      `json:"privacy"`
+		// PrivacyVersie is the policy version the form showed, see
+		// recordConsent.
+		PrivacyVersie string `json:"privacy_versie"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	t, ok := booking.appointmentType(request.Type)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Onbekend type afspraak"})
+		return
+	}
+	if msg := validateAppointmentRequest(t, request.Naam, request.Email, request.Adres, request.Privacy); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	if err := checkPolicyVersion(request.PrivacyVersie); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	a := Appointment{
+		Type:     t.Slug,
+		StartsAt: request.Start.UTC(),
+		EndsAt:   request.Start.UTC().Add(t.Duration.Duration),
+		Naam:     strings.TrimSpace(request.Naam),
+		Email:    strings.TrimSpace(request.Email),
+		Telefoon: strings.TrimSpace(request.Telefoon),
+		Adres:    strings.TrimSpace(request.Adres),
+		Bericht:  strings.TrimSpace(request.Bericht),
+		Status:   appointmentRequested,
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		buffer := booking.TravelBuffer.Duration
+		booked, err := activeAppointments(tx, a.StartsAt.Add(-buffer), a.EndsAt.Add(buffer))
+		if err != nil {
+			return err
+		}
+		// Only slots we would offer right now can be booked; this also
+		// rules out times outside availability and the booking horizon.
+		from := startOfDay(a.StartsAt)
+		offered := false
+		for _, s := range availableSlots(t, from, from.AddDate(0, 0, 1), booked) {
+			offered = offered || s.Start.Equal(a.StartsAt)
+		}
+		if !offered || a.StartsAt.After(time.Now().AddDate(0, 0, booking.HorizonDays+1)) {
+			return errSlotTaken
+		}
+		for _, tech := range booking.techniciansFor(t.Slug) {
+			var own []Appointment
+			for _, b := range booked {
+				if b.Technician == tech.ID {
+					own = append(own, b)
+				}
+			}
+			if !conflicts(t, a.StartsAt, a.EndsAt, own) && tech.available(a.StartsAt, a.EndsAt) {
+				a.Technician = tech.ID
+				break
+			}
+		}
+		if a.Technician == "" {
+			return errSlotTaken
+		}
+		if err := tx.Create(&a).Error; err != nil {
+			return err
+		}
+		if err := recordConsent(tx, c, consentAppointment, request.PrivacyVersie, a.Email, nil); err != nil {
+			return err
+		}
+		return queueMail(tx, appointmentRequestedMail(a, t))
+	})
+	if errors.Is(err, errSlotTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Helaas, " + err.Error() + "."})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("Uw afspraak is gereserveerd. Bevestig deze binnen %s via de link in de e-mail die wij naar %s hebben gestuurd.",
+			humanDuration(booking.HoldUnconfirmed.Duration), a.Email),
+	})
+}
+
+// available reports whether start to end lies within one of the
+// technician's availability windows.
+func (t Technician) available(start, end time.Time) bool {
+	for _, w := range t.windows(startOfDay(start)) {
+		if !start.Before(w.opens) && !end.After(w.closes) {
+			return true
+		}
+	}
+	return false
+}
+
+var postalCodeInAddress = regexp.MustCompile(`[1-9][0-9]{3} ?[A-Za-z]{2}`)
+
+// validateAppointmentRequest returns a message for the customer when the
+// request is incomplete, or "".
+func validateAppointmentRequest(t AppointmentType, naam, email, adres string, privacy bool) string {
+	switch {
+	case strings.TrimSpace(naam) == "":
+		return "Vul uw naam in."
+	case !validEmail(email):
+		return "Voer een geldig e-mailadres in."
+	case t.OnSite && !postalCodeInAddress.MatchString(adres):
+		return "Vul het adres in waar wij langskomen, inclusief postcode."
+	case !privacy:
+		return "Ga akkoord met het privacybeleid om een afspraak te maken."
+	}
+	return ""
+}
+
+func validEmail(s string) bool {
+	addr, err := mail.ParseAddress(strings.TrimSpace(s))
+	return err == nil && addr.Address == strings.TrimSpace(s)
+}
+
+// humanDuration writes d in Dutch, e.g. "2 uur" or "30 minuten".
+func humanDuration(d time.Duration) string {
+	switch {
+	case d >= time.Hour && d%time.Hour == 0:
+		return fmt.Sprintf("%d uur", d/time.Hour)
+	case d >= time.Hour:
+		return fmt.Sprintf("%d uur en %d minuten", d/time.Hour, d%time.Hour/time.Minute)
+	default:
+		return fmt.Sprintf("%d minuten", d/time.Minute)
+	}
+}
+
+// appointmentActionHandler handles GET and POST of /afspraak/bevestigen and
+// /afspraak/annuleren. GET only shows the appointment with a button, so
+// mail scanners that follow links cannot confirm or cancel it; the button
+// POSTs the same token back.
+func appointmentActionHandler(purpose string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.Query("token")
+		if token == "" {
+			token = c.PostForm("token")
+		}
+		id, err := verifyID(purpose, token)
+		if err != nil {
+			renderAppointmentPage(c, http.StatusBadRequest, nil, "", err.Error())
+			return
+		}
+		var a Appointment
+		if err := db.First(&a, id).Error; err != nil {
+			renderAppointmentPage(c, http.StatusNotFound, nil, "", "Deze afspraak bestaat niet meer.")
+			return
+		}
+		if c.Request.Method == http.MethodGet {
+			renderAppointmentPage(c, http.StatusOK, &a, token, "")
+			return
+		}
+
+		var notice string
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.First(&a, id).Error; err != nil {
+				return err
+			}
+			var err error
+			switch purpose {
+			case tokenAppointmentConfirm:
+				notice, err = confirmAppointment(tx, &a)
+			case tokenAppointmentCancel:
+				notice, err = cancelAppointment(tx, &a)
+			}
+			return err
+		})
+		if err != nil {
+			renderAppointmentPage(c, http.StatusInternalServerError, &a, "", err.Error())
+			return
+		}
+		renderAppointmentPage(c, http.StatusOK, &a, "", notice)
+	}
+}
+
+// confirmAppointment confirms a requested appointment, provided its slot was
+// not released and taken by someone else in the meantime.
+func confirmAppointment(tx *gorm.DB, a *Appointment) (string, error) {
+	switch a.Status {
+	case appointmentConfirmed:
+		return "Uw afspraak was al bevestigd.", nil
+	case appointmentCancelled:
+		return "Deze afspraak is geannuleerd en kan niet meer worden bevestigd.", nil
+	}
+	if a.StartsAt.Before(time.Now()) {
+		return "Deze afspraak ligt in het verleden.", nil
+	}
+	if time.Since(a.CreatedAt) > booking.HoldUnconfirmed.Duration {
+		t, _ := booking.appointmentType(a.Type)
+		buffer := booking.TravelBuffer.Duration
+		booked, err := activeAppointments(tx.Where("technician = ? AND id <> ?", a.Technician, a.ID), a.StartsAt.Add(-buffer), a.EndsAt.Add(buffer))
+		if err != nil {
+			return "", err
+		}
+		if conflicts(t, a.StartsAt, a.EndsAt, booked) {
+			return "Helaas is dit tijdstip inmiddels door iemand anders geboekt. Maak een nieuwe afspraak.", nil
+		}
+	}
+	now := time.Now().UTC()
+	a.Status, a.ConfirmedAt = appointmentConfirmed, &now
+	a.Sequence++
+	if err := tx.Save(a).Error; err != nil {
+		return "", err
+	}
+	if err := queueMail(tx, appointmentConfirmedMail(*a)); err != nil {
+		return "", err
+	}
+	if err := queueMail(tx, appointmentStaffMail(*a, "Nieuwe afspraak")); err != nil {
+		return "", err
+	}
+	return "Uw afspraak is bevestigd. U ontvangt een bevestiging per e-mail met een agenda-uitnodiging.", nil
+}
+
+// cancelAppointment cancels an appointment that has not started yet.
+func cancelAppointment(tx *gorm.DB, a *Appointment) (string, error) {
+	if a.Status == appointmentCancelled {
+		return "Deze afspraak was al geannuleerd.", nil
+	}
+	if a.StartsAt.Before(time.Now()) {
+		return "Deze afspraak is al begonnen en kan niet meer online worden geannuleerd. Neem contact met ons op.", nil
+	}
+	wasConfirmed := a.Status == appointmentConfirmed
+	now := time.Now().UTC()
+	a.Status, a.CancelledAt = appointmentCancelled, &now
+	a.Sequence++
+	if err := tx.Save(a).Error; err != nil {
+		return "", err
+	}
+	if wasConfirmed {
+		if err := queueMail(tx, appointmentCancelledMail(*a)); err != nil {
+			return "", err
+		}
+		if err := queueMail(tx, appointmentStaffMail(*a, "Afspraak geannuleerd")); err != nil {
+			return "", err
+		}
+	}
+	return "Uw afspraak is geannuleerd.", nil
+}
+
+// appointmentView is what the appointment page shows.
+type appointmentView struct {
+	Appointment *Appointment
+	Type        AppointmentType
+	Technician  Technician
+	Token       string // set when the page asks to confirm or cancel
+	Action      string // bevestigen or annuleren
+	Notice      string
+}
+
+func renderAppointmentPage(c *gin.Context, status int, a *Appointment, token, notice string) {
+	v := appointmentView{Appointment: a, Token: token, Notice: notice}
+	if a != nil {
+		v.Type, _ = booking.appointmentType(a.Type)
+		v.Technician, _ = booking.technician(a.Technician)
+	}
+	if strings.HasSuffix(c.Request.URL.Path, "/bevestigen") {
+		v.Action = "bevestigen"
+	} else {
+		v.Action = "annuleren"
+	}
+	c.HTML(status, "afspraak-status", privatePage(c.Request.URL.Path, "Uw afspraak", "afspraak-status", v))
+}
+
+// When formats the appointment time, e.g. "dinsdag 3 maart, 10:00 - 11:30".
+func (a Appointment) When() string {
+	start, end := a.StartsAt.In(openingHoursLocation), a.EndsAt.In(openingHoursLocation)
+	return dutchDate(start) + ", " + start.Format("15:04") + " - " + end.Format("15:04")
+}
+
+// calendarEvent returns the appointment as seen by the customer.
+func (a Appointment) calendarEvent() calendarEvent {
+	t, _ := booking.appointmentType(a.Type)
+	tech, _ := booking.technician(a.Technician)
+	location := a.Adres
+	if !t.OnSite {
+		location = company.AddressLine()
+	}
+	return calendarEvent{
+		UID:         fmt.Sprintf("afspraak-%d@%s", a.ID, mailDomain(company.Email)),
+		Sequence:    a.Sequence,
+		Start:       a.StartsAt,
+		End:         a.EndsAt,
+		Summary:     t.Name + " - " + company.Name,
+		Description: fmt.Sprintf("%s met %s.\nWijzigen of annuleren: %s", t.Name, tech.Name, appointmentLink(tokenAppointmentCancel, a)),
+		Location:    location,
+		Cancelled:   a.Status == appointmentCancelled,
+		Updated:     a.UpdatedAt,
+	}
+}
+
+// appointmentLink returns an absolute, signed link for one of the token
+// purposes. Links stay valid until shortly after the appointment.
+func appointmentLink(purpose string, a Appointment) string {
+	path := map[string]string{
+		tokenAppointmentConfirm:  "/afspraak/bevestigen",
+		tokenAppointmentCancel:   "/afspraak/annuleren",
+		tokenAppointmentCalendar: "/afspraak/agenda.ics",
+	}[purpose]
+	ttl := time.Until(a.EndsAt) + 7*24*time.Hour
+	return site.URL(path + "?token=" + signID(purpose, a.ID, ttl))
+}
+
+// appointmentCalendarHandler handles GET /afspraak/agenda.ics, the
+// customer's own appointment as a calendar file.
+func appointmentCalendarHandler(c *gin.Context) {
+	id, err := verifyID(tokenAppointmentCalendar, c.Query("token"))
+	if err != nil {
+		c.String(http.StatusForbidden, err.Error())
+		return
+	}
+	var a Appointment
+	if err := db.First(&a, id).Error; err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	method := calendarPublish
+	if a.Status == appointmentCancelled {
+		method = calendarCancel
+	}
+	c.Header("Content-Disposition", `attachment; filename="afspraak.ics"`)
+	c.Data(http.StatusOK, "text/calendar; charset=utf-8", buildCalendar(company.Name, method, []calendarEvent{a.calendarEvent()}))
+}
+
+// technicianFeedHandler handles GET /afspraak/feed/<id>.ics?token=..., a
+// calendar feed of a technician's confirmed appointments for their
+// calendar app to subscribe to.
+func technicianFeedHandler(c *gin.Context) {
+	id := strings.TrimSuffix(c.Param("file"), ".ics")
+	subject, err := verifyToken(tokenTechnicianFeed, c.Query("token"))
+	tech, ok := booking.technician(id)
+	if err != nil || subject != id || !ok {
+		c.String(http.StatusForbidden, "ongeldige link")
+		return
+	}
+	var appointments []Appointment
+	err = db.Where("technician = ? AND status IN ? AND ends_at > ?", id,
+		[]string{appointmentConfirmed, appointmentCancelled}, time.Now().UTC().AddDate(0, -1, 0)).
+		Order("starts_at").Find(&appointments).Error
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	events := make([]calendarEvent, len(appointments))
+	for i, a := range appointments {
+		t, _ := booking.appointmentType(a.Type)
+		e := a.calendarEvent()
+		e.Summary = t.Name + ": " + a.Naam
+		e.Description = fmt.Sprintf("%s\nE-mail: %s\nTelefoon: %s\n\n%s", a.Naam, a.Email, a.Telefoon, a.Bericht)
+		events[i] = e
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "text/calendar; charset=utf-8", buildCalendar("Afspraken "+tech.Name, calendarPublish, events))
+}
+
+// technicianFeedURL returns the secret feed URL of a technician. They are
+// printed with -print-feeds.
+func technicianFeedURL(t Technician) string {
+	return site.URL("/afspraak/feed/" + t.ID + ".ics?token=" + signToken(tokenTechnicianFeed, t.ID, 0))
+}
+
+func appointmentRequestedMail(a Appointment, t AppointmentType) *OutboxMessage {
+	return &OutboxMessage{
+		To:      a.Email,
+		Subject: "Bevestig uw afspraak: " + t.Name,
+		Text: fmt.Sprintf(`Beste %s,
+
+U heeft een afspraak aangevraagd:
+
+%s
+%s
+
+Bevestig de afspraak binnen %s via de onderstaande link, anders vervalt de reservering:
+%s
+
+Kunt u toch niet? Annuleer de afspraak dan via:
+%s
+
+Met vriendelijke groet,
+%s
+%s
+`, a.Naam, t.Name, a.When(), humanDuration(booking.HoldUnconfirmed.Duration),
+			appointmentLink(tokenAppointmentConfirm, a), appointmentLink(tokenAppointmentCancel, a),
+			company.Name, company.PhoneDisplay),
+	}
+}
+
+func appointmentConfirmedMail(a Appointment) *OutboxMessage {
+	t, _ := booking.appointmentType(a.Type)
+	tech, _ := booking.technician(a.Technician)
+	where := "bij u op " + a.Adres
+	if !t.OnSite {
+		where = "bij " + company.Name + ", " + company.AddressLine()
+	}
+	return &OutboxMessage{
+		To:      a.Email,
+		Subject: "Afspraak bevestigd: " + a.When(),
+		Text: fmt.Sprintf(`Beste %s,
+
+Uw afspraak is bevestigd:
+
+%s met %s
+%s
+%s
+
+De agenda-uitnodiging zit als bijlage bij deze e-mail. U kunt deze ook downloaden via:
+%s
+
+Kunt u toch niet? Annuleer de afspraak dan via:
+%s
+
+Met vriendelijke groet,
+%s
+%s
+`, a.Naam, t.Name, tech.Name, a.When(), where,
+			appointmentLink(tokenAppointmentCalendar, a), appointmentLink(tokenAppointmentCancel, a),
+			company.Name, company.PhoneDisplay),
+		Attachments: []OutboxAttachment{{
+			Filename:    "afspraak.ics",
+			ContentType: "text/calendar; charset=utf-8; method=PUBLISH",
+			Data:        buildCalendar(company.Name, calendarPublish, []calendarEvent{a.calendarEvent()}),
+		}},
+	}
+}
+
+func appointmentCancelledMail(a Appointment) *OutboxMessage {
+	t, _ := booking.appointmentType(a.Type)
+	return &OutboxMessage{
+		To:      a.Email,
+		Subject: "Afspraak geannuleerd: " + a.When(),
+		Text: fmt.Sprintf(`Beste %s,
+
+Uw afspraak is geannuleerd:
+
+%s
+%s
+
+Wilt u een nieuwe afspraak maken? Dat kan via %s.
+
+Met vriendelijke groet,
+%s
+`, a.Naam, t.Name, a.When(), site.URL("/afspraak"), company.Name),
+		Attachments: []OutboxAttachment{{
+			Filename:    "afspraak.ics",
+			ContentType: "text/calendar; charset=utf-8; method=CANCEL",
+			Data:        buildCalendar(company.Name, calendarCancel, []calendarEvent{a.calendarEvent()}),
+		}},
+	}
+}
+
+// appointmentStaffMail tells the company about a confirmed or cancelled
+// appointment. Technicians see it in their feed as well.
+func appointmentStaffMail(a Appointment, subject string) *OutboxMessage {
+	t, _ := booking.appointmentType(a.Type)
+	tech, _ := booking.technician(a.Technician)
+	return &OutboxMessage{
+		To:      company.Email,
+		Subject: fmt.Sprintf("%s: %s, %s", subject, t.Name, a.When()),
+		Text: fmt.Sprintf(`%s
+
+Type: %s
+Monteur: %s
+Tijd: %s
+Naam: %s
+E-mail: %s
+Telefoon: %s
+Adres: %s
+
+%s
+`, subject, t.Name, tech.Name, a.When(), a.Naam, a.Email, a.Telefoon, a.Adres, a.Bericht),
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testBooking replaces booking with a workshop appointment that Jan and
+// Piet take and a home visit that only Jan makes. Jan works 9:00 to 12:00
+// on weekdays, Piet only 9:00 to 10:00 on Mondays.
+func testBooking(t *testing.T) {
+	t.Helper()
+	saved := booking
+	t.Cleanup(func() { booking = saved })
+	weekdays := Weekdays{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+	booking = bookingConfig{
+		Types: []AppointmentType{
+			{Slug: "werkplaats", Name: "Werkplaats", Duration: configDuration{time.Hour}},
+			{Slug: "aan-huis", Name: "Aan huis", Duration: configDuration{time.Hour}, OnSite: true},
+		},
+		Technicians: []Technician{
+			{ID: "jan", Name: "Jan", Types: []string{"werkplaats", "aan-huis"},
+				Availability: []Availability{{Days: weekdays, From: "09:00", Until: "12:00"}}},
+			{ID: "piet", Name: "Piet", Types: []string{"werkplaats"},
+				Availability: []Availability{{Days: Weekdays{time.Monday}, From: "09:00", Until: "10:00"}}},
+		},
+		SlotInterval:    configDuration{30 * time.Minute},
+		TravelBuffer:    configDuration{30 * time.Minute},
+		HorizonDays:     60,
+		HoldUnconfirmed: configDuration{time.Hour},
+	}
+	if err := booking.validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// slotLabels returns the labels of slots, e.g. "09:00 09:30".
+func slotLabels(slots []Slot) string {
+	var labels []string
+	for _, s := range slots {
+		labels = append(labels, s.Label)
+	}
+	return strings.Join(labels, " ")
+}
+
+func TestAvailableSlots(t *testing.T) {
+	testBooking(t)
+	monday := dateOf(2030, time.January, 7)
+	at := func(clock string) time.Time {
+		c, _ := time.Parse("15:04", clock)
+		return monday.Add(time.Duration(c.Hour())*time.Hour + time.Duration(c.Minute())*time.Minute)
+	}
+	booked := func(technician, slug, from, until string) Appointment {
+		return Appointment{Technician: technician, Type: slug, StartsAt: at(from), EndsAt: at(until)}
+	}
+	tests := []struct {
+		name   string
+		slug   string
+		day    time.Time
+		booked []Appointment
+		want   string
+	}{
+		{"free", "werkplaats", monday, nil, "09:00 09:30 10:00 10:30 11:00"},
+		{"Jan busy, Piet free", "werkplaats", monday, []Appointment{booked("jan", "werkplaats", "09:00", "10:00")}, "09:00 10:00 10:30 11:00"},
+		{"both busy", "werkplaats", monday, []Appointment{booked("jan", "werkplaats", "09:00", "10:00"), booked("piet", "werkplaats", "09:00", "10:00")}, "10:00 10:30 11:00"},
+		{"travel time", "aan-huis", monday, []Appointment{booked("jan", "werkplaats", "11:00", "12:00")}, "09:00 09:30"},
+		{"travel time after a visit", "werkplaats", monday, []Appointment{booked("jan", "aan-huis", "09:00", "10:00"), booked("piet", "werkplaats", "09:00", "10:00")}, "10:30 11:00"},
+		{"other technician busy", "aan-huis", monday, []Appointment{booked("piet", "werkplaats", "09:00", "10:00")}, "09:00 09:30 10:00 10:30 11:00"},
+		{"Tuesday, Jan only", "werkplaats", monday.AddDate(0, 0, 1), nil, "09:00 09:30 10:00 10:30 11:00"},
+		{"Saturday", "werkplaats", monday.AddDate(0, 0, 5), nil, ""},
+		{"public holiday", "werkplaats", dateOf(2030, time.January, 1), nil, ""},
+	}
+	for _, tt := range tests {
+		tp, _ := booking.appointmentType(tt.slug)
+		if got := slotLabels(availableSlots(tp, tt.day, tt.day.AddDate(0, 0, 1), tt.booked)); got != tt.want {
+			t.Errorf("%s: slots %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// nextBookableMonday returns a Monday that is no public holiday, a few days
+// from now.
+func nextBookableMonday() time.Time {
+	day := startOfDay(time.Now()).AddDate(0, 0, 2)
+	for day.Weekday() != time.Monday {
+		day = day.AddDate(0, 0, 1)
+	}
+	for {
+		if _, closed := company.closedReason(day); !closed {
+			return day
+		}
+		day = day.AddDate(0, 0, 7)
+	}
+}
+
+// postAppointment books an appointment of type slug at start and returns
+// the status of the response.
+func postAppointment(t *testing.T, slug string, start time.Time, version string) int {
+	t.Helper()
+	body, err := json.Marshal(map[string]any{
+		"type": slug, "start": start, "naam": "Jan Jansen", "email": "jan@example.nl",
+		"adres": "Stationsstraat 1, 6961 AA Eerbeek", "privacy": true, "privacy_versie": version,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/afspraak", strings.NewReader(string(body)))
+	c.Request.Header.Set("Content-Type", "application/json")
+	appointmentPostHandler(c)
+	return w.Code
+}
+
+func TestAppointmentPostHandler(t *testing.T) {
+	testDB(t, &Appointment{}, &OutboxMessage{}, &ConsentRecord{})
+	testSecret(t)
+	testPolicies(t, "2024-01-01", "2026-01-01")
+	testBooking(t)
+	monday := nextBookableMonday()
+	nine := monday.Add(9 * time.Hour)
+
+	tests := []struct {
+		name    string
+		slug    string
+		start   time.Time
+		version string
+		want    int
+	}{
+		{"no policy version", "aan-huis", nine, "", http.StatusBadRequest},
+		{"earlier policy version", "aan-huis", nine, "2024-01-01", http.StatusBadRequest},
+		{"not a slot", "aan-huis", nine.Add(10 * time.Minute), "2026-01-01", http.StatusConflict},
+		{"outside availability", "aan-huis", nine.Add(-time.Hour), "2026-01-01", http.StatusConflict},
+		{"beyond the horizon", "aan-huis", nine.AddDate(0, 0, 70), "2026-01-01", http.StatusConflict},
+		{"home visit with Jan", "aan-huis", nine, "2026-01-01", http.StatusOK},
+		{"same visit again", "aan-huis", nine, "2026-01-01", http.StatusConflict},
+		{"overlapping visit", "aan-huis", nine.Add(30 * time.Minute), "2026-01-01", http.StatusConflict},
+		{"workshop with Piet", "werkplaats", nine, "2026-01-01", http.StatusOK},
+		{"workshop, both busy", "werkplaats", nine, "2026-01-01", http.StatusConflict},
+		{"workshop within Jan's travel time", "werkplaats", nine.Add(time.Hour), "2026-01-01", http.StatusConflict},
+		{"workshop after Jan's travel time", "werkplaats", nine.Add(90 * time.Minute), "2026-01-01", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if got := postAppointment(t, tt.slug, tt.start, tt.version); got != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	var appointments []Appointment
+	if err := db.Order("id").Find(&appointments).Error; err != nil {
+		t.Fatal(err)
+	}
+	var technicians []string
+	for _, a := range appointments {
+		technicians = append(technicians, a.Technician)
+	}
+	if got := strings.Join(technicians, " "); got != "jan piet jan" {
+		t.Errorf("booked with %q, want %q", got, "jan piet jan")
+	}
+	var consents []ConsentRecord
+	if err := db.Find(&consents).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(consents) != 3 || consents[0].Purpose != consentAppointment || consents[0].PolicyVersion != "2026-01-01" ||
+		consents[0].Email != "jan@example.nl" {
+		t.Errorf("consent records: %+v", consents)
+	}
+
+	// a request that was never confirmed releases its slot after the hold,
+	// as does a cancelled appointment
+	expired := time.Now().UTC().Add(-2 * time.Hour)
+	if err := db.Model(&appointments[0]).UpdateColumn("created_at", expired).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Model(&appointments[1]).UpdateColumn("status", appointmentCancelled).Error; err != nil {
+		t.Fatal(err)
+	}
+	if got := postAppointment(t, "aan-huis", nine, "2026-01-01"); got != http.StatusOK {
+		t.Errorf("home visit after the hold expired: status %d, want %d", got, http.StatusOK)
+	}
+	if got := postAppointment(t, "werkplaats", nine, "2026-01-01"); got != http.StatusOK {
+		t.Errorf("workshop after a cancellation: status %d, want %d", got, http.StatusOK)
+	}
+}
+
+func TestAppointmentPostHandlerConcurrent(t *testing.T) {
+	testDB(t, &Appointment{}, &OutboxMessage{}, &ConsentRecord{})
+	testSecret(t)
+	testPolicies(t, "2026-01-01")
+	testBooking(t)
+	// a Tuesday, when only Jan makes home visits
+	start := nextBookableMonday().AddDate(0, 0, 1).Add(10 * time.Hour)
+	for {
+		if _, closed := company.closedReason(start); !closed {
+			break
+		}
+		start = start.AddDate(0, 0, 7)
+	}
+
+	const customers = 8
+	statuses := make(chan int, customers)
+	var wg sync.WaitGroup
+	for i := 0; i < customers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses <- postAppointment(t, "aan-huis", start, "2026-01-01")
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+	counts := make(map[int]int)
+	for status := range statuses {
+		counts[status]++
+	}
+	if counts[http.StatusOK] != 1 || counts[http.StatusConflict] != customers-1 {
+		t.Errorf("statuses of %d simultaneous bookings: %v, want one %d", customers, counts, http.StatusOK)
+	}
+	var n int64
+	if err := db.Model(&Appointment{}).Count(&n).Error; err != nil || n != 1 {
+		t.Errorf("%d appointments, %v, want 1", n, err)
+	}
+}
@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+	"gorm.io/gorm"
+)
+
+// Campaign is a newsletter: written in Markdown, tried out with test sends
+// and then queued for every active subscriber.
+type Campaign struct {
+	ID         uint   `gorm:"primaryKey"`
+	Subject    string `gorm:"not null"`
+	Preheader  string // preview text shown next to the subject in inboxes
+	Body       string `gorm:"not null"` // Markdown
+	Status     string `gorm:"not null;index"`
+	Recipients int
+	TestSentAt *time.Time
+	SentAt     *time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// Campaign statuses.
+const (
+	campaignDraft = "concept"
+	campaignSent  = "verzonden"
+)
+
+// Editable reports whether the campaign can still be changed.
+func (c Campaign) Editable() bool {
+	return c.Status == campaignDraft
+}
+
+// Tested reports whether a test was sent of the current text.
+func (c Campaign) Tested() bool {
+	return c.TestSentAt != nil && !c.TestSentAt.Before(c.UpdatedAt)
+}
+
+// CampaignStats counts what became of the messages of a campaign.
+type CampaignStats struct {
+	Queued       int64
+	Sent         int64
+	Failed       int64
+	Cancelled    int64
+	Bounced      int64
+	Complaints   int64
+	Unsubscribes int64
+}
+
+// campaignStats collects the delivery statistics of campaign id from the
+// outbox and the mail events.
+func campaignStats(id uint) (CampaignStats, error) {
+	var stats CampaignStats
+	var rows []struct {
+		Key   string
+		Count int64
+	}
+	err := db.Model(&OutboxMessage{}).Select("status AS key, count(*) AS count").
+		Where("campaign_id = ?", id).Group("status").Scan(&rows).Error
+	if err != nil {
+		return stats, err
+	}
+	for _, r := range rows {
+		switch r.Key {
+		case outboxQueued:
+			stats.Queued = r.Count
+		case outboxSent:
+			stats.Sent = r.Count
+		case outboxFailed:
+			stats.Failed = r.Count
+		case outboxCancelled:
+			stats.Cancelled = r.Count
+		}
+	}
+	rows = nil
+	err = db.Model(&MailEvent{}).Select("type AS key, count(DISTINCT email) AS count").
+		Where("campaign_id = ?", id).Group("type").Scan(&rows).Error
+	if err != nil {
+		return stats, err
+	}
+	for _, r := range rows {
+		switch r.Key {
+		case mailEventBounce, mailEventSoftBounce:
+			stats.Bounced += r.Count
+		case mailEventComplaint:
+			stats.Complaints = r.Count
+		case mailEventUnsubscribe:
+			stats.Unsubscribes = r.Count
+		}
+	}
+	return stats, nil
+}
+
+// campaignMailer turns campaigns into e-mail: the Markdown becomes the body
+// of a responsive HTML layout, and a plain-text part is derived from it for
+// mail clients that do not show HTML.
+type campaignMailer struct {
+	layout   *template.Template
+	markdown goldmark.Markdown
+}
+
+func newCampaignMailer(templateFS fs.FS) (*campaignMailer, error) {
+	layout, err := template.New("nieuwsbrief.html").Funcs(template.FuncMap{
+		"company": func() CompanyProfile { return company },
+		"siteURL": site.URL,
+	}).ParseFS(templateFS, "mail/nieuwsbrief.html")
+	if err != nil {
+		return nil, err
+	}
+	return &campaignMailer{
+		layout: layout,
+		markdown: goldmark.New(
+			goldmark.WithExtensions(extension.GFM),
+			goldmark.WithParserOptions(parser.WithASTTransformers(util.Prioritized(absoluteLinks{}, 100))),
+		),
+	}, nil
+}
+
+// absoluteLinks makes links and images relative to the site absolute, as
+// they would point nowhere in a mail client.
+type absoluteLinks struct{}
+
+func (absoluteLinks) Transform(doc *ast.Document, _ text.Reader, _ parser.Context) {
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Link:
+			n.Destination = []byte(absoluteURL(string(n.Destination)))
+		case *ast.Image:
+			n.Destination = []byte(absoluteURL(string(n.Destination)))
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+func absoluteURL(u string) string {
+	if strings.HasPrefix(u, "/") && !strings.HasPrefix(u, "//") {
+		return site.URL(u)
+	}
+	return u
+}
+
+// campaignContent is a campaign rendered once, before it is addressed to
+// each subscriber.
+type campaignContent struct {
+	Campaign Campaign
+	HTML     template.HTML
+	Text     string
+}
+
+func (m *campaignMailer) content(c Campaign) (campaignContent, error) {
+	var buf bytes.Buffer
+	if err := m.markdown.Convert([]byte(c.Body), &buf); err != nil {
+		return campaignContent{}, err
+	}
+	// goldmark leaves out raw HTML, so the output is safe to embed
+	return campaignContent{Campaign: c, HTML: template.HTML(buf.String()), Text: markdownText(c.Body)}, nil
+}
+
+// message addresses rendered content to one recipient, with the link that
+// unsubscribes them.
+func (m *campaignMailer) message(content campaignContent, to, unsubscribe string) (*OutboxMessage, error) {
+	var buf bytes.Buffer
+	err := m.layout.Execute(&buf, map[string]any{
+		"Subject":     content.Campaign.Subject,
+		"Preheader":   content.Campaign.Preheader,
+		"Body":        content.HTML,
+		"Unsubscribe": unsubscribe,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &OutboxMessage{
+		To:      to,
+		Subject: content.Campaign.Subject,
+		HTML:    buf.String(),
+		Text: fmt.Sprintf("%s\n\n-- \nU ontvangt deze nieuwsbrief omdat u zich heeft aangemeld bij %s.\nAfmelden: %s\n",
+			content.Text, company.Name, unsubscribe),
+		Headers: strings.Join([]string{
+			"List-Unsubscribe: <" + unsubscribe + ">",
+			"List-Unsubscribe-Post: List-Unsubscribe=One-Click",
+			"List-Id: Nieuwsbrief " + company.Name + " <nieuwsbrief." + mailDomain(mailer.From.Address) + ">",
+			"Precedence: bulk",
+		}, "\n"),
+	}, nil
+}
+
+var (
+	markdownImage    = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	markdownLink     = regexp.MustCompile(`\[([^\]]+)\]\(\s*(\S+?)(?:\s+"[^"]*")?\s*\)`)
+	markdownHeading  = regexp.MustCompile(`(?m)^#{1,6}[ \t]+(.*?)[ \t]*#*[ \t]*$`)
+	markdownEmphasis = regexp.MustCompile(`\*\*|__|~~`)
+)
+
+// markdownText turns Markdown into plain text: Markdown reads well as it is,
+// so only the markup that gets in the way is removed and links are spelled
+// out.
+func markdownText(md string) string {
+	md = strings.ReplaceAll(md, "\r\n", "\n")
+	md = markdownImage.ReplaceAllString(md, "$1")
+	md = markdownLink.ReplaceAllStringFunc(md, func(s string) string {
+		m := markdownLink.FindStringSubmatch(s)
+		if m[1] == m[2] {
+			return absoluteURL(m[2])
+		}
+		return m[1] + " (" + absoluteURL(m[2]) + ")"
+	})
+	md = markdownHeading.ReplaceAllStringFunc(md, func(s string) string {
+		title := markdownHeading.FindStringSubmatch(s)[1]
+		return title + "\n" + strings.Repeat("=", len([]rune(title)))
+	})
+	return strings.TrimSpace(markdownEmphasis.ReplaceAllString(md, ""))
+}
+
+// campaignUnsubscribeLink is unsubscribeLink naming the campaign, so the
+// campaign statistics show who left the list after reading it.
+func campaignUnsubscribeLink(s Subscriber, campaignID uint) string {
+	return unsubscribeLink(s) + "&campagne=" + strconv.FormatUint(uint64(campaignID), 10)
+}
+
+// adminCampaignsHandler handles GET /admin/nieuwsbrief/campagnes.
+func adminCampaignsHandler(c *gin.Context) {
+	var campaigns []Campaign
+	if err := db.Order("created_at DESC").Find(&campaigns).Error; err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	renderAdmin(c, http.StatusOK, "campagnes", "nieuwsbrief", "Nieuwsbriefcampagnes", campaigns)
+}
+
+// adminNewCampaignHandler handles GET /admin/nieuwsbrief/campagnes/nieuw.
+func adminNewCampaignHandler(c *gin.Context) {
+	renderAdminCampaign(c, http.StatusOK, Campaign{Status: campaignDraft}, "")
+}
+
+func renderAdminCampaign(c *gin.Context, status int, campaign Campaign, errMsg string) {
+	title := "Nieuwe campagne"
+	data := gin.H{"Campaign": campaign, "Error": errMsg}
+	if campaign.ID != 0 {
+		title = campaign.Subject
+		stats, err := campaignStats(campaign.ID)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		var active int64
+		if err := db.Model(&Subscriber{}).Where("status = ?", subscriberActive).Count(&active).Error; err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		data["Stats"] = stats
+		data["Active"] = active
+		data["Text"] = markdownText(campaign.Body)
+		data["TestAddress"] = company.Email
+	}
+	renderAdmin(c, status, "campagne", "nieuwsbrief", title, data)
+}
+
+// applyCampaignForm copies the posted form onto campaign.
+func applyCampaignForm(c *gin.Context, campaign *Campaign) error {
+	campaign.Subject = strings.TrimSpace(c.PostForm("onderwerp"))
+	campaign.Preheader = strings.TrimSpace(c.PostForm("voorvertoning"))
+	campaign.Body = strings.TrimSpace(c.PostForm("tekst"))
+	if campaign.Subject == "" || campaign.Body == "" {
+		return errors.New("vul een onderwerp en de tekst van de nieuwsbrief in")
+	}
+	return nil
+}
+
+// adminCreateCampaignHandler handles POST /admin/nieuwsbrief/campagnes.
+func adminCreateCampaignHandler(c *gin.Context) {
+	campaign := Campaign{Status: campaignDraft}
+	if err := applyCampaignForm(c, &campaign); err != nil {
+		renderAdminCampaign(c, http.StatusBadRequest, campaign, err.Error())
+		return
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&campaign).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, "campagne.aangemaakt", "campagne", fmt.Sprint(campaign.ID), auditDiff(nil, auditFields(campaign)))
+	})
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	redirectAdmin(c, fmt.Sprintf("/admin/nieuwsbrief/campagnes/%d", campaign.ID), "Concept opgeslagen.")
+}
+
+// loadCampaign loads the campaign named by the :id parameter, or responds
+// with 404.
+func loadCampaign(c *gin.Context) (Campaign, bool) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	var campaign Campaign
+	if err := db.First(&campaign, id).Error; err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return campaign, false
+	}
+	return campaign, true
+}
+
+// adminCampaignHandler handles GET /admin/nieuwsbrief/campagnes/:id.
+func adminCampaignHandler(c *gin.Context) {
+	campaign, ok := loadCampaign(c)
+	if !ok {
+		return
+	}
+	renderAdminCampaign(c, http.StatusOK, campaign, "")
+}
+
+// adminUpdateCampaignHandler handles POST /admin/nieuwsbrief/campagnes/:id.
+// A sent campaign stays as it was sent.
+func adminUpdateCampaignHandler(c *gin.Context) {
+	campaign, ok := loadCampaign(c)
+	if !ok {
+		return
+	}
+	if !campaign.Editable() {
+		renderAdminCampaign(c, http.StatusConflict, campaign, "Een verzonden campagne kan niet meer worden gewijzigd.")
+		return
+	}
+	before := auditFields(campaign)
+	if err := applyCampaignForm(c, &campaign); err != nil {
+		renderAdminCampaign(c, http.StatusBadRequest, campaign, err.Error())
+		return
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&campaign).Select("subject", "preheader", "body").Updates(&campaign).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, "campagne.gewijzigd", "campagne", fmt.Sprint(campaign.ID), auditDiff(before, auditFields(campaign)))
+	})
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	redirectAdmin(c, fmt.Sprintf("/admin/nieuwsbrief/campagnes/%d", campaign.ID), "Concept opgeslagen.")
+}
+
+// adminCampaignPreviewHandler handles GET
+// /admin/nieuwsbrief/campagnes/:id/voorbeeld: the HTML e-mail as subscribers
+// will see it.
+func adminCampaignPreviewHandler(m *campaignMailer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		campaign, ok := loadCampaign(c)
+		if !ok {
+			return
+		}
+		content, err := m.content(campaign)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		msg, err := m.message(content, company.Email, site.URL("/nieuwsbrief"))
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(msg.HTML))
+	}
+}
+
+// adminTestCampaignHandler handles POST /admin/nieuwsbrief/campagnes/:id/test:
+// the campaign is mailed to the given address only. Its unsubscribe link
+// leads to the sign-up page, as the address need not be a subscriber.
+func adminTestCampaignHandler(m *campaignMailer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		campaign, ok := loadCampaign(c)
+		if !ok {
+			return
+		}
+		to := normalizeEmail(c.PostForm("email"))
+		if !validEmail(to) {
+			renderAdminCampaign(c, http.StatusBadRequest, campaign, "Vul een geldig e-mailadres in voor de testmail.")
+			return
+		}
+		content, err := m.content(campaign)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		msg, err := m.message(content, to, site.URL("/nieuwsbrief"))
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		msg.Subject = "[TEST] " + msg.Subject
+		err = db.Transaction(func(tx *gorm.DB) error {
+			// UpdateColumn leaves updated_at alone, which marks the text as tested
+			tested, now := campaign.TestSentAt, time.Now().UTC()
+			if err := tx.Model(&campaign).UpdateColumn("test_sent_at", now).Error; err != nil {
+				return err
+			}
+			// not the address: it may be anyone's
+			if err := recordAudit(tx, c, "campagne.test", "campagne", fmt.Sprint(campaign.ID), auditChanges{"TestSentAt": {tested, now}}); err != nil {
+				return err
+			}
+			return queueMail(tx, msg)
+		})
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		redirectAdmin(c, fmt.Sprintf("/admin/nieuwsbrief/campagnes/%d", campaign.ID), "Testmail verzonden naar "+to+".")
+	}
+}
+
+// adminSendCampaignHandler handles POST
+// /admin/nieuwsbrief/campagnes/:id/verzenden. A message is queued for every
+// active subscriber, spread out over time at mailer.CampaignRate messages a
+// minute; the mailer skips subscribers who leave the list in the meantime.
+func adminSendCampaignHandler(m *campaignMailer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		campaign, ok := loadCampaign(c)
+		if !ok {
+			return
+		}
+		path := fmt.Sprintf("/admin/nieuwsbrief/campagnes/%d", campaign.ID)
+		if !campaign.Tested() {
+			renderAdminCampaign(c, http.StatusConflict, campaign, "Verstuur eerst een testmail van de laatste versie.")
+			return
+		}
+		content, err := m.content(campaign)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		var recipients int
+		err = db.Transaction(func(tx *gorm.DB) error {
+			// checked again inside the transaction, so a double click sends once
+			var current Campaign
+			if err := tx.First(&current, campaign.ID).Error; err != nil {
+				return err
+			}
+			if !current.Editable() {
+				return errCampaignSent
+			}
+			var subscribers []Subscriber
+			if err := tx.Where("status = ?", subscriberActive).Order("id").Find(&subscribers).Error; err != nil {
+				return err
+			}
+			if len(subscribers) == 0 {
+				return errNoSubscribers
+			}
+			now := time.Now().UTC()
+			for i, s := range subscribers {
+				msg, err := m.message(content, s.Email, campaignUnsubscribeLink(s, campaign.ID))
+				if err != nil {
+					return err
+				}
+				msg.CampaignID = &campaign.ID
+				msg.SendAfter = now.Add(time.Duration(i/mailer.CampaignRate) * time.Minute)
+				if err := queueMail(tx, msg); err != nil {
+					return err
+				}
+			}
+			recipients = len(subscribers)
+			err := tx.Model(&campaign).UpdateColumns(map[string]any{
+				"status":     campaignSent,
+				"sent_at":    now,
+				"recipients": recipients,
+			}).Error
+			if err != nil {
+				return err
+			}
+			return recordAudit(tx, c, "campagne.verzonden", "campagne", fmt.Sprint(campaign.ID), auditChanges{
+				"Status":     {current.Status, campaignSent},
+				"Recipients": {nil, recipients},
+			})
+		})
+		switch {
+		case errors.Is(err, errCampaignSent), errors.Is(err, errNoSubscribers):
+			renderAdminCampaign(c, http.StatusConflict, campaign, err.Error())
+		case err != nil:
+			c.AbortWithError(http.StatusInternalServerError, err)
+		default:
+			minutes := (recipients + mailer.CampaignRate - 1) / mailer.CampaignRate
+			redirectAdmin(c, path, fmt.Sprintf("Campagne in de wachtrij gezet voor %d abonnees; verzenden duurt ongeveer %d minuten.", recipients, minutes))
+		}
+	}
+}
+
+var (
+	errCampaignSent  = errors.New("deze campagne is al verzonden")
+	errNoSubscribers = errors.New("er zijn nog geen actieve abonnees")
+)
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// CompanyProfile holds the company details shown on the site, in its
+// structured data and in the chat assistant's prompt. It is loaded from
+// config/company.json (or the file given with -company) and is the single
+// place these details are defined.
+type CompanyProfile struct {
+	Name         string         `json:"name"`
+	Description  string         `json:"description"`
+	Email        string         `json:"email"`
+	PrivacyEmail string         `json:"privacy_email"`
+	Phone        string         `json:"phone"`         // E.164, e.g. +31643138103
+	PhoneDisplay string         `json:"phone_display"` // as printed on the site
+	Address      PostalAddress  `json:"address"`
+	Geo          GeoCoordinates `json:"geo"`
+	KvK          string         `json:"kvk"` // Kamer van Koophandel number
+	BTW          string         `json:"btw"` // VAT identification number
+	OpeningHours []OpeningHours `json:"opening_hours"`
+	Closures     []Closure      `json:"closures"` // besides public holidays
+	SocialLinks  []SocialLink   `json:"social_links"`
+	Logo         string         `json:"logo"` // path relative to static/
+}
+
+// PostalAddress is the company's visiting address.
+type PostalAddress struct {
+	Street     string `json:"street"`
+	PostalCode string `json:"postal_code"`
+	Locality   string `json:"locality"`
+	Region     string `json:"region"`
+	Country    string `json:"country"` // ISO 3166-1 alpha-2
+}
+
+// GeoCoordinates locate the company on a map.
+type GeoCoordinates struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// MapURL opens the location in Google Maps.
+func (g GeoCoordinates) MapURL() string {
+	return fmt.Sprintf("https://www.google.com/maps/search/?api=1&query=%g,%g", g.Latitude, g.Longitude)
+}
+
+// EmbedURL shows the location in an iframe. Google sets its own cookies
+// there, so it is only embedded with consent for marketing cookies.
+func (g GeoCoordinates) EmbedURL() string {
+	return fmt.Sprintf("https://maps.google.com/maps?q=%g,%g&z=15&output=embed", g.Latitude, g.Longitude)
+}
+
+// OpeningHours is a regular weekly opening period, e.g. Monday to Friday
+// from 09:00 to 17:00.
+type OpeningHours struct {
+	Days   Weekdays `json:"days"`
+	Opens  string   `json:"opens"`  // HH:MM
+	Closes string   `json:"closes"` // HH:MM
+}
+
+// Closure is an ad-hoc closure, such as a holiday week, from the first up to
+// and including the last day.
+type Closure struct {
+	From   string `json:"from"`  // YYYY-MM-DD
+	Until  string `json:"until"` // YYYY-MM-DD, defaults to From
+	Reason string `json:"reason"`
+}
+
+// SocialLink is a company profile on a social network. Network doubles as
+// the icon name.
+type SocialLink struct {
+	Network string `json:"network"`
+	URL     string `json:"url"`
+}
+
+// Weekdays decodes the Dutch day abbreviations used in the configuration
+// ("ma" through "zo").
+type Weekdays []time.Weekday
+
+// dutchDays maps time.Weekday to its Dutch abbreviation.
+var dutchDays = [...]string{"zo", "ma", "di", "wo", "do", "vr", "za"}
+
+func (w *Weekdays) UnmarshalJSON(b []byte) error {
+	var names []string
+	if err := json.Unmarshal(b, &names); err != nil {
+		return err
+	}
+	days := make(Weekdays, 0, len(names))
+	for _, name := range names {
+		day := -1
+		for i, abbr := range dutchDays {
+			if strings.EqualFold(name, abbr) {
+				day = i
+			}
+		}
+		if day < 0 {
+			return fmt.Errorf("unknown day %q, use ma, di, wo, do, vr, za or zo", name)
+		}
+		days = append(days, time.Weekday(day))
+	}
+	*w = days
+	return nil
+}
+
+var company CompanyProfile
+
+// initCompanyProfile loads the company profile and stops the application
+// when it contains malformed values.
+func initCompanyProfile(path string) {
+	b := defaultCompanyConfig
+	if path != "" {
+		var err error
+		if b, err = os.ReadFile(path); err != nil {
+			log.Fatalf("Failed to read company profile: %v", err)
+		}
+	}
+	p, err := parseCompanyProfile(b)
+	if err != nil {
+		log.Fatalf("Invalid company profile:\n%v", err)
+	}
+	company = p
+}
+
+func parseCompanyProfile(b []byte) (CompanyProfile, error) {
+	var p CompanyProfile
+	dec := json.NewDecoder(strings.NewReader(string(b)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return p, err
+	}
+	return p, p.validate()
+}
+
+var (
+	e164Pattern       = regexp.MustCompile(`^\+[1-9][0-9]{7,14}$`)
+	kvkPattern        = regexp.MustCompile(`^[0-9]{8}$`)
+	btwPattern        = regexp.MustCompile(`^NL[0-9]{9}B[0-9]{2}$`)
+	postalCodePattern = regexp.MustCompile(`^[1-9][0-9]{3} ?[A-Z]{2}$`)
+	clockPattern      = regexp.MustCompile(`^([01][0-9]|2[0-3]):[0-5][0-9]$`)
+)
+
+// validate reports every malformed value at once, so a broken configuration
+// can be fixed in one go.
+func (p CompanyProfile) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(p.Name != "", "name is required")
+	for field, email := range map[string]string{"email": p.Email, "privacy_email": p.PrivacyEmail} {
+		addr, err := mail.ParseAddress(email)
+		check(err == nil && addr.Address == email, "%s %q is not a valid e-mail address", field, email)
+	}
+	check(e164Pattern.MatchString(p.Phone), "phone %q is not in E.164 form, e.g. +31612345678", p.Phone)
+	check(phoneDigits(p.PhoneDisplay) == strings.TrimPrefix(p.Phone, "+"),
+		"phone_display %q does not match phone %q", p.PhoneDisplay, p.Phone)
+	check(p.Address.Locality != "", "address.locality is required")
+	check(len(p.Address.Country) == 2 && strings.ToUpper(p.Address.Country) == p.Address.Country,
+		"address.country %q is not an ISO 3166-1 alpha-2 code", p.Address.Country)
+	check(p.Address.PostalCode == "" || postalCodePattern.MatchString(p.Address.PostalCode),
+		"address.postal_code %q is not a Dutch postal code", p.Address.PostalCode)
+	check(p.Geo.Latitude >= -90 && p.Geo.Latitude <= 90 && p.Geo.Longitude >= -180 && p.Geo.Longitude <= 180,
+		"geo coordinates are out of range")
+	check(p.KvK == "" || kvkPattern.MatchString(p.KvK), "kvk %q must be 8 digits", p.KvK)
+	check(p.BTW == "" || btwPattern.MatchString(p.BTW), "btw %q must look like NL123456789B01", p.BTW)
+	for i, h := range p.OpeningHours {
+		check(len(h.Days) > 0, "opening_hours[%d] has no days", i)
+		check(clockPattern.MatchString(h.Opens) && clockPattern.MatchString(h.Closes) && h.Opens < h.Closes,
+			"opening_hours[%d] must open before it closes, as HH:MM", i)
+	}
+	for i, c := range p.Closures {
+		from, err1 := time.Parse(time.DateOnly, c.From)
+		until, err2 := from, error(nil)
+		if c.Until != "" {
+			until, err2 = time.Parse(time.DateOnly, c.Until)
+		}
+		check(err1 == nil && err2 == nil && !until.Before(from) && c.Reason != "",
+			"closures[%d] needs a reason and dates as YYYY-MM-DD, from before until", i)
+	}
+	for i, l := range p.SocialLinks {
+		u, err := url.Parse(l.URL)
+		check(l.Network != "" && err == nil && u.Scheme == "https" && u.Host != "",
+			"social_links[%d] needs a network and an https URL", i)
+	}
+	check(p.Logo != "", "logo is required")
+	return errors.Join(errs...)
+}
+
+// phoneDigits returns the international digits of a displayed Dutch phone
+// number, dropping the trunk prefix written as "(0)".
+func phoneDigits(display string) string {
+	display = strings.ReplaceAll(display, "(0)", "")
+	var b strings.Builder
+	for _, r := range display {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// OpeningHoursSummary lists the weekly opening hours as shown on the site,
+// e.g. "Ma-Vr: 09:00 - 17:00", ending with the days the company is closed.
+func (p CompanyProfile) OpeningHoursSummary() []string {
+	var lines []string
+	open := make(map[time.Weekday]bool)
+	for _, h := range p.OpeningHours {
+		for _, d := range h.Days {
+			open[d] = true
+		}
+		lines = append(lines, dayRange(h.Days)+": "+h.Opens+" - "+h.Closes)
+	}
+	var closed []time.Weekday
+	for _, d := range []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday} {
+		if !open[d] {
+			closed = append(closed, d)
+		}
+	}
+	if len(closed) > 0 {
+		lines = append(lines, dayRange(closed)+": Gesloten")
+	}
+	return lines
+}
+
+// dayRange abbreviates days as "Ma-Vr" when consecutive, "Ma, Wo" otherwise.
+func dayRange(days []time.Weekday) string {
+	name := func(d time.Weekday) string {
+		return strings.ToUpper(dutchDays[d][:1]) + dutchDays[d][1:]
+	}
+	consecutive := len(days) > 2
+	for i := 1; i < len(days); i++ {
+		if days[i] != (days[i-1]+1)%7 {
+			consecutive = false
+		}
+	}
+	if consecutive {
+		return name(days[0]) + "-" + name(days[len(days)-1])
+	}
+	names := make([]string, len(days))
+	for i, d := range days {
+		names[i] = name(d)
+	}
+	return strings.Join(names, ", ")
+}
+
+// countryNames spells out the country codes we expect to see.
+var countryNames = map[string]string{"NL": "Nederland", "BE": "België", "DE": "Duitsland"}
+
+// AddressLine formats the address on one line, e.g. "Eerbeek, Nederland".
+func (p CompanyProfile) AddressLine() string {
+	var parts []string
+	if p.Address.Street != "" {
+		parts = append(parts, p.Address.Street)
+	}
+	parts = append(parts, strings.TrimSpace(p.Address.PostalCode+" "+p.Address.Locality))
+	if name, ok := countryNames[p.Address.Country]; ok {
+		parts = append(parts, name)
+	} else {
+		parts = append(parts, p.Address.Country)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefaultCompanyProfile(t *testing.T) {
+	p, err := parseCompanyProfile(defaultCompanyConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name == "" || len(p.OpeningHours) == 0 || p.OpeningHours[0].Days[0] != time.Monday {
+		t.Errorf("profile not read: %+v", p)
+	}
+}
+
+func TestParseCompanyProfileRejectsUnknownFields(t *testing.T) {
+	config := strings.Replace(string(defaultCompanyConfig), `"name"`, `"naam": "ICT Eerbeek", "name"`, 1)
+	if _, err := parseCompanyProfile([]byte(config)); err == nil {
+		t.Error("unknown field accepted")
+	}
+}
+
+func TestCompanyProfileValidate(t *testing.T) {
+	valid, err := parseCompanyProfile(defaultCompanyConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		change func(p *CompanyProfile)
+		want   string // part of the error, empty if valid
+	}{
+		{"valid", func(p *CompanyProfile) {}, ""},
+		{"all optional fields", func(p *CompanyProfile) {
+			p.Address.PostalCode = "6961 AA"
+			p.KvK = "12345678"
+			p.BTW = "NL123456789B01"
+			p.Closures = []Closure{{From: "2026-12-24", Until: "2026-12-31", Reason: "Kerstvakantie"}, {From: "2026-11-02", Reason: "Training"}}
+			p.SocialLinks = []SocialLink{{Network: "linkedin", URL: "https://www.linkedin.com/company/ict-eerbeek"}}
+		}, ""},
+		{"no name", func(p *CompanyProfile) { p.Name = "" }, "name"},
+		{"e-mail with display name", func(p *CompanyProfile) { p.Email = "ICT Eerbeek <info@ict-eerbeek.nl>" }, "email"},
+		{"no privacy e-mail", func(p *CompanyProfile) { p.PrivacyEmail = "" }, "privacy_email"},
+		{"national phone", func(p *CompanyProfile) { p.Phone = "0643138103" }, "E.164"},
+		{"other displayed phone", func(p *CompanyProfile) { p.PhoneDisplay = "+31 (0)6 43138104" }, "phone_display"},
+		{"country not a code", func(p *CompanyProfile) { p.Address.Country = "Nederland" }, "address.country"},
+		{"lower case country", func(p *CompanyProfile) { p.Address.Country = "nl" }, "address.country"},
+		{"postal code", func(p *CompanyProfile) { p.Address.PostalCode = "0961 AA" }, "postal_code"},
+		{"latitude", func(p *CompanyProfile) { p.Geo.Latitude = 91 }, "geo"},
+		{"kvk", func(p *CompanyProfile) { p.KvK = "1234567" }, "kvk"},
+		{"btw", func(p *CompanyProfile) { p.BTW = "NL123456789" }, "btw"},
+		{"closes before it opens", func(p *CompanyProfile) { p.OpeningHours[0].Closes = "08:00" }, "opening_hours[0]"},
+		{"no days", func(p *CompanyProfile) { p.OpeningHours[1].Days = nil }, "opening_hours[1] has no days"},
+		{"closure ends before it starts", func(p *CompanyProfile) {
+			p.Closures = []Closure{{From: "2026-12-31", Until: "2026-12-24", Reason: "Kerstvakantie"}}
+		}, "closures[0]"},
+		{"closure without reason", func(p *CompanyProfile) { p.Closures = []Closure{{From: "2026-12-24"}} }, "closures[0]"},
+		{"social link over http", func(p *CompanyProfile) {
+			p.SocialLinks = []SocialLink{{Network: "facebook", URL: "http://facebook.com/icteerbeek"}}
+		}, "social_links[0]"},
+		{"no logo", func(p *CompanyProfile) { p.Logo = "" }, "logo"},
+	}
+	for _, tt := range tests {
+		p := valid
+		p.OpeningHours = append([]OpeningHours(nil), valid.OpeningHours...)
+		tt.change(&p)
+		err := p.validate()
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s: err = %v, want one about %q", tt.name, err, tt.want)
+		}
+	}
+
+	// every problem is reported at once
+	p := valid
+	p.Name, p.Logo = "", ""
+	if err := p.validate(); err == nil || !strings.Contains(err.Error(), "name") || !strings.Contains(err.Error(), "logo") {
+		t.Errorf("two problems: err = %v", err)
+	}
+}
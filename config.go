@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/rand"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// siteConfig holds deployment settings read from the environment.
+type siteConfig struct {
+	// Env is the deployment environment: production, staging or development.
+	Env string
+	// BaseURL is the public origin of the site, without a trailing slash.
+	BaseURL string
+	// Secret signs the tokens in links we send out, such as appointment
+	// confirmations. Changing it invalidates all outstanding links.
+	Secret []byte
+	// TrustedProxies are the reverse proxies whose X-Forwarded-For header
+	// gives the client IP address. Without any, the address of the
+	// connection is used: the header is set by the client otherwise, and
+	// the login throttle and the logs would take its word for it.
+	TrustedProxies []string
+}
+
+var site siteConfig
+
+func initSiteConfig() {
+	site = siteConfig{
+		Env:     os.Getenv("APP_ENV"),
+		BaseURL: strings.TrimSuffix(os.Getenv("BASE_URL"), "/"),
+	}
+	if site.Env == "" {
+		site.Env = "production"
+	}
+	if site.BaseURL == "" {
+		site.BaseURL = "https://ict-eerbeek.nl"
+	}
+
+	switch site.Env {
+	case "production", "staging", "development":
+	default:
+		log.Fatalf("APP_ENV must be production, staging or development, got %q", site.Env)
+	}
+	if secret := os.Getenv("APP_SECRET"); secret != "" {
+		if len(secret) < 32 {
+			log.Fatal("APP_SECRET must be at least 32 characters")
+		}
+		site.Secret = []byte(secret)
+	} else if site.Env == "production" {
+		log.Fatal("APP_SECRET environment variable not set")
+	} else {
+		// Links signed with a throwaway secret stop working on restart,
+		// which is fine outside production.
+		site.Secret = make([]byte, 32)
+		rand.Read(site.Secret)
+		log.Print("APP_SECRET not set, using a random secret")
+	}
+
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			log.Fatalf("TRUSTED_PROXIES must list IP addresses or CIDR ranges, got %q", proxy)
+		}
+		site.TrustedProxies = append(site.TrustedProxies, proxy)
+	}
+
+	u, err := url.Parse(site.BaseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+		log.Fatalf("BASE_URL must be an absolute http(s) origin, got %q", site.BaseURL)
+	}
+}
+
+// Indexable reports whether search engines may index this deployment. Only
+// production is; staging and development copies must stay out of the index.
+func (s siteConfig) Indexable() bool {
+	return s.Env == "production"
+}
+
+// URL returns the absolute URL of path on this site.
+func (s siteConfig) URL(path string) string {
+	return s.BaseURL + path
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTrustedProxies(t *testing.T) {
+	saved := site
+	t.Cleanup(func() { site = saved })
+	t.Setenv("APP_ENV", "development")
+	tests := []struct {
+		proxies    string
+		remoteAddr string
+		want       string
+	}{
+		// without trusted proxies the header is the client's say-so
+		{"", "203.0.113.7:4321", "203.0.113.7"},
+		{"", "10.0.0.1:4321", "10.0.0.1"},
+		{"10.0.0.1", "10.0.0.1:4321", "198.51.100.9"},
+		{"10.0.0.0/8, 192.168.1.1", "10.1.2.3:4321", "198.51.100.9"},
+		{"10.0.0.1", "203.0.113.7:4321", "203.0.113.7"},
+	}
+	for _, tt := range tests {
+		t.Setenv("TRUSTED_PROXIES", tt.proxies)
+		initSiteConfig()
+		r := gin.New()
+		if err := r.SetTrustedProxies(site.TrustedProxies); err != nil {
+			t.Fatal(err)
+		}
+		r.GET("/", func(c *gin.Context) { c.String(200, c.ClientIP()) })
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		req.Header.Set("X-Forwarded-For", "198.51.100.9")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if got := w.Body.String(); got != tt.want {
+			t.Errorf("TRUSTED_PROXIES=%q, from %s: client IP %s, want %s", tt.proxies, tt.remoteAddr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// privacyPolicy is one published version of the privacy policy. Versions are
+// the files in templates/privacybeleid, named after the date they take
+// effect. A file may be added ahead of that date; it is published when it
+// takes effect and never edited after, so every consent record can be
+// traced back to the exact text that was agreed to. A change is a new file.
+type privacyPolicy struct {
+	Version   string // file name without extension, e.g. "2024-01-01"
+	Effective time.Time
+	Body      template.HTML
+}
+
+// Label returns the date the version took effect, e.g. "1 januari 2024".
+func (p privacyPolicy) Label() string {
+	return fmt.Sprintf("%d %s %d", p.Effective.Day(), dutchMonths[p.Effective.Month()-1], p.Effective.Year())
+}
+
+// Path is where the version stays readable after it is superseded.
+func (p privacyPolicy) Path() string {
+	return "/privacybeleid/versies/" + p.Version
+}
+
+// privacyPolicies holds every published version, oldest first.
+var privacyPolicies []privacyPolicy
+
+// loadPrivacyPolicies renders the versions in privacybeleid/ of fsys. They
+// are rendered once: besides the company profile they depend on nothing.
+func loadPrivacyPolicies(fsys fs.FS) ([]privacyPolicy, error) {
+	files, err := fs.Glob(fsys, "privacybeleid/*.html")
+	if err != nil {
+		return nil, err
+	}
+	var policies []privacyPolicy
+	for _, file := range files {
+		version := strings.TrimSuffix(path.Base(file), ".html")
+		effective, err := time.ParseInLocation(time.DateOnly, version, openingHoursLocation)
+		if err != nil {
+			return nil, fmt.Errorf("privacy policy %s: file name is not a date", file)
+		}
+		t, err := template.New(path.Base(file)).Funcs(template.FuncMap{
+			"company": func() CompanyProfile { return company },
+		}).ParseFS(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, nil); err != nil {
+			return nil, fmt.Errorf("privacy policy %s: %w", file, err)
+		}
+		policies = append(policies, privacyPolicy{Version: version, Effective: effective, Body: template.HTML(buf.String())})
+	}
+	if len(policies) == 0 {
+		return nil, errors.New("no privacy policy found in privacybeleid/")
+	}
+	if policies[0].Effective.After(time.Now()) {
+		return nil, fmt.Errorf("privacy policy %s: no version in force yet", policies[0].Version)
+	}
+	return policies, nil
+}
+
+// policiesInForce returns the versions that took effect by now, oldest
+// first. The last one is the current version.
+func policiesInForce(now time.Time) []privacyPolicy {
+	n := len(privacyPolicies)
+	for n > 1 && privacyPolicies[n-1].Effective.After(now) {
+		n--
+	}
+	return privacyPolicies[:n]
+}
+
+// currentPolicy returns the version in force: the latest one that took
+// effect.
+func currentPolicy() privacyPolicy {
+	inForce := policiesInForce(time.Now())
+	return inForce[len(inForce)-1]
+}
+
+// earlierPolicies returns the superseded versions, newest first.
+func earlierPolicies() []privacyPolicy {
+	inForce := policiesInForce(time.Now())
+	var earlier []privacyPolicy
+	for i := len(inForce) - 2; i >= 0; i-- {
+		earlier = append(earlier, inForce[i])
+	}
+	return earlier
+}
+
+// findPolicy returns the version named version, if it took effect.
+func findPolicy(version string) (privacyPolicy, bool) {
+	for _, p := range policiesInForce(time.Now()) {
+		if p.Version == version {
+			return p, true
+		}
+	}
+	return privacyPolicy{}, false
+}
+
+// privacyPolicyVersionHandler shows a version of the privacy policy, which
+// for anything but the current one is kept for reference only.
+func privacyPolicyVersionHandler(c *gin.Context) {
+	p, ok := findPolicy(c.Param("version"))
+	if !ok {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if p.Version == currentPolicy().Version {
+		c.Redirect(http.StatusMovedPermanently, "/privacybeleid")
+		return
+	}
+	c.HTML(http.StatusOK, "privacybeleid", privatePage(c.Request.URL.Path, "Privacybeleid van "+p.Label(), "privacybeleid", p))
+}
+
+// ConsentRecord proves that someone agreed to the privacy policy: which
+// version, for what and when. The IP address is only kept as a keyed hash,
+// enough to match it against a known address but not to reveal it.
+type ConsentRecord struct {
+	ID            uint   `gorm:"primaryKey"`
+	Purpose       string `gorm:"not null;index"`
+	PolicyVersion string `gorm:"not null"`
+	Email         string `gorm:"index"` // empty for the chat, which asks for no address
+	ContactID     *uint  `gorm:"index"`
+	IPHash        string
+	UserAgent     string
+	CreatedAt     time.Time
+}
+
+// What consent was given for.
+const (
+	consentContact     = "contact"
+	consentNewsletter  = "nieuwsbrief"
+	consentChat        = "chat"
+	consentAppointment = "afspraak"
+)
+
+var (
+	errNoConsent     = errors.New("ga akkoord met het privacybeleid om verder te gaan")
+	errPolicyChanged = errors.New("het privacybeleid is gewijzigd; laad de pagina opnieuw, lees de nieuwe versie en ga opnieuw akkoord")
+)
+
+// checkPolicyVersion returns errPolicyChanged unless version, the version a
+// form showed, is the current one. Consent is only recorded for the text
+// the visitor actually saw, so a form without a version, or one loaded
+// before the policy changed, is refused rather than taken to mean the
+// current version.
+func checkPolicyVersion(version string) error {
+	if version != currentPolicy().Version {
+		return errPolicyChanged
+	}
+	return nil
+}
+
+// consentIPHash identifies an IP address in consent records.
+func consentIPHash(ip string) string {
+	h := hmac.New(sha256.New, site.Secret)
+	h.Write([]byte("toestemming\x00" + ip))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// recordConsent stores that the visitor of c agreed to the privacy policy for
+// purpose. version is the version the form showed them, which must be the
+// current one; see checkPolicyVersion.
+func recordConsent(tx *gorm.DB, c *gin.Context, purpose, version, email string, contactID *uint) error {
+	if err := checkPolicyVersion(version); err != nil {
+		return err
+	}
+	return tx.Create(&ConsentRecord{
+		Purpose:       purpose,
+		PolicyVersion: version,
+		Email:         normalizeEmail(email),
+		ContactID:     contactID,
+		IPHash:        consentIPHash(c.ClientIP()),
+		UserAgent:     c.Request.UserAgent(),
+	}).Error
+}
+
+const (
+	chatConsentCookie = "chat_toestemming"
+	tokenChatConsent  = "chat-toestemming"
+)
+
+// chatConsent reports whether the visitor agreed to the current privacy
+// policy before using the chat, recording it when they do so now. Messages
+// go to an external AI service, so nothing is sent without consent. The
+// chat keeps no history, so a cookie remembers the consent instead of a
+// record per message. Agreeing to any but the current version returns
+// errPolicyChanged.
+func chatConsent(c *gin.Context, agreed bool, version string) (bool, error) {
+	current := currentPolicy().Version
+	if token, err := c.Cookie(chatConsentCookie); err == nil {
+		if v, err := verifyToken(tokenChatConsent, token); err == nil && v == current {
+			return true, nil
+		}
+	}
+	if !agreed {
+		return false, nil
+	}
+	if err := recordConsent(db, c, consentChat, version, "", nil); err != nil {
+		return false, err
+	}
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     chatConsentCookie,
+		Value:    signToken(tokenChatConsent, version, 0),
+		Path:     "/chat",
+		MaxAge:   int((365 * 24 * time.Hour).Seconds()),
+		Secure:   secureCookies(),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return true, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testPolicies replaces privacyPolicies with empty versions taking effect
+// on the given dates, oldest first.
+func testPolicies(t *testing.T, versions ...string) {
+	t.Helper()
+	var policies []privacyPolicy
+	for _, version := range versions {
+		effective, err := time.ParseInLocation(time.DateOnly, version, openingHoursLocation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		policies = append(policies, privacyPolicy{Version: version, Effective: effective})
+	}
+	saved := privacyPolicies
+	privacyPolicies = policies
+	t.Cleanup(func() { privacyPolicies = saved })
+}
+
+func TestLoadPrivacyPolicies(t *testing.T) {
+	policy := &fstest.MapFile{Data: []byte("<p>Beleid</p>")}
+	tests := []struct {
+		name  string
+		files []string
+		ok    bool
+	}{
+		{"one", []string{"2024-01-01"}, true},
+		{"announced ahead", []string{"2024-01-01", "2099-01-01"}, true},
+		{"none", nil, false},
+		{"none in force", []string{"2099-01-01"}, false},
+		{"not a date", []string{"2024-01-01", "nieuw"}, false},
+	}
+	for _, tt := range tests {
+		fsys := fstest.MapFS{}
+		for _, name := range tt.files {
+			fsys["privacybeleid/"+name+".html"] = policy
+		}
+		policies, err := loadPrivacyPolicies(fsys)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v, want ok %v", tt.name, err, tt.ok)
+		}
+		if err == nil && len(policies) != len(tt.files) {
+			t.Errorf("%s: %d versions, want %d", tt.name, len(policies), len(tt.files))
+		}
+	}
+}
+
+func TestPoliciesInForce(t *testing.T) {
+	testPolicies(t, "2024-01-01", "2026-10-18", "2026-10-19")
+	tests := []struct {
+		now     string
+		current string
+		n       int
+	}{
+		{"2025-06-01T12:00:00+02:00", "2024-01-01", 1},
+		{"2026-10-18T00:00:00+02:00", "2026-10-18", 2},
+		{"2026-10-18T23:59:59+02:00", "2026-10-18", 2},
+		{"2026-10-19T00:00:00+02:00", "2026-10-19", 3},
+	}
+	for _, tt := range tests {
+		now, err := time.Parse(time.RFC3339, tt.now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inForce := policiesInForce(now)
+		if len(inForce) != tt.n || inForce[len(inForce)-1].Version != tt.current {
+			t.Errorf("at %s: %d versions in force, current %s, want %d, %s", tt.now, len(inForce), inForce[len(inForce)-1].Version, tt.n, tt.current)
+		}
+	}
+}
+
+func TestRecordConsentVersion(t *testing.T) {
+	testDB(t, &ConsentRecord{})
+	testSecret(t)
+	testPolicies(t, "2024-01-01", "2026-01-01", "2099-01-01")
+	tests := []struct {
+		version string
+		err     error
+	}{
+		{"2026-01-01", nil},
+		{"", errPolicyChanged},
+		{"2024-01-01", errPolicyChanged}, // form loaded before the policy changed
+		{"2099-01-01", errPolicyChanged}, // not in force yet
+		{"onbekend", errPolicyChanged},
+	}
+	for _, tt := range tests {
+		c := testAdminContext("")
+		if err := recordConsent(db, c, consentContact, tt.version, "jan@example.nl", nil); !errors.Is(err, tt.err) {
+			t.Errorf("recordConsent(%q): err = %v, want %v", tt.version, err, tt.err)
+		}
+	}
+	var records []ConsentRecord
+	if err := db.Find(&records).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].PolicyVersion != "2026-01-01" {
+		t.Errorf("consent records: %+v", records)
+	}
+}
+
+func TestChatConsent(t *testing.T) {
+	testDB(t, &ConsentRecord{})
+	testSecret(t)
+	testPolicies(t, "2024-01-01", "2026-01-01")
+	chat := func(cookie string, agreed bool, version string) (bool, *httptest.ResponseRecorder, error) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/chat", nil)
+		if cookie != "" {
+			c.Request.Header.Set("Cookie", chatConsentCookie+"="+cookie)
+		}
+		ok, err := chatConsent(c, agreed, version)
+		return ok, w, err
+	}
+
+	if ok, _, err := chat("", false, ""); ok || err != nil {
+		t.Errorf("without consent: %v, %v", ok, err)
+	}
+	if ok, w, err := chat("", true, "2024-01-01"); ok || !errors.Is(err, errPolicyChanged) || len(w.Result().Cookies()) != 0 {
+		t.Errorf("agreed to an earlier version: %v, %v, cookies %v", ok, err, w.Result().Cookies())
+	}
+	ok, w, err := chat("", true, "2026-01-01")
+	if !ok || err != nil || len(w.Result().Cookies()) != 1 {
+		t.Fatalf("agreed to the current version: %v, %v, cookies %v", ok, err, w.Result().Cookies())
+	}
+	cookie := w.Result().Cookies()[0].Value
+	if v, err := verifyToken(tokenChatConsent, cookie); err != nil || v != "2026-01-01" {
+		t.Errorf("cookie for version %q, %v", v, err)
+	}
+	if ok, _, err := chat(cookie, false, ""); !ok || err != nil {
+		t.Errorf("with the cookie: %v, %v", ok, err)
+	}
+	var n int64
+	if err := db.Model(&ConsentRecord{}).Count(&n).Error; err != nil || n != 1 {
+		t.Errorf("%d consent records, %v, want 1", n, err)
+	}
+
+	// after the policy changes the cookie no longer counts
+	testPolicies(t, "2024-01-01", "2026-01-01", "2026-02-01")
+	if ok, _, err := chat(cookie, false, ""); ok || err != nil {
+		t.Errorf("with a cookie for an earlier version: %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Cookie categories. Necessary cookies, such as the login sessions and the
+// consent cookie itself, need no consent; the others are off until the
+// visitor turns them on.
+const (
+	cookiesNecessary = "noodzakelijk"
+	cookiesAnalytics = "analytisch"
+	cookiesMarketing = "marketing"
+)
+
+// cookieCategory is a category as offered in the banner and on /cookies.
+type cookieCategory struct {
+	Name        string
+	Label       string
+	Description string
+	Required    bool // always on, shown for information only
+}
+
+var cookieCategories = []cookieCategory{
+	{cookiesNecessary, "Noodzakelijk", "Nodig om de website te laten werken, zoals inloggen op het klantportaal en het onthouden van deze keuze.", true},
+	{cookiesAnalytics, "Analytisch", "Helpen ons te begrijpen hoe de website wordt gebruikt, zodat wij die kunnen verbeteren.", false},
+	{cookiesMarketing, "Marketing", "Voor inhoud van andere partijen, zoals kaarten van Google Maps. Die partijen kunnen u daarmee volgen.", false},
+}
+
+// CookieConsent is the choice a visitor made in the cookie banner. The zero
+// value is no choice at all, which allows only necessary cookies.
+type CookieConsent struct {
+	Given      bool     // a choice was made for the current privacy policy
+	Categories []string // the optional categories allowed
+}
+
+// Allows reports whether cookies of category may be used.
+func (cc CookieConsent) Allows(category string) bool {
+	return category == cookiesNecessary || slices.Contains(cc.Categories, category)
+}
+
+const (
+	cookieConsentCookie = "cookie_toestemming"
+	tokenCookieConsent  = "cookie-toestemming"
+
+	// cookieConsentMaxAge is how long a choice is remembered before the
+	// banner asks again.
+	cookieConsentMaxAge = 365 * 24 * time.Hour
+)
+
+// readCookieConsent returns the choice stored in the consent cookie. The
+// cookie is signed, so it cannot be made to claim a choice that was not
+// made here, and records the privacy policy it was made under: after the
+// policy changes the visitor is asked again.
+func readCookieConsent(c *gin.Context) CookieConsent {
+	value, err := c.Cookie(cookieConsentCookie)
+	if err != nil {
+		return CookieConsent{}
+	}
+	subject, err := verifyToken(tokenCookieConsent, value)
+	if err != nil {
+		return CookieConsent{}
+	}
+	version, categories, _ := strings.Cut(subject, "|")
+	if version != currentPolicy().Version {
+		return CookieConsent{}
+	}
+	cc := CookieConsent{Given: true}
+	for _, name := range strings.Split(categories, ",") {
+		if optionalCookieCategory(name) {
+			cc.Categories = append(cc.Categories, name)
+		}
+	}
+	return cc
+}
+
+func optionalCookieCategory(name string) bool {
+	return slices.ContainsFunc(cookieCategories, func(cat cookieCategory) bool { return cat.Name == name && !cat.Required })
+}
+
+func saveCookieConsent(c *gin.Context, cc CookieConsent) {
+	subject := currentPolicy().Version + "|" + strings.Join(cc.Categories, ",")
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     cookieConsentCookie,
+		Value:    signToken(tokenCookieConsent, subject, cookieConsentMaxAge),
+		Path:     "/",
+		MaxAge:   int(cookieConsentMaxAge.Seconds()),
+		Secure:   secureCookies(),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// consentWriter carries the cookie consent of a request to the page
+// renderer, which only gets to see the response writer.
+type consentWriter struct {
+	gin.ResponseWriter
+	consent CookieConsent
+}
+
+// cookieConsentMiddleware reads the consent cookie, so handlers can ask
+// cookieConsent and pages get it as PageData.Consent.
+func cookieConsentMiddleware(c *gin.Context) {
+	cc := readCookieConsent(c)
+	c.Set(cookieConsentCookie, cc)
+	c.Writer = consentWriter{c.Writer, cc}
+	c.Next()
+}
+
+// cookieConsent returns the choice of the visitor of c.
+func cookieConsent(c *gin.Context) CookieConsent {
+	cc, _ := c.MustGet(cookieConsentCookie).(CookieConsent)
+	return cc
+}
+
+// consentTo is the template helper guarding optional scripts and embeds:
+// {{if consentTo . "marketing"}}. A misspelt category fails the page
+// rather than silently hiding the content.
+func consentTo(p PageData, category string) (bool, error) {
+	if category != cookiesNecessary && !optionalCookieCategory(category) {
+		return false, fmt.Errorf("unknown cookie category %q", category)
+	}
+	return p.Consent.Allows(category), nil
+}
+
+func cookieGroup(r *gin.Engine) *gin.RouterGroup {
+	return r.Group("/cookies", adminHeaders, sameOriginPosts)
+}
+
+// cookieSettingsHandler handles GET /cookies, where a choice can be made or
+// changed at any time.
+func cookieSettingsHandler(c *gin.Context) {
+	c.HTML(http.StatusOK, "cookies", privatePage("/cookies", "Cookie-instellingen", "cookies", nil))
+}
+
+// cookieSettingsPostHandler handles POST /cookies from the banner and the
+// settings page. keuze is "alles", "noodzakelijk" or, from the settings
+// page, "selectie" with the allowed categories as categorie. The visitor is
+// sent back to the page they came from.
+func cookieSettingsPostHandler(c *gin.Context) {
+	var cc CookieConsent
+	switch c.PostForm("keuze") {
+	case "alles":
+		for _, cat := range cookieCategories {
+			if !cat.Required {
+				cc.Categories = append(cc.Categories, cat.Name)
+			}
+		}
+	case "selectie":
+		for _, name := range c.PostFormArray("categorie") {
+			if optionalCookieCategory(name) && !slices.Contains(cc.Categories, name) {
+				cc.Categories = append(cc.Categories, name)
+			}
+		}
+	case "noodzakelijk":
+	default:
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	saveCookieConsent(c, cc)
+	c.Redirect(http.StatusSeeOther, cookieReturnPath(c))
+}
+
+// cookieReturnPath returns the page the consent form was posted from, as a
+// path on this site.
+func cookieReturnPath(c *gin.Context) string {
+	for _, ref := range []string{c.GetHeader("Referer"), c.PostForm("terug")} {
+		u, err := url.Parse(ref)
+		if err != nil || (u.Host != "" && u.Host != c.Request.Host) || !strings.HasPrefix(u.Path, "/") ||
+			strings.HasPrefix(u.Path, "//") {
+			continue
+		}
+		return u.RequestURI()
+	}
+	return "/"
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"os"
+)
+
+// embedded holds the templates and static assets compiled into the binary, so
+// it runs regardless of the working directory it is started from.
+//
+//go:embed templates static
+var embedded embed.FS
+
+// defaultCompanyConfig is the company profile used when no -company file is
+// given.
+//
+//go:embed config/company.json
+var defaultCompanyConfig []byte
+
+// defaultBookingConfig holds the appointment types and technician
+// availability used when no -booking file is given.
+//
+//go:embed config/booking.json
+var defaultBookingConfig []byte
+
+// defaultRetentionConfig holds how long personal data is kept, as promised
+// in the privacy policy, when no -retention file is given.
+//
+//go:embed config/retention.json
+var defaultRetentionConfig []byte
+
+// siteFiles returns the file systems templates and static assets are read
+// from. In development mode they come straight from the working directory so
+// edits show up without rebuilding.
+func siteFiles(dev bool) (templates, static fs.FS) {
+	if dev {
+		return os.DirFS("templates"), os.DirFS("static")
+	}
+	templates, err := fs.Sub(embedded, "templates")
+	if err != nil {
+		panic(err)
+	}
+	static, err = fs.Sub(embedded, "static")
+	if err != nil {
+		panic(err)
+	}
+	return templates, static
+}
+
+// noListingFS serves files but refuses to list directories, matching the
+// behaviour of gin's Static.
+type noListingFS struct {
+	fs http.FileSystem
+}
+
+func (n noListingFS) Open(name string) (http.File, error) {
+	f, err := n.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if stat, err := f.Stat(); err == nil && stat.IsDir() {
+		f.Close()
+		return nil, os.ErrNotExist
+	}
+	return f, nil
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// Contact requests and the support tickets made from them are encrypted in
+// the database with AES-256-GCM: the name, company, e-mail address, phone
+// number and message of a contact request, and the name, company, address,
+// phone number, subject and messages of a ticket. The authenticator secrets
+// of admin users are too. Fields tagged serializer:encrypted are encrypted
+// on write and decrypted on read; queries cannot look inside them, so the
+// e-mail address is also stored as a blind index: a keyed hash to find
+// records by.
+//
+// Everything else is stored in plain text. Appointments, quotes, customer
+// accounts, subscribers, consent records, the mail log and the outbox hold
+// names and e-mail addresses that a copy of ict_eerbeek.db reveals.
+//
+// The keys come from DATA_KEYS, a comma-separated list of id:key pairs with
+// base64 encoded 32 byte keys. The first key encrypts, all of them decrypt.
+// To rotate, put a new key in front, restart, run the binary with
+// -reencrypt and drop the old key once it is done.
+
+// dataKey is one key of DATA_KEYS.
+type dataKey struct {
+	id    string
+	aead  cipher.AEAD
+	index []byte // key of the blind index, derived from the data key
+}
+
+// dataKeyring holds the keys, the current one first.
+type dataKeyring []dataKey
+
+var dataKeys dataKeyring
+
+// encryptedPrefix marks encrypted values; values without it were written
+// before encryption and are read as they are.
+const encryptedPrefix = "enc:"
+
+var (
+	errUnknownDataKey = errors.New("encrypted with a key not in DATA_KEYS")
+	errCorruptValue   = errors.New("cannot decrypt value")
+	dataKeyIDPattern  = regexp.MustCompile(`^[a-z0-9-]+$`)
+)
+
+func initDataKeys() {
+	spec := os.Getenv("DATA_KEYS")
+	if spec == "" {
+		if site.Env == "production" {
+			log.Fatal("DATA_KEYS environment variable not set")
+		}
+		// unlike a random key, this keeps a development database readable
+		// across restarts
+		sum := sha256.Sum256([]byte("ict-eerbeek development key"))
+		spec = "dev:" + base64.StdEncoding.EncodeToString(sum[:])
+		log.Print("DATA_KEYS not set, using an insecure development key")
+	}
+	keys, err := parseDataKeys(spec)
+	if err != nil {
+		log.Fatalf("DATA_KEYS: %v", err)
+	}
+	dataKeys = keys
+	schema.RegisterSerializer("encrypted", encryptedSerializer{})
+}
+
+func parseDataKeys(spec string) (dataKeyring, error) {
+	var keys dataKeyring
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || !dataKeyIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("%q is not an id:key pair with a lower-case id", entry)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("key id %q used twice", id)
+		}
+		seen[id] = true
+		secret, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(secret) != 32 {
+			return nil, fmt.Errorf("key %q must be 32 bytes, base64 encoded", id)
+		}
+		block, err := aes.NewCipher(secret)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte("blinde index"))
+		keys = append(keys, dataKey{id: id, aead: aead, index: mac.Sum(nil)})
+	}
+	return keys, nil
+}
+
+// encrypt seals plain with the current key. The column is authenticated
+// along, so a value cannot be copied into another column. Empty values are
+// left empty, so "is there an address" remains a plain query.
+func (k dataKeyring) encrypt(plain, column string) (string, error) {
+	if plain == "" {
+		return "", nil
+	}
+	key := k[0]
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := key.aead.Seal(nonce, nonce, []byte(plain), []byte(column))
+	return encryptedPrefix + key.id + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt opens a value made by encrypt, with whichever key made it.
+func (k dataKeyring) decrypt(value, column string) (string, error) {
+	rest, ok := strings.CutPrefix(value, encryptedPrefix)
+	if !ok {
+		return value, nil
+	}
+	id, encoded, _ := strings.Cut(rest, ":")
+	for _, key := range k {
+		if key.id != id {
+			continue
+		}
+		sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+		if err != nil || len(sealed) < key.aead.NonceSize() {
+			return "", errCorruptValue
+		}
+		nonce, ciphertext := sealed[:key.aead.NonceSize()], sealed[key.aead.NonceSize():]
+		plain, err := key.aead.Open(nil, nonce, ciphertext, []byte(column))
+		if err != nil {
+			return "", errCorruptValue
+		}
+		return string(plain), nil
+	}
+	return "", fmt.Errorf("%w: %q", errUnknownDataKey, id)
+}
+
+// emailIndex returns the blind index of an address under the current key.
+func (k dataKeyring) emailIndex(email string) string {
+	return k[0].blindIndex(email)
+}
+
+// emailIndexes returns the blind index of an address under every key, to
+// find records written before the last key rotation too.
+func (k dataKeyring) emailIndexes(email string) []string {
+	indexes := make([]string, len(k))
+	for i, key := range k {
+		indexes[i] = key.blindIndex(email)
+	}
+	return indexes
+}
+
+func (key dataKey) blindIndex(email string) string {
+	email = normalizeEmail(email)
+	if email == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, key.index)
+	mac.Write([]byte(email))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// encryptedSerializer is the GORM serializer behind serializer:encrypted,
+// for string fields.
+type encryptedSerializer struct{}
+
+func (encryptedSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue any) error {
+	var value string
+	switch v := dbValue.(type) {
+	case nil:
+	case string:
+		value = v
+	case []byte:
+		value = string(v)
+	default:
+		return fmt.Errorf("encrypted field %s: unexpected %T", field.Name, dbValue)
+	}
+	plain, err := dataKeys.decrypt(value, encryptedColumn(field))
+	if err != nil {
+		return fmt.Errorf("decrypt %s: %w", encryptedColumn(field), err)
+	}
+	return field.Set(ctx, dst, plain)
+}
+
+func (encryptedSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue any) (any, error) {
+	plain, ok := fieldValue.(string)
+	if !ok {
+		return nil, fmt.Errorf("encrypted field %s: unexpected %T", field.Name, fieldValue)
+	}
+	return dataKeys.encrypt(plain, encryptedColumn(field))
+}
+
+func encryptedColumn(field *schema.Field) string {
+	return field.Schema.Table + "." + field.DBName
+}
+
+// BeforeSave keeps the blind index in step with the address.
+func (c *Contact) BeforeSave(tx *gorm.DB) error {
+	c.EmailIndex = dataKeys.emailIndex(c.Email)
+	return nil
+}
+
+// BeforeSave keeps the blind index in step with the address.
+func (t *Ticket) BeforeSave(tx *gorm.DB) error {
+	t.EmailIndex = dataKeys.emailIndex(t.Email)
+	return nil
+}
+
+// byEmail narrows a query on contacts or tickets to those of an address.
+func byEmail(tx *gorm.DB, email string) *gorm.DB {
+	return tx.Where("email_index IN ?", dataKeys.emailIndexes(email))
+}
+
+// The columns written by the serializer, plus the blind index that goes
+// with them.
+var (
+	contactEncryptedColumns       = []string{"naam", "bedrijf", "email", "telefoon", "bericht", "email_index"}
+	ticketEncryptedColumns        = []string{"naam", "bedrijf", "email", "telefoon", "onderwerp", "email_index"}
+	ticketMessageEncryptedColumns = []string{"author", "body"}
+)
+
+// reencryptContacts writes every contact request again with the current
+// key, which also encrypts those stored before encryption existed.
+func reencryptContacts() (int, error) {
+	return rewriteRecords[Contact](contactEncryptedColumns, "")
+}
+
+// reencryptTickets writes every ticket and its messages again with the
+// current key.
+func reencryptTickets() (int, error) {
+	n, err := rewriteRecords[Ticket](ticketEncryptedColumns, "")
+	if err != nil {
+		return n, err
+	}
+	_, err = rewriteRecords[TicketMessage](ticketMessageEncryptedColumns, "")
+	return n, err
+}
+
+// reencryptAdminUsers writes the authenticator secrets again with the
+// current key.
+func reencryptAdminUsers() (int, error) {
+	var users []AdminUser
+	if err := db.Where("totp_secret <> ''").Find(&users).Error; err != nil {
+		return 0, err
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for i := range users {
+			if err := tx.Model(&users[i]).Select("totp_secret").Updates(&users[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return len(users), err
+}
+
+// migrateEncryption encrypts the contact requests and tickets stored
+// before they were encrypted, which are the ones without a blind index,
+// and the ticket messages stored in plain text.
+func migrateEncryption() error {
+	const unindexed = "(email_index IS NULL OR email_index = '') AND email <> ''"
+	contacts, err := rewriteRecords[Contact](contactEncryptedColumns, unindexed)
+	if err != nil {
+		return err
+	}
+	tickets, err := rewriteRecords[Ticket](ticketEncryptedColumns, unindexed)
+	if err != nil {
+		return err
+	}
+	messages, err := rewriteRecords[TicketMessage](ticketMessageEncryptedColumns, "body NOT LIKE '"+encryptedPrefix+"%'")
+	if contacts+tickets+messages > 0 {
+		log.Printf("encryption: %d contact requests, %d tickets and %d ticket messages encrypted", contacts, tickets, messages)
+	}
+	return err
+}
+
+// rewriteRecords saves the records of T matching the condition again, in
+// batches, writing the given columns.
+func rewriteRecords[T any](columns []string, condition string) (int, error) {
+	var lastID uint
+	var n int
+	for {
+		var ids []uint
+		query := db.Model(new(T)).Where("id > ?", lastID)
+		if condition != "" {
+			query = query.Where(condition)
+		}
+		if err := query.Order("id").Limit(100).Pluck("id", &ids).Error; err != nil {
+			return n, err
+		}
+		if len(ids) == 0 {
+			return n, nil
+		}
+		var batch []T
+		if err := db.Where("id IN ?", ids).Find(&batch).Error; err != nil {
+			return n, err
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			for i := range batch {
+				if err := tx.Model(&batch[i]).Select(columns).Updates(&batch[i]).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return n, err
+		}
+		lastID = ids[len(ids)-1]
+		n += len(batch)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testKey returns an id:key pair for DATA_KEYS with every byte of the key
+// set to b.
+func testKey(id string, b byte) string {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = b
+	}
+	return id + ":" + base64.StdEncoding.EncodeToString(key)
+}
+
+// alterMiddle returns s with another character halfway.
+func alterMiddle(s string) string {
+	i := len(s) / 2
+	c := "A"
+	if s[i] == 'A' {
+		c = "B"
+	}
+	return s[:i] + c + s[i+1:]
+}
+
+func TestParseDataKeys(t *testing.T) {
+	tests := []struct {
+		spec string
+		ok   bool
+	}{
+		{testKey("a", 1), true},
+		{testKey("nieuw", 1) + ", " + testKey("oud-1", 2), true},
+		{"", false},
+		{"a", false},
+		{testKey("A", 1), false},
+		{testKey("a", 1) + "," + testKey("a", 2), false},
+		{"a:" + base64.StdEncoding.EncodeToString(make([]byte, 16)), false},
+		{"a:geen base64", false},
+	}
+	for _, tt := range tests {
+		if _, err := parseDataKeys(tt.spec); (err == nil) != tt.ok {
+			t.Errorf("parseDataKeys(%q): err = %v, want ok %v", tt.spec, err, tt.ok)
+		}
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	keys, err := parseDataKeys(testKey("a", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := keys.encrypt("Jan Jansen", "contacts.naam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(sealed, encryptedPrefix+"a:") || strings.Contains(sealed, "Jan") {
+		t.Errorf("encrypt = %q", sealed)
+	}
+	if again, _ := keys.encrypt("Jan Jansen", "contacts.naam"); again == sealed {
+		t.Error("encrypting twice gives the same value")
+	}
+
+	tests := []struct {
+		name   string
+		value  string
+		column string
+		want   string
+		err    error
+	}{
+		{"round trip", sealed, "contacts.naam", "Jan Jansen", nil},
+		{"other column", sealed, "contacts.bericht", "", errCorruptValue},
+		{"altered", alterMiddle(sealed), "contacts.naam", "", errCorruptValue},
+		{"truncated", encryptedPrefix + "a:AAAA", "contacts.naam", "", errCorruptValue},
+		{"unknown key", encryptedPrefix + "b" + sealed[len(encryptedPrefix)+1:], "contacts.naam", "", errUnknownDataKey},
+		{"stored before encryption", "Jan Jansen", "contacts.naam", "Jan Jansen", nil},
+		{"empty", "", "contacts.naam", "", nil},
+	}
+	for _, tt := range tests {
+		got, err := keys.decrypt(tt.value, tt.column)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("%s: decrypt = %q, %v, want %q, %v", tt.name, got, err, tt.want, tt.err)
+		}
+	}
+	if empty, _ := keys.encrypt("", "contacts.naam"); empty != "" {
+		t.Errorf("encrypt of empty value = %q", empty)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	old, err := parseDataKeys(testKey("oud", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := parseDataKeys(testKey("nieuw", 2) + "," + testKey("oud", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := old.encrypt("jan@example.nl", "contacts.email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain, err := rotated.decrypt(sealed, "contacts.email"); err != nil || plain != "jan@example.nl" {
+		t.Errorf("old value after rotation: %q, %v", plain, err)
+	}
+	resealed, err := rotated.encrypt("jan@example.nl", "contacts.email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(resealed, encryptedPrefix+"nieuw:") {
+		t.Errorf("value after rotation not under the new key: %q", resealed)
+	}
+	if _, err := old.decrypt(resealed, "contacts.email"); !errors.Is(err, errUnknownDataKey) {
+		t.Errorf("new value with the old key only: err = %v, want %v", err, errUnknownDataKey)
+	}
+
+	if !slices.Contains(rotated.emailIndexes("jan@example.nl"), old.emailIndex("jan@example.nl")) {
+		t.Error("emailIndexes after rotation misses the index under the old key")
+	}
+	if rotated.emailIndex("jan@example.nl") == old.emailIndex("jan@example.nl") {
+		t.Error("blind index is the same under another key")
+	}
+}
+
+func TestEmailIndex(t *testing.T) {
+	keys, err := parseDataKeys(testKey("a", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := keys.emailIndex("jan@example.nl")
+	if index == "" || strings.Contains(index, "jan") {
+		t.Fatalf("emailIndex = %q", index)
+	}
+	tests := []struct {
+		email string
+		same  bool
+	}{
+		{"jan@example.nl", true},
+		{" Jan@Example.NL ", true},
+		{"piet@example.nl", false},
+		{"jan@example.com", false},
+	}
+	for _, tt := range tests {
+		if got := keys.emailIndex(tt.email); (got == index) != tt.same {
+			t.Errorf("emailIndex(%q) = %q, same as for jan@example.nl: %v, want %v", tt.email, got, got == index, tt.same)
+		}
+	}
+	if got := keys.emailIndex(""); got != "" {
+		t.Errorf("emailIndex of no address = %q", got)
+	}
+}
+
+func TestReencryptContacts(t *testing.T) {
+	testDB(t, &Contact{}, &Attachment{})
+	testDataKeys(t, testKey("oud", 1))
+	c := Contact{Naam: "Jan Jansen", Email: "jan@example.nl", Onderwerp: "Laptop", Bericht: "Start niet op"}
+	if err := db.Create(&c).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	testDataKeys(t, testKey("nieuw", 2)+","+testKey("oud", 1))
+	var found []Contact
+	if err := byEmail(db, "Jan@example.nl").Find(&found).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].Naam != "Jan Jansen" {
+		t.Fatalf("contact under the old key not found after rotation: %+v", found)
+	}
+	if n, err := reencryptContacts(); err != nil || n != 1 {
+		t.Fatalf("reencryptContacts = %d, %v", n, err)
+	}
+
+	// the old key can go now
+	testDataKeys(t, testKey("nieuw", 2))
+	var raw struct{ Naam, Email, EmailIndex string }
+	if err := db.Table("contacts").First(&raw).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(raw.Naam, encryptedPrefix+"nieuw:") || !strings.HasPrefix(raw.Email, encryptedPrefix+"nieuw:") {
+		t.Errorf("columns not under the new key: %+v", raw)
+	}
+	found = nil
+	if err := byEmail(db, "jan@example.nl").Find(&found).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].Bericht != "Start niet op" {
+		t.Errorf("contact not readable with the new key only: %+v", found)
+	}
+}
+
+func TestTicketsEncrypted(t *testing.T) {
+	testDB(t, &Contact{}, &Attachment{}, &Ticket{}, &TicketMessage{})
+	ticket := Ticket{Number: "TK-2026-0001", Naam: "Jan Jansen", Email: "Jan@example.nl", Telefoon: "0612345678",
+		Onderwerp: "Laptop start niet op", Status: ticketNew, Priority: "normaal",
+		Messages: []TicketMessage{{Author: "Jan Jansen", FromCustomer: true, Body: "Laptop start niet op"}}}
+	if err := db.Create(&ticket).Error; err != nil {
+		t.Fatal(err)
+	}
+	// stored before tickets were encrypted
+	err := db.Exec(`INSERT INTO tickets (number, naam, email, onderwerp, status, priority) VALUES ('TK-2026-0002', 'Piet', 'piet@example.nl', 'Printer', 'nieuw', 'laag')`).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec(`INSERT INTO ticket_messages (ticket_id, author, from_customer, internal, body) VALUES (2, 'Piet', true, false, 'Printer doet het niet')`).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateEncryption(); err != nil {
+		t.Fatal(err)
+	}
+
+	var tickets []struct{ Naam, Email, EmailIndex, Telefoon, Onderwerp string }
+	if err := db.Table("tickets").Order("id").Find(&tickets).Error; err != nil {
+		t.Fatal(err)
+	}
+	var messages []struct{ Author, Body string }
+	if err := db.Table("ticket_messages").Order("id").Find(&messages).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, raw := range []string{tickets[0].Naam, tickets[0].Email, tickets[0].Telefoon, tickets[0].Onderwerp,
+		tickets[1].Naam, tickets[1].Email, messages[0].Author, messages[0].Body, messages[1].Body} {
+		if !strings.HasPrefix(raw, encryptedPrefix) {
+			t.Errorf("stored in plain text: %q", raw)
+		}
+	}
+
+	for _, email := range []string{"jan@example.nl", "piet@example.nl"} {
+		var found []Ticket
+		if err := byEmail(db.Preload("Messages"), email).Find(&found).Error; err != nil {
+			t.Fatal(err)
+		}
+		if len(found) != 1 || found[0].Email == "" || len(found[0].Messages) != 1 || found[0].Messages[0].Body == "" {
+			t.Errorf("tickets of %s: %+v", email, found)
+		}
+	}
+
+	if _, err := retentionTables["tickets"].anonymize(db, []uint{ticket.ID}); err != nil {
+		t.Fatal(err)
+	}
+	var anonymized Ticket
+	if err := db.Preload("Messages").First(&anonymized, ticket.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if anonymized.Email != "" || anonymized.EmailIndex != "" || anonymized.Naam != "Verwijderd" ||
+		anonymized.Messages[0].Author != "Verwijderd" || anonymized.Messages[0].Body != "" {
+		t.Errorf("anonymized ticket: %+v", anonymized)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"strings"
+)
+
+// webFont is a self-hosted font file in static/fonts.
+type webFont struct {
+	Family string
+	File   string // path relative to static/
+	Weight string
+	Style  string
+}
+
+// webFonts are served from our own domain instead of Google Fonts, so page
+// views do not leak visitor IP addresses to a third party. Inter is
+// distributed under the SIL Open Font License; the variable font file comes
+// from the official release at https://github.com/rsms/inter, and the
+// licence must be shipped with it in static/fonts/OFL.txt.
+var webFonts = []webFont{
+	{Family: "Inter", File: "fonts/InterVariable.woff2", Weight: "100 900", Style: "normal"},
+}
+
+const fontLicense = "fonts/OFL.txt"
+
+// fontFaces returns the preload links and @font-face rules for the vendored
+// fonts that are present. A missing font file is logged and skipped, so the
+// system font stack in style.css takes over; a font file without its
+// licence is an error, as the font may not be distributed without it.
+func fontFaces(assets *assetManifest) (template.HTML, error) {
+	var b strings.Builder
+	var faces strings.Builder
+	for _, f := range webFonts {
+		if !assets.Has(f.File) {
+			log.Printf("fonts: static/%s not found, falling back to system fonts", f.File)
+			continue
+		}
+		if !assets.Has(fontLicense) {
+			return "", fmt.Errorf("fonts: static/%s missing; ship the font licence with the font files", fontLicense)
+		}
+		url := assets.URL(f.File)
+		fmt.Fprintf(&b, `<link rel="preload" href="%s" as="font" type="font/woff2" crossorigin>`+"\n", url)
+		fmt.Fprintf(&faces, "@font-face{font-family:%q;src:url(%q) format(\"woff2\");font-weight:%s;font-style:%s;font-display:swap}\n",
+			f.Family, url, f.Weight, f.Style)
+	}
+	if faces.Len() > 0 {
+		b.WriteString("<style>\n" + faces.String() + "</style>")
+	}
+	return template.HTML(b.String()), nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFontFaces(t *testing.T) {
+	font := &fstest.MapFile{Data: []byte("wOF2")}
+	license := &fstest.MapFile{Data: []byte("SIL Open Font License")}
+	tests := []struct {
+		name    string
+		files   fstest.MapFS
+		preload bool
+		ok      bool
+	}{
+		{"font and licence", fstest.MapFS{"fonts/InterVariable.woff2": font, "fonts/OFL.txt": license}, true, true},
+		{"no font", fstest.MapFS{"fonts/OFL.txt": license}, false, true},
+		{"nothing", fstest.MapFS{"css/style.css": {Data: []byte("body{}")}}, false, true},
+		{"font without licence", fstest.MapFS{"fonts/InterVariable.woff2": font}, false, false},
+	}
+	for _, tt := range tests {
+		assets, err := buildAssets(tt.files, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		html, err := fontFaces(assets)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v, want ok %v", tt.name, err, tt.ok)
+		}
+		if got := strings.Contains(string(html), `rel="preload"`); got != tt.preload {
+			t.Errorf("%s: preload %v, want %v: %s", tt.name, got, tt.preload, html)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+	_ "time/tzdata" // the server may run without a zoneinfo database
+
+	"github.com/gin-gonic/gin"
+)
+
+// openingHoursLocation is the time zone the opening hours are kept in,
+// whatever the time zone of the server.
+var openingHoursLocation = mustLoadLocation("Europe/Amsterdam")
+
+func mustLoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return loc
+}
+
+// Holiday is a public holiday on which we are closed.
+type Holiday struct {
+	Date time.Time
+	Name string
+}
+
+// dutchHolidays returns the Dutch public holidays of year.
+func dutchHolidays(year int) []Holiday {
+	easter := easterSunday(year)
+	kingsDay := dateOf(year, time.April, 27)
+	if kingsDay.Weekday() == time.Sunday {
+		kingsDay = kingsDay.AddDate(0, 0, -1)
+	}
+	holidays := []Holiday{
+		{dateOf(year, time.January, 1), "Nieuwjaarsdag"},
+		{easter, "Eerste Paasdag"},
+		{easter.AddDate(0, 0, 1), "Tweede Paasdag"},
+		{kingsDay, "Koningsdag"},
+		{easter.AddDate(0, 0, 39), "Hemelvaartsdag"},
+		{easter.AddDate(0, 0, 49), "Eerste Pinksterdag"},
+		{easter.AddDate(0, 0, 50), "Tweede Pinksterdag"},
+		{dateOf(year, time.December, 25), "Eerste Kerstdag"},
+		{dateOf(year, time.December, 26), "Tweede Kerstdag"},
+	}
+	// Bevrijdingsdag is only a day off in lustrum years.
+	if year%5 == 0 {
+		holidays = append(holidays, Holiday{dateOf(year, time.May, 5), "Bevrijdingsdag"})
+	}
+	sort.Slice(holidays, func(i, j int) bool { return holidays[i].Date.Before(holidays[j].Date) })
+	return holidays
+}
+
+// easterSunday computes the date of Easter in the Gregorian calendar with the
+// anonymous Gregorian algorithm (Meeus/Jones/Butcher).
+func easterSunday(year int) time.Time {
+	a := year % 19
+	b, c := year/100, year%100
+	d, e := b/4, b%4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i, k := c/4, c%4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	day := (h+l-7*m+114)%31 + 1
+	return dateOf(year, time.Month(month), day)
+}
+
+func dateOf(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, openingHoursLocation)
+}
+
+// startOfDay returns midnight of the day t falls on, in the opening hours'
+// time zone.
+func startOfDay(t time.Time) time.Time {
+	t = t.In(openingHoursLocation)
+	return dateOf(t.Year(), t.Month(), t.Day())
+}
+
+// closedReason reports why we are closed on day despite the weekly schedule:
+// a public holiday or an ad-hoc closure.
+func (p CompanyProfile) closedReason(day time.Time) (string, bool) {
+	day = startOfDay(day)
+	for _, h := range dutchHolidays(day.Year()) {
+		if h.Date.Equal(day) {
+			return h.Name, true
+		}
+	}
+	date := day.Format(time.DateOnly)
+	for _, c := range p.Closures {
+		until := c.Until
+		if until == "" {
+			until = c.From
+		}
+		if c.From <= date && date <= until {
+			return c.Reason, true
+		}
+	}
+	return "", false
+}
+
+// openPeriod is a single stretch of time we are open.
+type openPeriod struct {
+	opens, closes time.Time
+}
+
+// periods returns the periods we are open on day, in order.
+func (p CompanyProfile) periods(day time.Time) []openPeriod {
+	day = startOfDay(day)
+	if _, closed := p.closedReason(day); closed {
+		return nil
+	}
+	at := func(clock string) time.Time {
+		t, _ := time.Parse("15:04", clock) // validated on startup
+		return time.Date(day.Year(), day.Month(), day.Day(), t.Hour(), t.Minute(), 0, 0, openingHoursLocation)
+	}
+	var periods []openPeriod
+	for _, h := range p.OpeningHours {
+		for _, d := range h.Days {
+			if d == day.Weekday() {
+				periods = append(periods, openPeriod{at(h.Opens), at(h.Closes)})
+			}
+		}
+	}
+	sort.Slice(periods, func(i, j int) bool { return periods[i].opens.Before(periods[j].opens) })
+	return periods
+}
+
+// searchDays bounds how far ahead we look for the next opening, so a
+// schedule without any opening hours cannot loop forever.
+const searchDays = 400
+
+// OpeningStatus answers whether we are open at a moment, and if not, when we
+// open next.
+type OpeningStatus struct {
+	Open        bool       `json:"open"`
+	Now         time.Time  `json:"now"`
+	ClosesAt    *time.Time `json:"closes_at,omitempty"`
+	NextOpening *time.Time `json:"next_opening,omitempty"`
+	Reason      string     `json:"reason,omitempty"` // holiday or closure today
+	Summary     string     `json:"summary"`
+}
+
+// StatusAt returns the opening status at t.
+func (p CompanyProfile) StatusAt(t time.Time) OpeningStatus {
+	t = t.In(openingHoursLocation)
+	s := OpeningStatus{Now: t}
+	s.Reason, _ = p.closedReason(t)
+	for _, period := range p.periods(t) {
+		if !t.Before(period.opens) && t.Before(period.closes) {
+			closes := period.closes
+			s.Open, s.ClosesAt = true, &closes
+		}
+	}
+	if !s.Open {
+		if next, ok := p.nextOpening(t); ok {
+			s.NextOpening = &next
+		}
+	}
+	s.Summary = s.summary()
+	return s
+}
+
+// nextOpening returns the first moment after t we open.
+func (p CompanyProfile) nextOpening(t time.Time) (time.Time, bool) {
+	day := startOfDay(t)
+	for i := 0; i < searchDays; i++ {
+		for _, period := range p.periods(day.AddDate(0, 0, i)) {
+			if period.opens.After(t) {
+				return period.opens, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// summary describes the status in a sentence, e.g. "Nu geopend tot 17:00"
+// or "Gesloten (Koningsdag), morgen weer open vanaf 09:00".
+func (s OpeningStatus) summary() string {
+	if s.Open {
+		return "Nu geopend tot " + s.ClosesAt.Format("15:04")
+	}
+	closed := "Gesloten"
+	if s.Reason != "" {
+		closed += " (" + s.Reason + ")"
+	}
+	if s.NextOpening == nil {
+		return closed
+	}
+	next := *s.NextOpening
+	switch days := int(startOfDay(next).Sub(startOfDay(s.Now)).Hours()+12) / 24; days {
+	case 0:
+		return closed + ", vandaag open vanaf " + next.Format("15:04")
+	case 1:
+		return closed + ", morgen weer open vanaf " + next.Format("15:04")
+	default:
+		return closed + ", weer open op " + dutchDate(next) + " om " + next.Format("15:04")
+	}
+}
+
+// ClosedDays is a stretch of days on which we are unexpectedly closed.
+type ClosedDays struct {
+	From   time.Time `json:"from"`
+	Until  time.Time `json:"until"`
+	Reason string    `json:"reason"`
+}
+
+// Label describes the closure, e.g. "Koningsdag: maandag 27 april".
+func (c ClosedDays) Label() string {
+	if c.Until.Equal(c.From) {
+		return c.Reason + ": " + dutchDate(c.From)
+	}
+	return c.Reason + ": " + dutchDate(c.From) + " t/m " + dutchDate(c.Until)
+}
+
+// UpcomingClosures lists the holidays and closures in the days after from
+// that fall on days we would otherwise be open. Closures for the same reason
+// with no opening day in between are merged.
+func (p CompanyProfile) UpcomingClosures(from time.Time, days int) []ClosedDays {
+	var closures []ClosedDays
+	extend := false // whether the last closure is still running
+	day := startOfDay(from)
+	for i := 0; i < days; i++ {
+		d := day.AddDate(0, 0, i)
+		if !p.opensOnWeekday(d.Weekday()) {
+			continue
+		}
+		reason, closed := p.closedReason(d)
+		switch {
+		case !closed:
+			extend = false
+		case extend && closures[len(closures)-1].Reason == reason:
+			closures[len(closures)-1].Until = d
+		default:
+			closures = append(closures, ClosedDays{From: d, Until: d, Reason: reason})
+			extend = true
+		}
+	}
+	return closures
+}
+
+func (p CompanyProfile) opensOnWeekday(day time.Weekday) bool {
+	for _, h := range p.OpeningHours {
+		for _, d := range h.Days {
+			if d == day {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// BusinessDeadline returns the moment d of opening hours have passed since
+// start, skipping evenings, weekends, holidays and closures. Response times
+// (SLAs) are measured this way.
+func (p CompanyProfile) BusinessDeadline(start time.Time, d time.Duration) time.Time {
+	start = start.In(openingHoursLocation)
+	day := startOfDay(start)
+	for i := 0; i < searchDays; i++ {
+		for _, period := range p.periods(day.AddDate(0, 0, i)) {
+			if !period.closes.After(start) {
+				continue
+			}
+			from := period.opens
+			if start.After(from) {
+				from = start
+			}
+			if left := period.closes.Sub(from); d <= left {
+				return from.Add(d)
+			} else {
+				d -= left
+			}
+		}
+	}
+	return start.Add(d)
+}
+
+// responseTargets is the time, in opening hours, we allow ourselves to
+// respond for each urgency of the contact form.
+var responseTargets = map[string]time.Duration{
+	"laag":    5 * 8 * time.Hour,
+	"normaal": 3 * 8 * time.Hour,
+	"hoog":    8 * time.Hour,
+	"urgent":  2 * time.Hour,
+}
+
+// responseDeadline returns when a request of the given urgency, received at
+// t, should have been answered.
+func responseDeadline(urgency string, t time.Time) time.Time {
+	target, ok := responseTargets[urgency]
+	if !ok {
+		target = responseTargets["normaal"]
+	}
+	return company.BusinessDeadline(t, target)
+}
+
+var (
+	dutchWeekdays = [...]string{"zondag", "maandag", "dinsdag", "woensdag", "donderdag", "vrijdag", "zaterdag"}
+	dutchMonths   = [...]string{"januari", "februari", "maart", "april", "mei", "juni", "juli", "augustus", "september", "oktober", "november", "december"}
+)
+
+// dutchDate formats t as e.g. "maandag 27 april".
+func dutchDate(t time.Time) string {
+	t = t.In(openingHoursLocation)
+	return fmt.Sprintf("%s %d %s", dutchWeekdays[t.Weekday()], t.Day(), dutchMonths[t.Month()-1])
+}
+
+// openingHoursHandler handles GET /api/opening-hours: whether we are open
+// now, when we open next, the weekly schedule and upcoming closures.
+func openingHoursHandler(c *gin.Context) {
+	now := time.Now()
+	c.JSON(http.StatusOK, gin.H{
+		"status":   company.StatusAt(now),
+		"weekly":   company.OpeningHoursSummary(),
+		"closures": company.UpcomingClosures(now, 60),
+		"timezone": openingHoursLocation.String(),
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEasterSunday(t *testing.T) {
+	tests := []struct {
+		year  int
+		month time.Month
+		day   int
+	}{
+		{1818, time.March, 22}, // earliest possible
+		{1943, time.April, 25}, // latest possible
+		{2000, time.April, 23},
+		{2019, time.April, 21},
+		{2024, time.March, 31},
+		{2025, time.April, 20},
+		{2026, time.April, 5},
+		{2038, time.April, 25},
+	}
+	for _, tt := range tests {
+		got := easterSunday(tt.year)
+		if want := dateOf(tt.year, tt.month, tt.day); !got.Equal(want) {
+			t.Errorf("easterSunday(%d) = %s, want %s", tt.year, got.Format(time.DateOnly), want.Format(time.DateOnly))
+		}
+		if got.Weekday() != time.Sunday {
+			t.Errorf("easterSunday(%d) is a %s", tt.year, got.Weekday())
+		}
+	}
+}
+
+func TestDutchHolidays(t *testing.T) {
+	tests := []struct {
+		date string
+		name string // empty if the day is no holiday
+	}{
+		{"2026-04-27", "Koningsdag"},
+		{"2025-04-26", "Koningsdag"}, // the 27th is a Sunday
+		{"2025-04-27", ""},
+		{"2014-04-26", "Koningsdag"},
+		{"2026-04-06", "Tweede Paasdag"},
+		{"2026-05-14", "Hemelvaartsdag"},
+		{"2026-05-25", "Tweede Pinksterdag"},
+		{"2025-05-05", "Bevrijdingsdag"},
+		{"2026-05-05", ""}, // only in lustrum years
+		{"2026-12-26", "Tweede Kerstdag"},
+	}
+	for _, tt := range tests {
+		day, err := time.ParseInLocation(time.DateOnly, tt.date, openingHoursLocation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got string
+		for _, h := range dutchHolidays(day.Year()) {
+			if h.Date.Equal(day) {
+				got = h.Name
+			}
+		}
+		if got != tt.name {
+			t.Errorf("%s: holiday %q, want %q", tt.date, got, tt.name)
+		}
+	}
+}
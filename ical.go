@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// calendarEvent is a single VEVENT of an iCalendar (RFC 5545) file.
+type calendarEvent struct {
+	UID         string
+	Sequence    int
+	Start, End  time.Time
+	Summary     string
+	Description string
+	Location    string
+	URL         string
+	Cancelled   bool
+	Updated     time.Time
+}
+
+// calendarMethod says what a calendar file is for: PUBLISH for feeds and
+// plain attachments, CANCEL to remove an event from the recipient's calendar.
+const (
+	calendarPublish = "PUBLISH"
+	calendarCancel  = "CANCEL"
+)
+
+// icalTime is the UTC form of times in iCalendar files.
+const icalTime = "20060102T150405Z"
+
+// buildCalendar renders events as an iCalendar file named name.
+func buildCalendar(name, method string, events []calendarEvent) []byte {
+	var b bytes.Buffer
+	line := func(s string) {
+		writeFolded(&b, s)
+	}
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//" + icalEscape(company.Name) + "//Afspraken//NL")
+	line("CALSCALE:GREGORIAN")
+	line("METHOD:" + method)
+	line("X-WR-CALNAME:" + icalEscape(name))
+	line("X-WR-TIMEZONE:" + openingHoursLocation.String())
+	for _, e := range events {
+		line("BEGIN:VEVENT")
+		line("UID:" + e.UID)
+		line("SEQUENCE:" + strconv.Itoa(e.Sequence))
+		line("DTSTAMP:" + e.Updated.UTC().Format(icalTime))
+		line("DTSTART:" + e.Start.UTC().Format(icalTime))
+		line("DTEND:" + e.End.UTC().Format(icalTime))
+		line("SUMMARY:" + icalEscape(e.Summary))
+		if e.Description != "" {
+			line("DESCRIPTION:" + icalEscape(e.Description))
+		}
+		if e.Location != "" {
+			line("LOCATION:" + icalEscape(e.Location))
+		}
+		if e.URL != "" {
+			line("URL:" + e.URL)
+		}
+		line("ORGANIZER;CN=" + icalParam(company.Name) + ":mailto:" + company.Email)
+		if e.Cancelled {
+			line("STATUS:CANCELLED")
+		} else {
+			line("STATUS:CONFIRMED")
+		}
+		line("END:VEVENT")
+	}
+	line("END:VCALENDAR")
+	return b.Bytes()
+}
+
+// icalEscape escapes a TEXT value.
+func icalEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// icalParam quotes a parameter value.
+func icalParam(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "'") + `"`
+}
+
+// writeFolded writes a content line, folding it at 75 octets without
+// splitting UTF-8 sequences.
+func writeFolded(b *bytes.Buffer, s string) {
+	limit := 75
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		b.WriteString(s[:cut] + "\r\n ")
+		s = s[cut:]
+		limit = 74 // the leading space of a continuation line counts
+	}
+	b.WriteString(s + "\r\n")
+}
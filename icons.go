@@ -0,0 +1,75 @@
+package main
+
+import (
+	"html/template"
+	"io/fs"
+	"log"
+	"path"
+	"strings"
+	"sync"
+)
+
+// iconSet renders the SVG icons in static/icons inline, so pages need no icon
+// font from a third-party CDN. Icons are addressed by the Font Awesome name
+// they replace, e.g. {{icon "shield-alt"}}.
+type iconSet struct {
+	fsys fs.FS
+	dev  bool
+
+	mu    sync.Mutex
+	icons map[string]template.HTML
+}
+
+// iconAttrs are added to every inline icon; the icon takes the size and colour
+// of the surrounding text.
+const iconAttrs = `class="icon" aria-hidden="true" focusable="false" width="1em" height="1em"`
+
+// loadIcons reads every icon up front so a malformed file fails at startup.
+// In development mode icons are read from disk on every use instead.
+func loadIcons(fsys fs.FS, dev bool) (*iconSet, error) {
+	s := &iconSet{fsys: fsys, dev: dev, icons: make(map[string]template.HTML)}
+	if dev {
+		return s, nil
+	}
+	files, err := fs.Glob(fsys, "icons/*.svg")
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(path.Base(file), ".svg")
+		if s.icons[name], err = readIcon(fsys, name); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func readIcon(fsys fs.FS, name string) (template.HTML, error) {
+	b, err := fs.ReadFile(fsys, "icons/"+name+".svg")
+	if err != nil {
+		return "", err
+	}
+	svg := strings.TrimSpace(string(b))
+	if !strings.HasPrefix(svg, "<svg ") {
+		return "", &fs.PathError{Op: "icon", Path: name, Err: fs.ErrInvalid}
+	}
+	return template.HTML("<svg " + iconAttrs + svg[len("<svg"):]), nil
+}
+
+// Icon returns the inline SVG for name. Unknown icons are logged once and
+// render as nothing rather than breaking the page.
+func (s *iconSet) Icon(name string) template.HTML {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if svg, ok := s.icons[name]; ok && !s.dev {
+		return svg
+	}
+	svg, err := readIcon(s.fsys, name)
+	if err != nil {
+		if _, seen := s.icons[name]; !seen {
+			log.Printf("icons: %v", err)
+		}
+	}
+	s.icons[name] = svg
+	return svg
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/image/draw"
+	"golang.org/x/sync/singleflight"
+)
+
+// imageWidths are the only widths variants are generated for. Requests for
+// other widths are rounded up, so the disk cache cannot be filled with an
+// unbounded number of variants.
+var imageWidths = []int{80, 160, 320, 480, 640, 960, 1280, 1920}
+
+// maxImagePixels guards against decompression bombs among the source images.
+const maxImagePixels = 40_000_000
+
+// imageEncoder writes an image in one output format.
+type imageEncoder struct {
+	contentType string
+	ext         string
+	encode      func(io.Writer, image.Image) error
+}
+
+// imageEncoders are the output formats we can produce in pure Go. WebP and
+// AVIF have no pure-Go encoder we can depend on yet; once one is registered
+// here it is offered automatically to browsers that accept it.
+var imageEncoders = map[string]imageEncoder{
+	"jpeg": {
+		contentType: "image/jpeg",
+		ext:         ".jpg",
+		encode: func(w io.Writer, img image.Image) error {
+			return jpeg.Encode(w, img, &jpeg.Options{Quality: 82})
+		},
+	},
+	"png": {
+		contentType: "image/png",
+		ext:         ".png",
+		encode: func(w io.Writer, img image.Image) error {
+			enc := png.Encoder{CompressionLevel: png.BestCompression}
+			return enc.Encode(w, img)
+		},
+	},
+}
+
+// negotiatedFormats are offered, in order, to browsers that list them in
+// their Accept header, provided an encoder is registered.
+var negotiatedFormats = []string{"avif", "webp"}
+
+// imageOptimizer serves resized and re-encoded variants of the images in the
+// static directory under /img/, caching every variant on disk.
+type imageOptimizer struct {
+	fsys     fs.FS
+	assets   *assetManifest
+	cacheDir string
+	group    singleflight.Group
+
+	mu    sync.Mutex
+	sizes map[string]image.Point
+}
+
+func newImageOptimizer(fsys fs.FS, assets *assetManifest, cacheDir string) (*imageOptimizer, error) {
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return nil, err
+	}
+	return &imageOptimizer{
+		fsys:     fsys,
+		assets:   assets,
+		cacheDir: cacheDir,
+		sizes:    make(map[string]image.Point),
+	}, nil
+}
+
+// defaultImageCacheDir returns the directory image variants are cached in
+// when none is configured.
+func defaultImageCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "ict-eerbeek", "img")
+}
+
+// snapWidth rounds w up to the nearest supported width.
+func snapWidth(w int) int {
+	i := sort.SearchInts(imageWidths, w)
+	if i == len(imageWidths) {
+		return imageWidths[len(imageWidths)-1]
+	}
+	return imageWidths[i]
+}
+
+// sourceFormat maps a source file to the output format it keeps by default.
+func sourceFormat(name string) (string, bool) {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".jpg", ".jpeg":
+		return "jpeg", true
+	case ".png", ".gif":
+		return "png", true
+	}
+	return "", false
+}
+
+// version returns a short content hash of a source image, used both in
+// cache keys and as the cache-busting v parameter of generated URLs.
+func (o *imageOptimizer) version(name string) (string, error) {
+	if v, ok := o.assets.Hash(name); ok {
+		return v, nil
+	}
+	b, err := fs.ReadFile(o.fsys, name)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])[:fingerprintLength], nil
+}
+
+// size returns the pixel dimensions of a source image.
+func (o *imageOptimizer) size(name string) (image.Point, error) {
+	o.mu.Lock()
+	p, ok := o.sizes[name]
+	o.mu.Unlock()
+	if ok {
+		return p, nil
+	}
+	f, err := o.fsys.Open(name)
+	if err != nil {
+		return image.Point{}, err
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return image.Point{}, err
+	}
+	p = image.Pt(cfg.Width, cfg.Height)
+	o.mu.Lock()
+	o.sizes[name] = p
+	o.mu.Unlock()
+	return p, nil
+}
+
+// variantWidth returns the width of the variant of name served for a
+// requested width: snapped to a supported width, but never wider than the
+// source, which is not upscaled.
+func (o *imageOptimizer) variantWidth(name string, width int) int {
+	width = snapWidth(width)
+	if size, err := o.size(name); err == nil && width > size.X {
+		return size.X
+	}
+	return width
+}
+
+// URL returns the URL of name resized to width, for use in templates.
+func (o *imageOptimizer) URL(name string, width int) string {
+	name = strings.TrimPrefix(name, "/")
+	v, err := o.version(name)
+	if err != nil {
+		log.Printf("images: %v", err)
+		return o.assets.URL(name)
+	}
+	return fmt.Sprintf("/img/%s?w=%d&v=%s", name, o.variantWidth(name, width), v)
+}
+
+// Srcset returns a srcset attribute value offering name at each of widths.
+// Widths larger than the source image are left out; the browser upscales
+// better than we would.
+func (o *imageOptimizer) Srcset(name string, widths ...int) template.Srcset {
+	name = strings.TrimPrefix(name, "/")
+	size, err := o.size(name)
+	if err != nil {
+		log.Printf("images: %v", err)
+		return template.Srcset(o.assets.URL(name))
+	}
+	var parts []string
+	for _, w := range widths {
+		w = o.variantWidth(name, w)
+		part := fmt.Sprintf("%s %dw", o.URL(name, w), w)
+		if len(parts) == 0 || parts[len(parts)-1] != part {
+			parts = append(parts, part)
+		}
+		if w == size.X {
+			break
+		}
+	}
+	return template.Srcset(strings.Join(parts, ", "))
+}
+
+// serve handles GET /img/*filepath?w=<width>[&f=<format>][&v=<version>].
+func (o *imageOptimizer) serve(c *gin.Context) {
+	name := strings.TrimPrefix(path.Clean(c.Param("filepath")), "/")
+	srcFormat, ok := sourceFormat(name)
+	if !ok {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	width, err := strconv.Atoi(c.Query("w"))
+	if err != nil || width <= 0 {
+		c.String(http.StatusBadRequest, "invalid width")
+		return
+	}
+
+	format := c.Query("f")
+	if format == "" {
+		format = srcFormat
+		for _, f := range negotiatedFormats {
+			if _, ok := imageEncoders[f]; ok && strings.Contains(c.GetHeader("Accept"), "image/"+f) {
+				format = f
+				break
+			}
+		}
+		c.Header("Vary", "Accept")
+	}
+	enc, ok := imageEncoders[format]
+	if !ok {
+		c.String(http.StatusBadRequest, "unsupported format")
+		return
+	}
+
+	version, err := o.version(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		c.Status(http.StatusNotFound)
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	width = o.variantWidth(name, width)
+	sum := sha256.Sum256([]byte(name + "@" + version))
+	key := fmt.Sprintf("%s-%d%s", hex.EncodeToString(sum[:8]), width, enc.ext)
+	file := filepath.Join(o.cacheDir, key)
+	if _, err := os.Stat(file); err != nil {
+		_, err, _ = o.group.Do(key, func() (any, error) {
+			return nil, o.generate(name, width, enc, file)
+		})
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	if c.Query("v") == version {
+		c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		c.Header("Cache-Control", "public, max-age=3600")
+	}
+	c.Header("ETag", `"`+key+`"`)
+	c.Header("Content-Type", enc.contentType)
+	c.File(file)
+}
+
+// generate decodes name, scales it down to width (never up) and writes the
+// encoded result to file atomically.
+func (o *imageOptimizer) generate(name string, width int, enc imageEncoder, file string) error {
+	size, err := o.size(name)
+	if err != nil {
+		return err
+	}
+	if size.X*size.Y > maxImagePixels {
+		return fmt.Errorf("images: %s is too large to process", name)
+	}
+	f, err := o.fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return fmt.Errorf("images: decode %s: %w", name, err)
+	}
+
+	img := src
+	if width < size.X {
+		height := size.Y * width / size.X
+		dst := image.NewNRGBA(image.Rect(0, 0, width, max(height, 1)))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Src, nil)
+		img = dst
+	}
+
+	tmp, err := os.CreateTemp(o.cacheDir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := enc.encode(tmp, img); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
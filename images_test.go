@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func testImageFS(t *testing.T, width, height int) fstest.MapFS {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return fstest.MapFS{"images/foto.png": {Data: buf.Bytes()}}
+}
+
+func TestSnapWidth(t *testing.T) {
+	tests := []struct{ in, want int }{
+		{1, 80},
+		{80, 80},
+		{81, 160},
+		{500, 640},
+		{1920, 1920},
+		{5000, 1920},
+	}
+	for _, tt := range tests {
+		if got := snapWidth(tt.in); got != tt.want {
+			t.Errorf("snapWidth(%d) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSrcsetWidthsMatchURLs(t *testing.T) {
+	fsys := testImageFS(t, 500, 100)
+	assets, err := buildAssets(fsys, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := newImageOptimizer(fsys, assets, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srcset := string(o.Srcset("/images/foto.png", 320, 640, 960))
+	var widths []int
+	for _, part := range strings.Split(srcset, ", ") {
+		link, descriptor, ok := strings.Cut(part, " ")
+		if !ok {
+			t.Fatalf("malformed srcset entry %q", part)
+		}
+		u, err := url.Parse(link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.Query().Get("w")+"w" != descriptor {
+			t.Errorf("srcset entry %q announces another width than it requests", part)
+		}
+		w, _ := strconv.Atoi(strings.TrimSuffix(descriptor, "w"))
+		widths = append(widths, w)
+	}
+	// 640 and 960 are wider than the source: one entry at its own width
+	if want := []int{320, 500}; !slices.Equal(widths, want) {
+		t.Errorf("srcset widths = %v, want %v (%s)", widths, want, srcset)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// OutboxMessage is an e-mail waiting to be sent. Mail is never sent from a
+// request handler: handlers queue it in the same database as the change that
+// caused it, and the mailer delivers it in the background with retries.
+type OutboxMessage struct {
+	ID          uint   `gorm:"primaryKey"`
+	To          string `gorm:"not null"`
+	Subject     string `gorm:"not null"`
+	Text        string
+	HTML        string
+	Headers     string // extra header lines, "Name: value" separated by newlines
+	Attachments []OutboxAttachment
+	CampaignID  *uint  `gorm:"index"`                           // set for newsletter campaign mail
+	Status      string `gorm:"not null;default:wachtrij;index"` // wachtrij, verzonden, mislukt or geannuleerd
+	Attempts    int    `gorm:"not null;default:0"`
+	LastError   string
+	SendAfter   time.Time `gorm:"index"`
+	SentAt      *time.Time
+	CreatedAt   time.Time
+}
+
+// OutboxAttachment is a file attached to an outbox message.
+type OutboxAttachment struct {
+	ID              uint   `gorm:"primaryKey"`
+	OutboxMessageID uint   `gorm:"not null;index"`
+	Filename        string `gorm:"not null"`
+	ContentType     string `gorm:"not null"`
+	Data            []byte
+}
+
+const (
+	outboxQueued = "wachtrij"
+	outboxSent   = "verzonden"
+	outboxFailed = "mislukt"
+	// outboxCancelled marks campaign mail that was not sent because the
+	// subscriber left the list while it was waiting.
+	outboxCancelled = "geannuleerd"
+
+	// outboxMaxAttempts is how often delivery is tried before a message is
+	// marked as failed.
+	outboxMaxAttempts = 8
+)
+
+// mailConfig holds the SMTP settings read from the environment. Without
+// SMTP_HOST messages are written to the log instead of sent, which is what
+// development copies want.
+type mailConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     mail.Address
+	// CampaignRate is how many newsletter messages are sent per minute, so
+	// a campaign does not trip the sending limits of the mail provider.
+	CampaignRate int
+}
+
+var mailer mailConfig
+
+func initMailer() {
+	mailer = mailConfig{
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     os.Getenv("SMTP_PORT"),
+		Username: os.Getenv("SMTP_USER"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     mail.Address{Name: company.Name, Address: company.Email},
+	}
+	if mailer.Port == "" {
+		mailer.Port = "587"
+	}
+	mailer.CampaignRate = 100
+	if rate := os.Getenv("NEWSLETTER_RATE"); rate != "" {
+		n, err := strconv.Atoi(rate)
+		if err != nil || n < 1 {
+			log.Fatal("NEWSLETTER_RATE must be a positive number of messages per minute")
+		}
+		mailer.CampaignRate = n
+	}
+	if from := os.Getenv("MAIL_FROM"); from != "" {
+		addr, err := mail.ParseAddress(from)
+		if err != nil {
+			log.Fatalf("MAIL_FROM is not a valid address: %v", err)
+		}
+		mailer.From = *addr
+	}
+	if mailer.Host == "" {
+		log.Print("SMTP_HOST not set, e-mail is written to the log")
+	}
+}
+
+// queueMail adds a message to the outbox. Pass a transaction as tx to queue
+// it together with the change it reports on.
+func queueMail(tx *gorm.DB, m *OutboxMessage) error {
+	m.Status = outboxQueued
+	if m.SendAfter.IsZero() {
+		m.SendAfter = time.Now().UTC()
+	}
+	return tx.Create(m).Error
+}
+
+// runMailer delivers queued messages until the process exits.
+func runMailer() {
+	for {
+		if err := deliverQueued(); err != nil {
+			log.Printf("mail: %v", err)
+		}
+		time.Sleep(10 * time.Second)
+	}
+}
+
+// deliverQueued sends every message that is due.
+func deliverQueued() error {
+	var due []OutboxMessage
+	err := db.Preload("Attachments").
+		Where("status = ? AND send_after <= ?", outboxQueued, time.Now().UTC()).
+		Order("id").Limit(50).Find(&due).Error
+	if err != nil {
+		return err
+	}
+	for _, m := range due {
+		if m.CampaignID != nil && !subscribed(m.To) {
+			if err := db.Model(&m).Update("status", outboxCancelled).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		err := mailer.send(m)
+		m.Attempts++
+		if err == nil {
+			now := time.Now().UTC()
+			m.Status, m.SentAt, m.LastError = outboxSent, &now, ""
+		} else {
+			log.Printf("mail: message %d to %s: %v", m.ID, m.To, err)
+			m.LastError = err.Error()
+			if m.Attempts >= outboxMaxAttempts {
+				m.Status = outboxFailed
+			} else {
+				// Back off exponentially: 1, 2, 4 ... minutes.
+				m.SendAfter = time.Now().UTC().Add(time.Minute << (m.Attempts - 1))
+			}
+		}
+		if err := db.Select("Status", "Attempts", "LastError", "SendAfter", "SentAt").Save(&m).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send delivers one message over SMTP, or logs it when SMTP is not set up.
+func (c mailConfig) send(m OutboxMessage) error {
+	msg, err := buildMessage(c.From, m)
+	if err != nil {
+		return err
+	}
+	if c.Host == "" {
+		log.Printf("mail: not sending (no SMTP_HOST) to %s: %s\n%s", m.To, m.Subject, m.Text)
+		return nil
+	}
+	var auth smtp.Auth
+	if c.Username != "" {
+		auth = smtp.PlainAuth("", c.Username, c.Password, c.Host)
+	}
+	return smtp.SendMail(net.JoinHostPort(c.Host, c.Port), auth, c.From.Address, []string{m.To}, msg)
+}
+
+// buildMessage renders m as a MIME message: text and HTML alternatives,
+// followed by any attachments.
+func buildMessage(from mail.Address, m OutboxMessage) ([]byte, error) {
+	var buf bytes.Buffer
+	header := func(name, value string) {
+		value = strings.NewReplacer("\r", "", "\n", "").Replace(value)
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+	}
+	header("From", from.String())
+	header("To", m.To)
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", fmt.Sprintf("<outbox-%d.%d@%s>", m.ID, m.CreatedAt.Unix(), mailDomain(from.Address)))
+	header("MIME-Version", "1.0")
+	for _, line := range strings.Split(m.Headers, "\n") {
+		if name, value, ok := strings.Cut(line, ":"); ok {
+			header(strings.TrimSpace(name), strings.TrimSpace(value))
+		}
+	}
+
+	mixed := multipart.NewWriter(&buf)
+	header("Content-Type", "multipart/mixed; boundary="+mixed.Boundary())
+	buf.WriteString("\r\n")
+
+	altBuf := new(bytes.Buffer)
+	alt := multipart.NewWriter(altBuf)
+	if err := writeQuotedPart(alt, "text/plain; charset=utf-8", m.Text); err != nil {
+		return nil, err
+	}
+	if m.HTML != "" {
+		if err := writeQuotedPart(alt, "text/html; charset=utf-8", m.HTML); err != nil {
+			return nil, err
+		}
+	}
+	alt.Close()
+	part, err := mixed.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"multipart/alternative; boundary=" + alt.Boundary()},
+	})
+	if err != nil {
+		return nil, err
+	}
+	part.Write(altBuf.Bytes())
+
+	for _, a := range m.Attachments {
+		part, err := mixed.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {a.ContentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename})},
+		})
+		if err != nil {
+			return nil, err
+		}
+		enc := base64.StdEncoding.EncodeToString(a.Data)
+		for len(enc) > 76 {
+			part.Write([]byte(enc[:76] + "\r\n"))
+			enc = enc[76:]
+		}
+		part.Write([]byte(enc + "\r\n"))
+	}
+	mixed.Close()
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPart(w *multipart.Writer, contentType, body string) error {
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+	qp := quotedprintable.NewWriter(part)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+var outboxMessageID = regexp.MustCompile(`^<?outbox-(\d+)\.\d+@`)
+
+// outboxIDFromMessageID returns the outbox message a Message-ID header set
+// by buildMessage belongs to, as reported back in bounces.
+func outboxIDFromMessageID(messageID string) (uint, bool) {
+	m := outboxMessageID.FindStringSubmatch(strings.TrimSpace(messageID))
+	if m == nil {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(m[1], 10, 64)
+	return uint(id), err == nil
+}
+
+func mailDomain(address string) string {
+	if _, domain, ok := strings.Cut(address, "@"); ok {
+		return domain
+	}
+	return "localhost"
+}
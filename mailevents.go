@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// MailEvent is something that happened to sent mail after it left us: a
+// bounce or complaint reported by the mail provider, or an unsubscribe from
+// a campaign.
+type MailEvent struct {
+	ID              uint   `gorm:"primaryKey"`
+	Type            string `gorm:"not null;index"`
+	Email           string `gorm:"not null;index"`
+	OutboxMessageID *uint
+	CampaignID      *uint `gorm:"index"`
+	Detail          string
+	CreatedAt       time.Time
+}
+
+// Mail event types.
+const (
+	mailEventBounce      = "harde bounce"
+	mailEventSoftBounce  = "zachte bounce"
+	mailEventComplaint   = "klacht"
+	mailEventUnsubscribe = "afmelding"
+)
+
+// softBounceLimit is how many temporary delivery failures in a row make an
+// address undeliverable.
+const softBounceLimit = 3
+
+// mailWebhookEvent is an event as posted to the webhook by the mail
+// provider, usually through a small adapter for its own format.
+type mailWebhookEvent struct {
+	Type      string `json:"type"` // bounce or complaint
+	Email     string `json:"email"`
+	MessageID string `json:"message_id"` // Message-ID header of the original mail
+	Permanent bool   `json:"permanent"`  // for bounces: hard rather than soft
+	Reason    string `json:"reason"`
+}
+
+// mailWebhookHandler returns the handler for POST /api/mail/events, which
+// takes one event or a list of them as JSON. The provider authenticates
+// with the MAIL_WEBHOOK_SECRET as bearer token; without it the webhook is
+// disabled and nil is returned.
+func mailWebhookHandler() gin.HandlerFunc {
+	secret := os.Getenv("MAIL_WEBHOOK_SECRET")
+	if secret == "" {
+		log.Print("MAIL_WEBHOOK_SECRET not set, bounce webhook disabled")
+		return nil
+	}
+	return func(c *gin.Context) {
+		token, _ := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		body, err := c.GetRawData()
+		if err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		var events []mailWebhookEvent
+		if body = bytes.TrimSpace(body); len(body) > 0 && body[0] == '{' {
+			events = make([]mailWebhookEvent, 1)
+			err = json.Unmarshal(body, &events[0])
+		} else {
+			err = json.Unmarshal(body, &events)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		for _, e := range events {
+			if err := recordMailEvent(e); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"received": len(events)})
+	}
+}
+
+var errUnknownMailEvent = errors.New("unknown event type")
+
+// recordMailEvent stores a reported event and takes the subscriber off the
+// list when mail to them bounces for good or they mark it as spam.
+func recordMailEvent(e mailWebhookEvent) error {
+	event := MailEvent{Email: normalizeEmail(e.Email), Detail: e.Reason}
+	switch {
+	case e.Type == "complaint":
+		event.Type = mailEventComplaint
+	case e.Type == "bounce" && e.Permanent:
+		event.Type = mailEventBounce
+	case e.Type == "bounce":
+		event.Type = mailEventSoftBounce
+	default:
+		return errUnknownMailEvent
+	}
+	if id, ok := outboxIDFromMessageID(e.MessageID); ok {
+		var m OutboxMessage
+		if err := db.Select("id", "to", "campaign_id").First(&m, id).Error; err == nil {
+			event.OutboxMessageID, event.CampaignID = &m.ID, m.CampaignID
+			if event.Email == "" {
+				event.Email = normalizeEmail(m.To)
+			}
+		}
+	}
+	if event.Email == "" {
+		return errors.New("event without e-mail address or known message_id")
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&event).Error; err != nil {
+			return err
+		}
+		var s Subscriber
+		err := tx.Where("email = ? AND status = ?", event.Email, subscriberActive).First(&s).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		switch event.Type {
+		case mailEventComplaint:
+			s.Status, s.UnsubscribedAt = subscriberUnsubscribed, &now
+		case mailEventBounce:
+			s.Status = subscriberBounced
+		case mailEventSoftBounce:
+			if bounces, err := softBouncesSince(tx, s); err != nil || bounces < softBounceLimit {
+				return err
+			}
+			s.Status = subscriberBounced
+		}
+		log.Printf("newsletter: %s taken off the list after %s", s.Email, event.Type)
+		return tx.Model(&s).Select("status", "unsubscribed_at").Updates(&s).Error
+	})
+}
+
+// softBouncesSince counts the temporary failures for s since mail to them
+// last got through.
+func softBouncesSince(tx *gorm.DB, s Subscriber) (int64, error) {
+	var lastSent OutboxMessage
+	since := s.RequestedAt
+	err := tx.Select("sent_at").Where(`"to" = ? AND status = ? AND id NOT IN (?)`, s.Email, outboxSent,
+		tx.Model(&MailEvent{}).Select("outbox_message_id").Where("email = ? AND outbox_message_id IS NOT NULL", s.Email)).
+		Order("sent_at DESC").First(&lastSent).Error
+	if err == nil && lastSent.SentAt != nil && lastSent.SentAt.After(since) {
+		since = *lastSent.SentAt
+	} else if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, err
+	}
+	var n int64
+	err = tx.Model(&MailEvent{}).Where("email = ? AND type = ? AND created_at > ?", s.Email, mailEventSoftBounce, since.UTC()).
+		Count(&n).Error
+	return n, err
+}
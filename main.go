@@ -2,10 +2,14 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"html/template"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -17,17 +21,21 @@ import (
 
 // Contact represents a contact form submission
 type Contact struct {
-	ID         uint      `json:"id" gorm:"primaryKey"`
-	Naam       string    `json:"naam" gorm:"not null"`
-	Bedrijf    string    `json:"bedrijf"`
-	Email      string    `json:"email" gorm:"not null"`
-	Telefoon   string    `json:"telefoon"`
-	Onderwerp  string    `json:"onderwerp" gorm:"not null"`
-	Urgentie   string    `json:"urgentie"`
-	Bericht    string    `json:"bericht" gorm:"not null"`
-	Privacy    bool      `json:"privacy" gorm:"not null"`
-	Nieuwsbrief bool     `json:"nieuwsbrief"`
-	CreatedAt  time.Time `json:"created_at"`
+	ID            uint         `json:"id" gorm:"primaryKey"`
+	Naam          string       `json:"naam" form:"naam" gorm:"not null;serializer:encrypted"`
+	Bedrijf       string       `json:"bedrijf" form:"bedrijf" gorm:"serializer:encrypted"`
+	Email         string       `json:"email" form:"email" gorm:"not null;serializer:encrypted"`
+	EmailIndex    string       `json:"-" form:"-" gorm:"index"` // blind index of Email, see byEmail
+	Telefoon      string       `json:"telefoon" form:"telefoon" gorm:"serializer:encrypted"`
+	Onderwerp     string       `json:"onderwerp" form:"onderwerp" gorm:"not null"`
+	Urgentie      string       `json:"urgentie" form:"urgentie"`
+	Bericht       string       `json:"bericht" form:"bericht" gorm:"not null;serializer:encrypted"`
+	Privacy       bool         `json:"privacy" form:"privacy" gorm:"not null"`
+	PrivacyVersie string       `json:"privacy_versie" form:"privacy_versie" gorm:"-"` // policy version the form showed, see recordConsent
+	Nieuwsbrief   bool         `json:"nieuwsbrief" form:"nieuwsbrief"`
+	ReactieVoor   time.Time    `json:"reactie_voor" form:"-"` // response deadline, see responseTargets
+	Attachments   []Attachment `json:"-" form:"-"`            // files sent with a multipart submission
+	CreatedAt     time.Time    `json:"created_at" form:"-"`
 }
 
 // PageData represents data passed to templates
@@ -35,35 +43,308 @@ type PageData struct {
 	Title       string
 	Description string
 	Page        string
-	Content     template.HTML
+	Path        string
+	Kind        pageKind
+	FAQs        []FAQ
+	SEO         SEO
+	Data        any           // page specific, e.g. the appointment on /afspraak/annuleren
+	Consent     CookieConsent // set by the renderer, see consentWriter
 }
 
 var db *gorm.DB
 var geminiClient *genai.GenerativeModel
 
+// devMode serves templates and static files from disk instead of the copies
+// embedded in the binary, and reloads templates when they change.
+var devMode = flag.Bool("dev", false, "read templates and static files from disk and reload templates on change")
+
+// imageCacheDir is where resized image variants served under /img/ are kept.
+var imageCacheDir = flag.String("image-cache", defaultImageCacheDir(), "directory for cached image variants")
+
+// companyConfig overrides the embedded company profile.
+var companyConfig = flag.String("company", "", "JSON file with the company profile (default: embedded config/company.json)")
+
+// bookingConfigFile overrides the embedded appointment types and availability.
+var bookingConfigFile = flag.String("booking", "", "JSON file with appointment types and technician availability (default: embedded config/booking.json)")
+
+// retentionConfigFile overrides the embedded retention policies.
+var retentionConfigFile = flag.String("retention", "", "JSON file with retention periods for personal data (default: embedded config/retention.json)")
+
+// reencrypt encrypts the contact requests, tickets and authenticator secrets again with the current
+// key after a key rotation.
+var reencrypt = flag.Bool("reencrypt", false, "encrypt personal data again with the first key of DATA_KEYS and exit")
+
+// retentionReport prints what the retention policies would remove and exits.
+var retentionReport = flag.Bool("retention-report", false, "print what the retention policies would remove now and exit")
+
+// printFeeds prints the calendar feed URL of every technician and exits.
+var printFeeds = flag.Bool("print-feeds", false, "print the technicians' calendar feed URLs and exit")
+
 func main() {
-	// Initialize database
+	flag.Parse()
+
+	// Deployment settings: environment and public base URL
+	initSiteConfig()
+
+	// Company name, contact details and opening hours
+	initCompanyProfile(*companyConfig)
+
+	// Appointment types and technician availability
+	initBooking(*bookingConfigFile)
+	if *printFeeds {
+		for _, t := range booking.Technicians {
+			fmt.Printf("%s: %s\n", t.Name, technicianFeedURL(t))
+		}
+		return
+	}
+
+	// How long personal data is kept
+	initRetention(*retentionConfigFile)
+
+	// Initialize database; personal data in it is encrypted
+	initDataKeys()
+	initStorage()
 	initDatabase()
+	if *reencrypt {
+		n, err := reencryptContacts()
+		if err != nil {
+			log.Fatal(err)
+		}
+		tickets, err := reencryptTickets()
+		if err != nil {
+			log.Fatal(err)
+		}
+		users, err := reencryptAdminUsers()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%d contact requests, %d tickets and %d admin users encrypted with key %s\n", n, tickets, users, dataKeys[0].id)
+		return
+	}
+	if *retentionReport {
+		if err := printRetentionReport(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
 	// Initialize Gemini client
 	initGeminiClient()
 
+	// Deliver queued e-mail in the background
+	initMailer()
+	go runMailer()
+
+	// Remove personal data past its retention period
+	go runRetention()
+
+	// Store page views and roll them up per day
+	go runAnalytics()
+
 	// Create Gin router
 	r := gin.Default()
+	if err := r.SetTrustedProxies(site.TrustedProxies); err != nil {
+		log.Fatal(err)
+	}
+	r.Use(noIndexOutsideProduction, cookieConsentMiddleware, analyticsMiddleware)
+
+	templateFS, staticFS := siteFiles(*devMode)
+
+	// Fingerprint static files for long-lived caching
+	assets, err := buildAssets(staticFS, *devMode)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Self-hosted icons and fonts instead of third-party CDNs
+	icons, err := loadIcons(staticFS, *devMode)
+	if err != nil {
+		log.Fatal(err)
+	}
+	faces, err := fontFaces(assets)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Resized and re-encoded variants of the static images
+	images, err := newImageOptimizer(staticFS, assets, *imageCacheDir)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	// Load HTML templates
-	r.LoadHTMLGlob("templates/*")
+	// Generated Open Graph share images
+	ogImages, err := newOGImages(staticFS, company.Logo)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Quote documents
+	quotePDFs, err := newQuotePDF(staticFS, company.Logo)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Newsletter e-mails
+	campaignMails, err := newCampaignMailer(templateFS)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Published versions of the privacy policy
+	if privacyPolicies, err = loadPrivacyPolicies(templateFS); err != nil {
+		log.Fatal(err)
+	}
+
+	// Load HTML templates; every page is rendered through base.html
+	pages, err := loadPages(templateFS, template.FuncMap{
+		"asset":            assets.URL,
+		"icon":             icons.Icon,
+		"fontFaces":        func() template.HTML { return faces },
+		"img":              images.URL,
+		"srcset":           images.Srcset,
+		"company":          func() CompanyProfile { return company },
+		"openingStatus":    func() OpeningStatus { return company.StatusAt(time.Now()) },
+		"closures":         func(days int) []ClosedDays { return company.UpcomingClosures(time.Now(), days) },
+		"services":         func() []Service { return services },
+		"appointmentTypes": func() []AppointmentType { return booking.Types },
+		"structuredData":   structuredDataFunc(assets),
+		"euro":             formatEuro,
+		"amount":           formatAmount,
+		"quantity":         formatQuantity,
+		"dateNL":           dateNL,
+		"dateTimeNL":       dateTimeNL,
+		"fileSize":         formatFileSize,
+		"dutchDate":        dutchDate,
+		"privacyPolicy":    currentPolicy,
+		"earlierPolicies":  earlierPolicies,
+		"cookieCategories": func() []cookieCategory { return cookieCategories },
+		"consentTo":        consentTo,
+		"dateInput":        func(t time.Time) string { return t.In(openingHoursLocation).Format(time.DateOnly) },
+	}, *devMode)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.HTMLRender = pages
 
 	// Serve static files
-	r.Static("/static", "./static")
+	r.GET("/static/*filepath", assets.serve)
+	r.HEAD("/static/*filepath", assets.serve)
+	r.GET("/img/*filepath", images.serve)
+	r.GET("/og/:page", ogImages.serve)
 
 	// Routes
-	r.GET("/", homeHandler)
-	r.GET("/diensten", dienstenHandler)
-	r.GET("/over-ons", overOnsHandler)
-	r.GET("/contact", contactGetHandler)
-	r.POST("/contact", contactPostHandler)
-	r.GET("/privacybeleid", privacybeleidHandler)
+	for _, p := range sitePages {
+		r.GET(p.Path, pageHandler(p))
+	}
+	r.GET("/privacybeleid/versies/:version", privacyPolicyVersionHandler)
+	r.POST("/contact", limitBody(maxUploadBody), contactPostHandler)
+	r.GET("/sitemap.xml", sitemapHandler(templateFS))
+	r.GET("/robots.txt", robotsHandler)
+	r.GET("/api/opening-hours", openingHoursHandler)
+
+	// Appointment booking
+	r.POST("/afspraak", appointmentPostHandler)
+	r.GET("/api/afspraak/slots", appointmentSlotsHandler)
+	r.GET("/afspraak/bevestigen", appointmentActionHandler(tokenAppointmentConfirm))
+	r.POST("/afspraak/bevestigen", appointmentActionHandler(tokenAppointmentConfirm))
+	r.GET("/afspraak/annuleren", appointmentActionHandler(tokenAppointmentCancel))
+	r.POST("/afspraak/annuleren", appointmentActionHandler(tokenAppointmentCancel))
+	r.GET("/afspraak/agenda.ics", appointmentCalendarHandler)
+	r.GET("/afspraak/feed/:file", technicianFeedHandler)
+
+	// Quotes
+	r.GET("/offerte", quoteHandler)
+	r.GET("/offerte/pdf", quotePDFHandler(quotePDFs))
+	r.POST("/offerte/accepteren", quoteAcceptHandler)
+
+	// Admin area: staff log in with a password and an authenticator app;
+	// privacy requests, the audit log, sending campaigns and user
+	// management are for owners only
+	admin := adminGroup(r)
+	admin.GET("/inloggen", adminLoginHandler)
+	admin.POST("/inloggen", adminLoginPostHandler)
+	admin.GET("/inloggen/code", adminLoginCodeHandler)
+	admin.POST("/inloggen/code", adminLoginCodeHandler)
+	admin.GET("/inloggen/koppelen", adminEnrolHandler)
+	admin.POST("/inloggen/koppelen", adminEnrolHandler)
+	admin.POST("/uitloggen", adminLogoutHandler)
+	staff := admin.Group("", requireAdmin)
+	staff.GET("/", func(c *gin.Context) { c.Redirect(http.StatusFound, "/admin/contacten") })
+	staff.GET("/account", adminAccountHandler)
+	staff.POST("/account/wachtwoord", adminPasswordHandler)
+	staff.POST("/account/herstelcodes", adminRecoveryCodesHandler)
+	staff.GET("/contacten", adminContactsHandler)
+	staff.GET("/offertes", adminQuotesHandler)
+	staff.GET("/offertes/nieuw", adminNewQuoteHandler)
+	staff.POST("/offertes", adminCreateQuoteHandler)
+	staff.GET("/offertes/:id", adminQuoteHandler)
+	staff.POST("/offertes/:id", adminUpdateQuoteHandler)
+	staff.POST("/offertes/:id/verzenden", adminSendQuoteHandler(quotePDFs))
+	staff.GET("/offertes/:id/pdf", adminQuotePDFHandler(quotePDFs))
+	staff.GET("/tickets", adminTicketsHandler)
+	staff.GET("/tickets/:id", adminTicketHandler)
+	staff.POST("/tickets/:id", limitBody(maxUploadBody), adminTicketReplyHandler)
+	staff.POST("/tickets/:id/prioriteit", adminTicketPriorityHandler)
+	staff.GET("/bijlagen/:id", adminAttachmentHandler)
+	staff.GET("/nieuwsbrief", adminSubscribersHandler)
+	staff.POST("/nieuwsbrief/bevestigingen", adminSendConfirmationsHandler)
+	staff.GET("/nieuwsbrief/campagnes", adminCampaignsHandler)
+	staff.GET("/nieuwsbrief/campagnes/nieuw", adminNewCampaignHandler)
+	staff.POST("/nieuwsbrief/campagnes", adminCreateCampaignHandler)
+	staff.GET("/nieuwsbrief/campagnes/:id", adminCampaignHandler)
+	staff.POST("/nieuwsbrief/campagnes/:id", adminUpdateCampaignHandler)
+	staff.GET("/nieuwsbrief/campagnes/:id/voorbeeld", adminCampaignPreviewHandler(campaignMails))
+	staff.POST("/nieuwsbrief/campagnes/:id/test", adminTestCampaignHandler(campaignMails))
+	staff.GET("/statistieken", adminAnalyticsHandler)
+	owner := staff.Group("", requireRole(roleOwner))
+	owner.POST("/nieuwsbrief/campagnes/:id/verzenden", adminSendCampaignHandler(campaignMails))
+	owner.GET("/privacy", adminPrivacyHandler)
+	owner.POST("/privacy/export", adminPrivacyExportHandler)
+	owner.POST("/privacy/verwijderen", adminPrivacyEraseHandler)
+	owner.GET("/logboek", adminAuditHandler)
+	owner.GET("/gebruikers", adminUsersHandler)
+	owner.POST("/gebruikers", adminCreateUserHandler)
+	owner.POST("/gebruikers/:id", adminUpdateUserHandler)
+
+	// Support tickets
+	r.GET("/ticket", ticketHandler)
+	r.POST("/ticket/reageren", limitBody(maxUploadBody), ticketReplyHandler)
+	r.GET("/ticket/bijlage/:id", ticketAttachmentHandler)
+
+	// Newsletter subscriptions
+	r.POST("/nieuwsbrief", newsletterPostHandler)
+	r.GET("/nieuwsbrief/bevestigen", newsletterActionHandler(tokenNewsletterConfirm))
+	r.POST("/nieuwsbrief/bevestigen", newsletterActionHandler(tokenNewsletterConfirm))
+	r.GET("/nieuwsbrief/afmelden", newsletterActionHandler(tokenNewsletterUnsubscribe))
+	r.POST("/nieuwsbrief/afmelden", newsletterActionHandler(tokenNewsletterUnsubscribe))
+	if h := mailWebhookHandler(); h != nil {
+		r.POST("/api/mail/events", h)
+	}
+
+	// Requests to see or erase personal data
+	privacy := privacyGroup(r)
+	privacy.GET("", privacyRequestHandler)
+	privacy.POST("", privacyRequestPostHandler)
+	privacy.GET("/inzage", privacyActionHandler(tokenDataExport))
+	privacy.POST("/inzage", privacyActionHandler(tokenDataExport))
+	privacy.GET("/verwijderen", privacyActionHandler(tokenDataErasure))
+	privacy.POST("/verwijderen", privacyActionHandler(tokenDataErasure))
+
+	// Cookie consent
+	cookies := cookieGroup(r)
+	cookies.GET("", cookieSettingsHandler)
+	cookies.POST("", cookieSettingsPostHandler)
+
+	// Customer portal
+	account := accountGroup(r)
+	account.GET("/inloggen", accountLoginHandler)
+	account.POST("/inloggen", accountLoginPostHandler)
+	account.GET("/inloggen/link", accountLinkHandler)
+	account.POST("/inloggen/link", accountLinkHandler)
+	account.POST("/uitloggen", accountLogoutHandler)
+	account.GET("", requireCustomer, accountHandler)
+	account.GET("/profiel", requireCustomer, accountProfileHandler)
+	account.POST("/profiel", requireCustomer, accountProfileHandler)
 
 	// New route for Gemini chat
 	r.POST("/chat", chatHandler)
@@ -72,18 +353,63 @@ func main() {
 	r.Run("0.0.0.0:8080")
 }
 
+// Migration records that a one-time data migration was applied.
+type Migration struct {
+	Name      string `gorm:"primaryKey"`
+	AppliedAt time.Time
+}
+
+// migrateOnce applies migrate unless it was applied before, and records it
+// in the same transaction.
+func migrateOnce(name string, migrate func(tx *gorm.DB) error) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var n int64
+		if err := tx.Model(&Migration{}).Where("name = ?", name).Count(&n).Error; err != nil || n > 0 {
+			return err
+		}
+		if err := migrate(tx); err != nil {
+			return err
+		}
+		return tx.Create(&Migration{Name: name, AppliedAt: time.Now().UTC()}).Error
+	})
+}
+
 func initDatabase() {
 	var err error
-	db, err = gorm.Open(sqlite.Open("ict_eerbeek.db"), &gorm.Config{})
+	// Transactions take the write lock when they begin, so a transaction
+	// that checks for a free slot and then reserves it cannot interleave
+	// with another one. Times are stored in UTC so they compare as text.
+	db, err = gorm.Open(sqlite.Open("ict_eerbeek.db?_txlock=immediate&_busy_timeout=5000"), &gorm.Config{
+		NowFunc: func() time.Time { return time.Now().UTC() },
+	})
 	if err != nil {
 		panic("Failed to connect to database: " + err.Error())
 	}
 
 	// Auto migrate the schema
-	err = db.AutoMigrate(&Contact{})
+	err = db.AutoMigrate(&Contact{}, &Appointment{}, &OutboxMessage{}, &OutboxAttachment{}, &Quote{}, &QuoteLine{},
+		&Ticket{}, &TicketMessage{}, &Attachment{}, &Customer{}, &Session{}, &Subscriber{},
+		&Campaign{}, &MailEvent{}, &DataRequest{}, &ConsentRecord{},
+		&AnalyticsHit{}, &AnalyticsSalt{}, &AnalyticsDay{}, &AuditEvent{},
+		&AdminUser{}, &AdminRecoveryCode{}, &AdminLoginAttempt{}, &Migration{})
 	if err != nil {
 		panic("Failed to migrate database: " + err.Error())
 	}
+	if err := protectAuditLog(); err != nil {
+		panic("Failed to protect audit log: " + err.Error())
+	}
+	if err := migrateTicketAttachments(); err != nil {
+		panic("Failed to migrate ticket attachments: " + err.Error())
+	}
+	if err := migrateEncryption(); err != nil {
+		panic("Failed to encrypt personal data: " + err.Error())
+	}
+	if err := migrateOnce("nieuwsbrief-contacten", migrateNewsletterContacts); err != nil {
+		panic("Failed to migrate newsletter contacts: " + err.Error())
+	}
+	if err := seedAdminUser(); err != nil {
+		panic("Failed to create admin user: " + err.Error())
+	}
 }
 
 func initGeminiClient() {
@@ -102,17 +428,29 @@ func initGeminiClient() {
 
 func chatHandler(c *gin.Context) {
 	var request struct {
-		Message string `json:"message"`
+		Message       string `json:"message"`
+		Privacy       bool   `json:"privacy"`        // agreed to the privacy policy, needed once
+		PrivacyVersie string `json:"privacy_versie"` // the version shown
 	}
 
 	if err := c.BindJSON(&request); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if ok, err := chatConsent(c, request.Privacy, request.PrivacyVersie); errors.Is(err, errPolicyChanged) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "privacy_versie": currentPolicy().Version})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	} else if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": errNoConsent.Error(), "privacy_versie": currentPolicy().Version})
+		return
+	}
 
 	ctx := context.Background()
 
-	resp, err := geminiClient.GenerateContent(ctx, genai.Text(request.Message))
+	resp, err := geminiClient.GenerateContent(ctx, genai.Text(chatContext()), genai.Text(request.Message))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -126,379 +464,89 @@ func chatHandler(c *gin.Context) {
 	}
 }
 
-func homeHandler(c *gin.Context) {
-	data := PageData{
-		Title:       "Home",
-		Description: "ICT Eerbeek - Uw betrouwbare partner voor alle ICT-oplossingen in Eerbeek en omgeving. Netwerk & security, website ontwerp, IoT & AI oplossingen, en computerhulp.",
-		Page:        "home",
-	}
-
-	// Read home template content
-	homeContent := `<!-- Hero Section -->
-<section class="hero">
-    <div class="hero-container">
-        <h1>Welkom bij ICT Eerbeek</h1>
-        <p>Uw betrouwbare partner voor alle ICT-oplossingen in Eerbeek en omgeving. Van netwerk & security tot website ontwerp en AI-oplossingen.</p>
-        <a href="/contact" class="cta-button">Neem Contact Op</a>
-    </div>
-</section>
-
-<!-- Services Section -->
-<section class="services">
-    <div class="services-container">
-        <h2 class="section-title">Onze Diensten</h2>
-        <div class="services-grid">
-            <div class="service-card">
-                <div class="service-icon">
-                    <i class="fas fa-shield-alt"></i>
-                </div>
-                <h3>Netwerk & Security</h3>
-                <p>Professionele netwerkoplossingen en beveiligingssystemen om uw bedrijf te beschermen tegen cyberdreigingen en optimale prestaties te garanderen.</p>
-            </div>
-            <div class="service-card">
-                <div class="service-icon">
-                    <i class="fas fa-palette"></i>
-                </div>
-                <h3>Website & Logo Ontwerp</h3>
-                <p>Creatieve en professionele website- en logo-ontwerpen die uw merk versterken en uw online aanwezigheid verbeteren.</p>
-            </div>
-            <div class="service-card">
-                <div class="service-icon">
-                    <i class="fas fa-microchip"></i>
-                </div>
-                <h3>IoT & AI Oplossingen</h3>
-                <p>Innovatieve Internet of Things en Artificial Intelligence oplossingen om uw bedrijfsprocessen te automatiseren en optimaliseren.</p>
-            </div>
-            <div class="service-card">
-                <div class="service-icon">
-                    <i class="fas fa-tools"></i>
-                </div>
-                <h3>All-round Computerhulp</h3>
-                <p>Uitgebreide computerondersteuning voor particulieren en bedrijven, van hardware reparaties tot software installaties en training.</p>
-            </div>
-        </div>
-    </div>
-</section>
-
-<!-- About Preview Section -->
-<section class="about-preview" style="padding: 6rem 0; background: linear-gradient(135deg, #f8f9fa, #e9ecef);">
-    <div class="services-container">
-        <div style="display: grid; grid-template-columns: 1fr 1fr; gap: 4rem; align-items: center;">
-            <div>
-                <h2 style="font-size: 2.5rem; font-weight: 700; margin-bottom: 1.5rem; color: var(--text-dark);">Waarom ICT Eerbeek?</h2>
-                <p style="font-size: 1.1rem; line-height: 1.7; color: var(--text-light); margin-bottom: 2rem;">
-                    Met jarenlange ervaring in de ICT-sector bieden wij betrouwbare en innovatieve oplossingen voor al uw technologische uitdagingen. 
-                    Ons team van experts staat klaar om uw bedrijf naar het volgende niveau te tillen.
-                </p>
-                <div style="display: grid; grid-template-columns: 1fr 1fr; gap: 1rem;">
-                    <div style="text-align: center; padding: 1rem;">
-                        <div style="font-size: 2rem; font-weight: 700; color: var(--primary-green);">10+</div>
-                        <div style="color: var(--text-light);">Jaar Ervaring</div>
-                    </div>
-                    <div style="text-align: center; padding: 1rem;">
-                        <div style="font-size: 2rem; font-weight: 700; color: var(--primary-blue);">100+</div>
-                        <div style="color: var(--text-light);">Tevreden Klanten</div>
-                    </div>
-                    <div style="text-align: center; padding: 1rem;">
-                        <div style="font-size: 2rem; font-weight: 700; color: var(--primary-purple);">24/7</div>
-                        <div style="color: var(--text-light);">Ondersteuning</div>
-                    </div>
-                    <div style="text-align: center; padding: 1rem;">
-                        <div style="font-size: 2rem; font-weight: 700; color: var(--primary-brown);">100%</div>
-                        <div style="color: var(--text-light);">Tevredenheid</div>
-                    </div>
-                </div>
-            </div>
-            <div style="text-align: center;">
-                <img src="/static/images/logo.jpg" alt="ICT Eerbeek Team" style="max-width: 100%; height: auto; border-radius: 15px; box-shadow: 0 10px 30px rgba(0,0,0,0.1);">
-            </div>
-        </div>
-    </div>
-</section>
-
-<!-- Contact CTA Section -->
-<section style="background: linear-gradient(135deg, var(--primary-green), var(--primary-blue)); color: white; padding: 4rem 0; text-align: center;">
-    <div class="services-container">
-        <h2 style="font-size: 2.5rem; font-weight: 700; margin-bottom: 1rem;">Klaar om te beginnen?</h2>
-        <p style="font-size: 1.2rem; margin-bottom: 2rem; opacity: 0.9;">
-            Neem vandaag nog contact met ons op voor een vrijblijvende consultatie en ontdek hoe wij uw ICT-uitdagingen kunnen oplossen.
-        </p>
-        <div style="display: flex; gap: 1rem; justify-content: center; flex-wrap: wrap;">
-            <a href="/contact" class="cta-button">Contact Opnemen</a>
-            <a href="/diensten" style="display: inline-block; background: transparent; color: white; padding: 1rem 2rem; text-decoration: none; border: 2px solid white; border-radius: 50px; font-weight: 600; transition: all 0.3s ease;">Bekijk Diensten</a>
-        </div>
-    </div>
-</section>`
-
-	data.Content = template.HTML(homeContent)
-	c.HTML(http.StatusOK, "base.html", data)
-}
-
-func dienstenHandler(c *gin.Context) {
-	data := PageData{
-		Title:       "Onze Diensten",
-		Description: "Ontdek ons uitgebreide aanbod van ICT-oplossingen: netwerk & security, website & logo ontwerp, IoT & AI oplossingen, en all-round computerhulp.",
-		Page:        "diensten",
-	}
-
-	dienstenContent := `<!-- Page Header -->
-<section class="page-header">
-    <div class="hero-container">
-        <h1>Onze Diensten</h1>
-        <p>Ontdek ons uitgebreide aanbod van ICT-oplossingen voor particulieren en bedrijven</p>
-    </div>
-</section>
-
-<!-- Page Content -->
-<div class="page-content">
-    <!-- Netwerk & Security -->
-    <div class="content-section" id="netwerk-security">
-        <h2><i class="fas fa-shield-alt" style="color: var(--primary-blue); margin-right: 1rem;"></i>Netwerk & Security</h2>
-        <p>In de digitale wereld van vandaag is een betrouwbaar netwerk en sterke beveiliging essentieel voor elk bedrijf. Wij bieden uitgebreide netwerkoplossingen en beveiligingsdiensten om uw bedrijf te beschermen.</p>
-        
-        <div class="services-grid" style="margin-top: 2rem;">
-            <div class="service-card">
-                <h3>Netwerkinstallatie</h3>
-                <p>Professionele installatie van bedrijfsnetwerken, inclusief bekabeling, switches, routers en access points voor optimale connectiviteit.</p>
-            </div>
-            <div class="service-card">
-                <h3>Firewall Configuratie</h3>
-                <p>Implementatie en configuratie van geavanceerde firewalls om uw netwerk te beschermen tegen externe bedreigingen.</p>
-            </div>
-            <div class="service-card">
-                <h3>VPN Oplossingen</h3>
-                <p>Veilige externe toegang tot uw bedrijfsnetwerk via Virtual Private Network oplossingen voor thuiswerkers.</p>
-            </div>
-            <div class="service-card">
-                <h3>Security Monitoring</h3>
-                <p>24/7 monitoring van uw netwerk om verdachte activiteiten te detecteren en direct actie te ondernemen.</p>
-            </div>
-        </div>
-    </div>
-
-    <!-- Website & Logo Ontwerp -->
-    <div class="content-section" id="website-logo">
-        <h2><i class="fas fa-palette" style="color: var(--primary-green); margin-right: 1rem;"></i>Website & Logo Ontwerp</h2>
-        <p>Uw online aanwezigheid is cruciaal voor het succes van uw bedrijf. Wij creëren professionele websites en memorabele logo's die uw merk versterken en klanten aantrekken.</p>
-        
-        <div class="services-grid" style="margin-top: 2rem;">
-            <div class="service-card">
-                <h3>Responsive Webdesign</h3>
-                <p>Moderne websites die perfect werken op alle apparaten, van desktop tot smartphone, met focus op gebruikerservaring.</p>
-            </div>
-            <div class="service-card">
-                <h3>E-commerce Oplossingen</h3>
-                <p>Volledige webshops met betalingssystemen, voorraadbeheersystemen en klantenportalen voor online verkoop.</p>
-            </div>
-            <div class="service-card">
-                <h3>Logo & Branding</h3>
-                <p>Creatieve logo-ontwerpen en complete huisstijlen die uw bedrijf onderscheiden van de concurrentie.</p>
-            </div>
-            <div class="service-card">
-                <h3>SEO Optimalisatie</h3>
-                <p>Zoekmachine optimalisatie om uw website beter vindbaar te maken in Google en andere zoekmachines.</p>
-            </div>
-        </div>
-    </div>
-
-    <!-- IoT & AI Oplossingen -->
-    <div class="content-section" id="iot-ai">
-        <h2><i class="fas fa-microchip" style="color: var(--primary-purple); margin-right: 1rem;"></i>IoT & AI Oplossingen</h2>
-        <p>Stap in de toekomst met onze innovatieve Internet of Things en Artificial Intelligence oplossingen. Automatiseer processen, verzamel waardevolle data en optimaliseer uw bedrijfsvoering.</p>
-        
-        <div class="services-grid" style="margin-top: 2rem;">
-            <div class="service-card">
-                <h3>Smart Building Systemen</h3>
-                <p>Intelligente gebouwbeheersystemen voor verlichting, klimaatbeheersing en beveiliging met IoT-sensoren.</p>
-            </div>
-            <div class="service-card">
-                <h3>Industriële Automatisering</h3>
-                <p>IoT-oplossingen voor productieprocessen, kwaliteitscontrole en voorspellend onderhoud in de industrie.</p>
-            </div>
-            <div class="service-card">
-                <h3>AI Chatbots</h3>
-                <p>Intelligente chatbots voor klantenservice die 24/7 beschikbaar zijn en veel voorkomende vragen automatisch beantwoorden.</p>
-            </div>
-            <div class="service-card">
-                <h3>Data Analytics</h3>
-                <p>AI-gedreven data-analyse om patronen te herkennen, trends te voorspellen en betere bedrijfsbeslissingen te nemen.</p>
-            </div>
-        </div>
-    </div>
-
-    <!-- All-round Computerhulp -->
-    <div class="content-section" id="computerhulp">
-        <h2><i class="fas fa-tools" style="color: var(--primary-brown); margin-right: 1rem;"></i>All-round Computerhulp</h2>
-        <p>Van hardware reparaties tot software installaties, wij bieden uitgebreide computerondersteuning voor particulieren en bedrijven. Geen probleem is te klein of te groot.</p>
-        
-        <div class="services-grid" style="margin-top: 2rem;">
-            <div class="service-card">
-                <h3>Hardware Reparatie</h3>
-                <p>Reparatie van computers, laptops, printers en andere hardware met snelle diagnose en eerlijke prijzen.</p>
-            </div>
-            <div class="service-card">
-                <h3>Software Installatie</h3>
-                <p>Installatie en configuratie van besturingssystemen, applicaties en drivers voor optimale prestaties.</p>
-            </div>
-            <div class="service-card">
-                <h3>Data Recovery</h3>
-                <p>Herstel van verloren data van harde schijven, USB-sticks en andere opslagmedia met geavanceerde technieken.</p>
-            </div>
-            <div class="service-card">
-                <h3>IT Training</h3>
-                <p>Persoonlijke training en workshops om uw digitale vaardigheden te verbeteren en efficiënter te werken.</p>
-            </div>
-        </div>
-    </div>
-
-    <!-- Call to Action -->
-    <div class="highlight-box">
-        <h3>Heeft u vragen over onze diensten?</h3>
-        <p>Neem contact met ons op voor een vrijblijvende consultatie. Wij denken graag met u mee over de beste ICT-oplossing voor uw situatie.</p>
-        <a href="/contact" class="cta-button" style="margin-top: 1rem; display: inline-block;">Contact Opnemen</a>
-    </div>
-</div>`
-
-	data.Content = template.HTML(dienstenContent)
-	c.HTML(http.StatusOK, "base.html", data)
-}
-
-func overOnsHandler(c *gin.Context) {
-	data := PageData{
-		Title:       "Over ICT Eerbeek",
-		Description: "Leer meer over ICT Eerbeek, ons team, onze missie en onze passie voor technologie. Uw betrouwbare ICT-partner in Eerbeek.",
-		Page:        "over-ons",
-	}
-
-	overOnsContent := `<!-- Page Header -->
-<section class="page-header">
-    <div class="hero-container">
-        <h1>Over ICT Eerbeek</h1>
-        <p>Leer meer over ons team, onze missie en onze passie voor technologie</p>
-    </div>
-</section>
-
-<!-- Page Content -->
-<div class="page-content">
-    <!-- Company Story -->
-    <div class="content-section">
-        <h2>Ons Verhaal</h2>
-        <p>ICT Eerbeek is ontstaan uit de passie voor technologie en de wens om bedrijven en particulieren te helpen bij hun digitale uitdagingen. Sinds onze oprichting hebben wij ons ontwikkeld tot een betrouwbare partner voor alle ICT-gerelateerde vraagstukken in Eerbeek en omgeving.</p>
-        
-        <p>Wat begon als een kleine onderneming is uitgegroeid tot een professioneel ICT-bedrijf dat zich onderscheidt door persoonlijke service, technische expertise en innovatieve oplossingen. Wij geloven dat technologie toegankelijk moet zijn voor iedereen, ongeacht de grootte van uw bedrijf of uw technische achtergrond.</p>
-    </div>
-
-    <!-- Mission & Vision -->
-    <div style="display: grid; grid-template-columns: 1fr 1fr; gap: 3rem; margin: 3rem 0;">
-        <div class="service-card">
-            <div class="service-icon">
-                <i class="fas fa-bullseye"></i>
-            </div>
-            <h3>Onze Missie</h3>
-            <p>Wij maken technologie toegankelijk en begrijpelijk voor iedereen. Door persoonlijke service en maatwerkoplossingen helpen wij onze klanten hun digitale doelen te bereiken en hun bedrijfsprocessen te optimaliseren.</p>
-        </div>
-        <div class="service-card">
-            <div class="service-icon">
-                <i class="fas fa-eye"></i>
-            </div>
-            <h3>Onze Visie</h3>
-            <p>Wij streven ernaar de meest vertrouwde en innovatieve ICT-partner te zijn, die duurzame oplossingen levert die bijdragen aan het succes van onze klanten in een steeds digitalere wereld.</p>
-        </div>
-    </div>
-
-    <!-- Team Section -->
-    <div class="content-section">
-        <h2>Ons Team</h2>
-        <p>Ons team bestaat uit gepassioneerde en gecertificeerde ICT-professionals met jarenlange ervaring in diverse vakgebieden. Wij werken nauw samen om de beste oplossingen te leveren en staan altijd klaar om u te ondersteunen.</p>
-        
-        <div class="team-grid">
-            <div class="team-member">
-                <img src="https://via.placeholder.com/100" alt="Teamlid 1">
-                <h4>Jan de Vries</h4>
-                <p>Oprichter & Lead Netwerk Engineer</p>
-            </div>
-            <div class="team-member">
-                <img src="https://via.placeholder.com/100" alt="Teamlid 2">
-                <h4>Sophie Jansen</h4>
-                <p>Webdesigner & UI/UX Specialist</p>
-            </div>
-            <div class="team-member">
-                <img src="https://via.placeholder.com/100" alt="Teamlid 3">
-                <h4>Mark van Dijk</h4>
-                <p>IoT & AI Ontwikkelaar</p>
-            </div>
-            <div class="team-member">
-                <img src="https://via.placeholder.com/100" alt="Teamlid 4">
-                <h4>Linda Bakker</h4>
-                <p>All-round IT Support Specialist</p>
-            </div>
-        </div>
-    </div>
-
-    <!-- Values Section -->
-    <div class="content-section">
-        <h2>Onze Waarden</h2>
-        <div style="display: grid; grid-template-columns: 1fr 1fr 1fr; gap: 2rem; margin-top: 2rem;">
-            <div class="service-card">
-                <h3>Klantgerichtheid</h3>
-                <p>De klant staat centraal in alles wat we doen. Wij luisteren naar uw behoeften en leveren oplossingen die echt waarde toevoegen.</p>
-            </div>
-            <div class="service-card">
-                <h3>Innovatie</h3>
-                <p>Wij blijven op de hoogte van de nieuwste technologische ontwikkelingen en passen deze toe om u de meest geavanceerde oplossingen te bieden.</p>
-            </div>
-            <div class="service-card">
-                <h3>Betrouwbaarheid</h3>
-                <p>U kunt op ons rekenen. Wij leveren wat we beloven en zorgen voor stabiele en veilige ICT-omgevingen.</p>
-            </div>
-        </div>
-    </div>
-
-    <!-- Call to Action -->
-    <div class="highlight-box">
-        <h3>Benieuwd wat wij voor u kunnen betekenen?</h3>
-        <p>Neem contact op voor een vrijblijvend gesprek. Wij helpen u graag verder!</p>
-        <a href="/contact" class="cta-button" style="margin-top: 1rem; display: inline-block;">Contact Opnemen</a>
-    </div>
-</div>`
-
-	data.Content = template.HTML(overOnsContent)
-	c.HTML(http.StatusOK, "base.html", data)
-}
-
-func contactGetHandler(c *gin.Context) {
-	data := PageData{
-		Title:       "Contact",
-		Description: "Neem contact op met ICT Eerbeek voor al uw vragen over netwerk & security, website ontwerp, IoT & AI oplossingen, en computerhulp.",
-		Page:        "contact",
-	}
-	c.HTML(http.StatusOK, "contact.html", data)
-}
-
+// contactPostHandler accepts the contact form as JSON, or as a multipart
+// form when files are attached.
 func contactPostHandler(c *gin.Context) {
 	var contact Contact
-	if err := c.ShouldBindJSON(&contact); err != nil {
+	if err := c.ShouldBind(&contact); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !contact.Privacy {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errNoConsent.Error()})
+		return
+	}
+	if err := checkPolicyVersion(contact.PrivacyVersie); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	uploads, err := formUploads(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if contact.Attachments, err = storeUploads(c, uploads); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
-	contact.CreatedAt = time.Now()
-
-	if result := db.Create(&contact); result.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+	contact.CreatedAt = time.Now().UTC()
+	contact.ReactieVoor = responseDeadline(contact.Urgentie, contact.CreatedAt).UTC()
+
+	// Support requests become tickets the customer can follow
+	var ticket Ticket
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&contact).Error; err != nil {
+			return err
+		}
+		if err := recordConsent(tx, c, consentContact, contact.PrivacyVersie, contact.Email, &contact.ID); err != nil {
+			return err
+		}
+		if contact.Nieuwsbrief {
+			if err := subscribe(tx, contact.Email, contact.Naam, sourceContactForm, &contact.ID); err != nil {
+				return err
+			}
+			if err := recordConsent(tx, c, consentNewsletter, contact.PrivacyVersie, contact.Email, &contact.ID); err != nil {
+				return err
+			}
+		}
+		if contact.Onderwerp != ticketSubject {
+			return nil
+		}
+		var err error
+		ticket, err = openTicket(tx, contact)
+		return err
+	})
+	if err != nil {
+		discardAttachments(contact.Attachments)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	trackHit(c, hitConversion)
 
+	if ticket.ID != 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"message": fmt.Sprintf("Bericht succesvol verzonden! Uw ticketnummer is %s; u ontvangt een e-mail met een link om uw ticket te volgen.", ticket.Number),
+			"ticket":  ticket.Number,
+		})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "Bericht succesvol verzonden!"})
 }
 
-func privacybeleidHandler(c *gin.Context) {
-	data := PageData{
-		Title:       "Privacybeleid",
-		Description: "Lees het privacybeleid van ICT Eerbeek. Wij respecteren uw privacy en zorgen voor een veilige verwerking van uw persoonsgegevens.",
-		Page:        "privacybeleid",
+// chatContext tells the model who it is answering for. gemini-pro has no
+// system instructions, so it is sent as the first part of every request.
+func chatContext() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Je bent de assistent op de website van %s. %s\n", company.Name, company.Description)
+	fmt.Fprintf(&b, "Contactgegevens: e-mail %s, telefoon %s, gevestigd in %s.\n",
+		company.Email, company.PhoneDisplay, company.Address.Locality)
+	fmt.Fprintf(&b, "Openingstijden: %s.\n", strings.Join(company.OpeningHoursSummary(), "; "))
+	now := time.Now().In(openingHoursLocation)
+	fmt.Fprintf(&b, "Het is nu %s %s. %s.\n", dutchDate(now), now.Format("15:04"), company.StatusAt(now).Summary)
+	for _, c := range company.UpcomingClosures(now, 30) {
+		fmt.Fprintf(&b, "Gesloten: %s.\n", c.Label())
 	}
-	c.HTML(http.StatusOK, "privacybeleid.html", data)
+	b.WriteString("Afspraken kunnen online worden gemaakt via /afspraak; verwijs voor offertes en overige vragen naar de contactpagina. Bestaande klanten met een technisch probleem kiezen daar het onderwerp Technische Ondersteuning; ze krijgen dan een ticketnummer en een link om hun ticket te volgen. Verzin geen andere contactgegevens.")
+	return b.String()
 }
-
-
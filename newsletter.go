@@ -0,0 +1,321 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Subscriber is a newsletter subscription. Nobody receives the newsletter
+// before confirming their address through the link in the confirmation
+// e-mail (double opt-in); the timestamps record when consent was asked,
+// given and withdrawn.
+type Subscriber struct {
+	ID                 uint   `gorm:"primaryKey"`
+	Email              string `gorm:"not null;uniqueIndex"` // lower case
+	Naam               string
+	Status             string `gorm:"not null;index"`
+	Source             string `gorm:"not null"` // where the subscription came from
+	ContactID          *uint  // the contact request it came with, if any
+	RequestedAt        time.Time
+	ConfirmationSentAt *time.Time
+	ConfirmedAt        *time.Time
+	UnsubscribedAt     *time.Time
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+}
+
+// Subscriber statuses.
+const (
+	subscriberPending      = "wachtend"
+	subscriberActive       = "actief"
+	subscriberUnsubscribed = "afgemeld"
+	// subscriberBounced is an address that cannot receive mail, as reported
+	// by the mail provider.
+	subscriberBounced = "onbestelbaar"
+)
+
+// Subscription sources.
+const (
+	sourceContactForm = "contactformulier"
+	sourceSignupForm  = "aanmeldformulier"
+)
+
+const (
+	tokenNewsletterConfirm     = "nieuwsbrief-bevestigen"
+	tokenNewsletterUnsubscribe = "nieuwsbrief-afmelden"
+	newsletterConfirmTTL       = 7 * 24 * time.Hour
+	// newsletterResendAfter keeps repeated sign-ups from flooding an inbox
+	// with confirmation e-mails.
+	newsletterResendAfter = 10 * time.Minute
+)
+
+// subscribe records a request to receive the newsletter and queues the
+// confirmation e-mail. Active subscribers are left alone.
+func subscribe(tx *gorm.DB, email, naam, source string, contactID *uint) error {
+	email = normalizeEmail(email)
+	now := time.Now().UTC()
+	var s Subscriber
+	err := tx.Where("email = ?", email).First(&s).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		s = Subscriber{Email: email, Naam: naam, Source: source, ContactID: contactID}
+	case err != nil:
+		return err
+	case s.Status == subscriberActive:
+		return nil
+	case s.Status == subscriberPending && s.ConfirmationSentAt != nil && now.Sub(*s.ConfirmationSentAt) < newsletterResendAfter:
+		return nil
+	}
+	if s.Status != subscriberPending {
+		// a new request after unsubscribing starts over
+		s.Source, s.ContactID, s.ConfirmedAt, s.UnsubscribedAt = source, contactID, nil, nil
+	}
+	if naam != "" {
+		s.Naam = naam
+	}
+	s.Status, s.RequestedAt, s.ConfirmationSentAt = subscriberPending, now, &now
+	if err := tx.Save(&s).Error; err != nil {
+		return err
+	}
+	return queueMail(tx, newsletterConfirmMail(s))
+}
+
+// subscribed reports whether email is on the list and receives campaigns.
+func subscribed(email string) bool {
+	var n int64
+	db.Model(&Subscriber{}).Where("email = ? AND status = ?", normalizeEmail(email), subscriberActive).Count(&n)
+	return n > 0
+}
+
+// unsubscribeLink returns the link that ends a subscription. It does not
+// expire, so it keeps working in old newsletters.
+func unsubscribeLink(s Subscriber) string {
+	return site.URL("/nieuwsbrief/afmelden?token=" + signID(tokenNewsletterUnsubscribe, s.ID, 0))
+}
+
+func newsletterConfirmMail(s Subscriber) *OutboxMessage {
+	greeting := "Beste lezer"
+	if s.Naam != "" {
+		greeting = "Beste " + s.Naam
+	}
+	return &OutboxMessage{
+		To:      s.Email,
+		Subject: "Bevestig uw aanmelding voor de nieuwsbrief van " + company.Name,
+		Text: fmt.Sprintf(`%s,
+
+U heeft zich aangemeld voor de nieuwsbrief van %s. Bevestig uw aanmelding via deze link:
+%s
+
+De link is %d dagen geldig. Heeft u zich niet aangemeld? Dan kunt u deze e-mail negeren; u ontvangt dan niets van ons.
+
+Met vriendelijke groet,
+%s
+`, greeting, company.Name, site.URL("/nieuwsbrief/bevestigen?token="+signID(tokenNewsletterConfirm, s.ID, newsletterConfirmTTL)),
+			int(newsletterConfirmTTL.Hours()/24), company.Name),
+	}
+}
+
+// newsletterPostHandler handles POST /nieuwsbrief, the sign-up form. The
+// answer is the same for new and existing subscribers.
+func newsletterPostHandler(c *gin.Context) {
+	page := findPage("/nieuwsbrief")
+	email := normalizeEmail(c.PostForm("email"))
+	naam := strings.TrimSpace(c.PostForm("naam"))
+	if !validEmail(email) {
+		c.HTML(http.StatusBadRequest, page.Template, pageData(page, gin.H{"Error": "Vul een geldig e-mailadres in.", "Email": email, "Naam": naam}))
+		return
+	}
+	if c.PostForm("toestemming") == "" {
+		c.HTML(http.StatusBadRequest, page.Template, pageData(page, gin.H{"Error": "Geef aan dat u de nieuwsbrief wilt ontvangen.", "Email": email, "Naam": naam}))
+		return
+	}
+	if err := checkPolicyVersion(c.PostForm("privacy_versie")); err != nil {
+		c.HTML(http.StatusBadRequest, page.Template, pageData(page, gin.H{"Error": "Ons privacybeleid is gewijzigd. Lees de nieuwe versie en meld u opnieuw aan.", "Email": email, "Naam": naam}))
+		return
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := subscribe(tx, email, naam, sourceSignupForm, nil); err != nil {
+			return err
+		}
+		return recordConsent(tx, c, consentNewsletter, c.PostForm("privacy_versie"), email, nil)
+	})
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.HTML(http.StatusOK, page.Template, pageData(page, gin.H{"Sent": email}))
+}
+
+// newsletterActionHandler handles GET and POST /nieuwsbrief/bevestigen and
+// /nieuwsbrief/afmelden. Opening the link shows a button; posting it acts,
+// so mail scanners that follow links change nothing. Unsubscribing also
+// accepts the one-click POST of RFC 8058 from mail clients.
+func newsletterActionHandler(purpose string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.Query("token")
+		if token == "" {
+			token = c.PostForm("token")
+		}
+		render := func(status int, data gin.H) {
+			data["Action"] = purpose
+			c.HTML(status, "nieuwsbrief-status", privatePage(c.Request.URL.Path, "Nieuwsbrief", "nieuwsbrief-status", data))
+		}
+		id, err := verifyID(purpose, token)
+		if err != nil {
+			render(http.StatusForbidden, gin.H{"Error": err.Error() + ". Meld u opnieuw aan via de nieuwsbriefpagina."})
+			return
+		}
+		var s Subscriber
+		if err := db.First(&s, id).Error; err != nil {
+			render(http.StatusNotFound, gin.H{"Error": "Deze aanmelding bestaat niet meer."})
+			return
+		}
+		if c.Request.Method == http.MethodGet {
+			render(http.StatusOK, gin.H{"Subscriber": s, "Token": token})
+			return
+		}
+
+		now := time.Now().UTC()
+		var notice string
+		var event *MailEvent
+		switch purpose {
+		case tokenNewsletterConfirm:
+			if s.Status == subscriberUnsubscribed {
+				render(http.StatusConflict, gin.H{"Error": "U heeft zich inmiddels afgemeld. Meld u opnieuw aan als u de nieuwsbrief toch wilt ontvangen."})
+				return
+			}
+			if s.Status == subscriberPending {
+				s.Status, s.ConfirmedAt = subscriberActive, &now
+			}
+			notice = "Bedankt! Uw aanmelding is bevestigd. U ontvangt voortaan onze nieuwsbrief op " + s.Email + "."
+		case tokenNewsletterUnsubscribe:
+			if s.Status != subscriberUnsubscribed {
+				s.Status, s.UnsubscribedAt = subscriberUnsubscribed, &now
+				// links in campaigns name the campaign, for its statistics
+				if id, err := strconv.ParseUint(c.Query("campagne"), 10, 64); err == nil {
+					campaignID := uint(id)
+					event = &MailEvent{Type: mailEventUnsubscribe, Email: s.Email, CampaignID: &campaignID}
+				}
+			}
+			notice = "U bent afgemeld. " + s.Email + " ontvangt geen nieuwsbrieven meer van ons."
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&s).Select("status", "confirmed_at", "unsubscribed_at").Updates(&s).Error; err != nil {
+				return err
+			}
+			if event != nil {
+				return tx.Create(event).Error
+			}
+			return nil
+		})
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		render(http.StatusOK, gin.H{"Subscriber": s, "Notice": notice})
+	}
+}
+
+// migrateNewsletterContacts turns contacts who ticked the newsletter box,
+// before subscriptions existed, into pending subscribers. They receive a
+// confirmation e-mail when it is sent from the admin. It runs once, see
+// migrateOnce: run again, it would bring back the addresses that have
+// unsubscribed or were removed by retention since.
+func migrateNewsletterContacts(tx *gorm.DB) error {
+	// Databases that ran this before it was recorded already have
+	// subscribers, as do those that never had contacts to migrate.
+	var n int64
+	if err := tx.Model(&Subscriber{}).Count(&n).Error; err != nil || n > 0 {
+		return err
+	}
+	var contacts []Contact
+	if err := tx.Where("nieuwsbrief").Order("created_at").Find(&contacts).Error; err != nil || len(contacts) == 0 {
+		return err
+	}
+	seen := make(map[string]bool)
+	var subscribers []Subscriber
+	for _, contact := range contacts {
+		email := normalizeEmail(contact.Email)
+		if seen[email] || !validEmail(email) {
+			continue
+		}
+		seen[email] = true
+		contactID := contact.ID
+		subscribers = append(subscribers, Subscriber{
+			Email:       email,
+			Naam:        contact.Naam,
+			Status:      subscriberPending,
+			Source:      sourceContactForm,
+			ContactID:   &contactID,
+			RequestedAt: contact.CreatedAt.UTC(),
+		})
+	}
+	if len(subscribers) == 0 {
+		return nil
+	}
+	if err := tx.Create(&subscribers).Error; err != nil {
+		return err
+	}
+	log.Printf("newsletter: %d contacts added as pending subscribers", len(subscribers))
+	return nil
+}
+
+// adminSubscribersHandler handles GET /admin/nieuwsbrief.
+func adminSubscribersHandler(c *gin.Context) {
+	var subscribers []Subscriber
+	if err := db.Order("created_at DESC").Find(&subscribers).Error; err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	counts := make(map[string]int)
+	unconfirmed := 0
+	for _, s := range subscribers {
+		counts[s.Status]++
+		if s.Status == subscriberPending && s.ConfirmationSentAt == nil {
+			unconfirmed++
+		}
+	}
+	renderAdmin(c, http.StatusOK, "nieuwsbrief", "nieuwsbrief", "Nieuwsbrief", gin.H{
+		"Subscribers": subscribers,
+		"Counts":      counts,
+		"Unconfirmed": unconfirmed,
+	})
+}
+
+// adminSendConfirmationsHandler handles POST /admin/nieuwsbrief/bevestigingen:
+// it mails the confirmation link to pending subscribers who never got one,
+// such as those migrated from the contact form.
+func adminSendConfirmationsHandler(c *gin.Context) {
+	var sent int
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var subscribers []Subscriber
+		err := tx.Where("status = ? AND confirmation_sent_at IS NULL", subscriberPending).Find(&subscribers).Error
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		for _, s := range subscribers {
+			if err := tx.Model(&s).Update("confirmation_sent_at", now).Error; err != nil {
+				return err
+			}
+			if err := queueMail(tx, newsletterConfirmMail(s)); err != nil {
+				return err
+			}
+		}
+		sent = len(subscribers)
+		return recordAudit(tx, c, "nieuwsbrief.bevestigingen", "nieuwsbrief", "", auditChanges{"Bevestigingsmails": {nil, sent}})
+	})
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	redirectAdmin(c, "/admin/nieuwsbrief", fmt.Sprintf("%d bevestigingsmails in de wachtrij gezet.", sent))
+}
@@ -0,0 +1,67 @@
+package main
+
+import "testing"
+
+func TestMigrateNewsletterContactsOnce(t *testing.T) {
+	testDB(t, &Contact{}, &Attachment{}, &Subscriber{}, &Migration{})
+	contacts := []Contact{
+		{Naam: "Jan", Email: "jan@example.nl", Onderwerp: "vraag", Bericht: "…", Nieuwsbrief: true},
+		{Naam: "Jan", Email: "Jan@example.nl", Onderwerp: "vraag", Bericht: "…", Nieuwsbrief: true},
+		{Naam: "Piet", Email: "piet@example.nl", Onderwerp: "vraag", Bericht: "…"},
+	}
+	if err := db.Create(&contacts).Error; err != nil {
+		t.Fatal(err)
+	}
+	count := func() int64 {
+		var n int64
+		if err := db.Model(&Subscriber{}).Count(&n).Error; err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	if err := migrateOnce("nieuwsbrief-contacten", migrateNewsletterContacts); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(); n != 1 {
+		t.Fatalf("%d subscribers after the migration, want 1", n)
+	}
+
+	// removed by retention, or unsubscribed and removed: it stays gone
+	if err := db.Where("1 = 1").Delete(&Subscriber{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateOnce("nieuwsbrief-contacten", migrateNewsletterContacts); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(); n != 0 {
+		t.Errorf("%d subscribers after running the migration again, want 0", n)
+	}
+}
+
+func TestMigrateNewsletterContactsAppliedBefore(t *testing.T) {
+	testDB(t, &Contact{}, &Attachment{}, &Subscriber{}, &Migration{})
+	contact := Contact{Naam: "Jan", Email: "jan@example.nl", Onderwerp: "vraag", Bericht: "…", Nieuwsbrief: true}
+	if err := db.Create(&contact).Error; err != nil {
+		t.Fatal(err)
+	}
+	// migrated before migrations were recorded; Jan has unsubscribed since
+	// and retention removed him, while someone else subscribed
+	other := Subscriber{Email: "piet@example.nl", Status: subscriberActive, Source: sourceContactForm}
+	if err := db.Create(&other).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateOnce("nieuwsbrief-contacten", migrateNewsletterContacts); err != nil {
+		t.Fatal(err)
+	}
+	var n int64
+	if err := db.Model(&Subscriber{}).Where("email = ?", "jan@example.nl").Count(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Error("address added again to a database with subscribers")
+	}
+	if err := db.Model(&Migration{}).Where("name = ?", "nieuwsbrief-contacten").Count(&n).Error; err != nil || n != 1 {
+		t.Errorf("migration not recorded: %d, %v", n, err)
+	}
+}
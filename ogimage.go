@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/fs"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Open Graph images use the 1.91:1 size recommended by Facebook and X.
+const (
+	ogWidth  = 1200
+	ogHeight = 630
+	ogMargin = 80
+)
+
+var (
+	ogGreen = color.RGBA{0x7C, 0xB3, 0x42, 0xFF} // --primary-green
+	ogBlue  = color.RGBA{0x21, 0x96, 0xF3, 0xFF} // --primary-blue
+)
+
+// ogImagePath is the URL path of the generated Open Graph image of a page.
+func ogImagePath(p sitePage) string {
+	return "/og/" + p.Template + ".png"
+}
+
+// ogImages renders a share image per registered page: the logo and the page
+// title on the header gradient. Images only depend on the page registry and
+// the logo, so each is rendered once and kept in memory.
+type ogImages struct {
+	logo  image.Image
+	title font.Face
+	sub   font.Face
+
+	mu    sync.Mutex
+	cache map[string][]byte
+}
+
+func newOGImages(staticFS fs.FS, logo string) (*ogImages, error) {
+	f, err := staticFS.Open(logo)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("og image: decode logo: %w", err)
+	}
+
+	bold, err := opentype.Parse(gobold.TTF)
+	if err != nil {
+		return nil, err
+	}
+	regular, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		return nil, err
+	}
+	title, err := opentype.NewFace(bold, &opentype.FaceOptions{Size: 64, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, err
+	}
+	sub, err := opentype.NewFace(regular, &opentype.FaceOptions{Size: 30, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, err
+	}
+	return &ogImages{logo: img, title: title, sub: sub, cache: make(map[string][]byte)}, nil
+}
+
+// serve handles GET /og/:page, e.g. /og/contact.png.
+func (o *ogImages) serve(c *gin.Context) {
+	name := strings.TrimSuffix(c.Param("page"), ".png")
+	var page *sitePage
+	for i := range sitePages {
+		if sitePages[i].Template == name {
+			page = &sitePages[i]
+		}
+	}
+	if page == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	b, err := o.render(*page)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=86400")
+	c.Data(http.StatusOK, "image/png", b)
+}
+
+func (o *ogImages) render(p sitePage) ([]byte, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if b, ok := o.cache[p.Template]; ok {
+		return b, nil
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, ogWidth, ogHeight))
+	for x := 0; x < ogWidth; x++ {
+		for y := 0; y < ogHeight; y++ {
+			// 135 degree gradient, as in the site header.
+			t := float64(x+y) / float64(ogWidth+ogHeight)
+			img.Set(x, y, mix(ogGreen, ogBlue, t))
+		}
+	}
+
+	// Logo on a white rounded tile in the top left corner.
+	const logoSize = 160
+	tile := image.Rect(ogMargin, ogMargin, ogMargin+logoSize, ogMargin+logoSize)
+	draw.Draw(img, tile, image.White, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(img, tile.Inset(12), o.logo, o.logo.Bounds(), draw.Over, nil)
+
+	d := font.Drawer{Dst: img, Src: image.White}
+	d.Face = o.sub
+	d.Dot = fixed.P(ogMargin+logoSize+32, ogMargin+logoSize/2+10)
+	d.DrawString(company.Name)
+
+	d.Face = o.title
+	lines := wrapText(d.Face, p.Title, ogWidth-2*ogMargin)
+	y := ogHeight - ogMargin - 40 - (len(lines)-1)*76
+	for _, line := range lines {
+		d.Dot = fixed.P(ogMargin, y)
+		d.DrawString(line)
+		y += 76
+	}
+
+	d.Face = o.sub
+	d.Dot = fixed.P(ogMargin, ogHeight-ogMargin+10)
+	d.DrawString(strings.TrimPrefix(strings.TrimPrefix(site.BaseURL, "https://"), "http://"))
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	o.cache[p.Template] = buf.Bytes()
+	return buf.Bytes(), nil
+}
+
+func mix(a, b color.RGBA, t float64) color.RGBA {
+	lerp := func(x, y uint8) uint8 { return uint8(float64(x) + (float64(y)-float64(x))*t) }
+	return color.RGBA{lerp(a.R, b.R), lerp(a.G, b.G), lerp(a.B, b.B), 0xFF}
+}
+
+// wrapText breaks s into lines no wider than width pixels.
+func wrapText(face font.Face, s string, width int) []string {
+	var lines []string
+	var line string
+	for _, word := range strings.Fields(s) {
+		candidate := strings.TrimSpace(line + " " + word)
+		if line != "" && font.MeasureString(face, candidate).Ceil() > width {
+			lines = append(lines, line)
+			candidate = word
+		}
+		line = candidate
+	}
+	return append(lines, line)
+}
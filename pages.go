@@ -0,0 +1,187 @@
+package main
+
+import (
+	"io/fs"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pageKind classifies pages for the sitemap.
+type pageKind int
+
+const (
+	kindHome pageKind = iota
+	kindService
+	kindContent
+	kindLegal
+)
+
+// sitemapPriority is the sitemap priority of each kind of page.
+var sitemapPriority = map[pageKind]float64{
+	kindHome:    1.0,
+	kindService: 0.8,
+	kindContent: 0.6,
+	kindLegal:   0.3,
+}
+
+// sitePage is an entry in the page registry. Routes, the sitemap and
+// robots.txt are all derived from sitePages, so a page added here is served
+// and listed without further wiring.
+type sitePage struct {
+	Path        string
+	Template    string // page template name, e.g. "contact"
+	Kind        pageKind
+	ChangeFreq  string // sitemap changefreq
+	Title       string
+	Description string
+	FAQs        []FAQ
+	SEO         SEO // overrides of the defaults from pageSEO
+}
+
+// FAQ is a frequently asked question shown on a page and published as
+// FAQPage structured data.
+type FAQ struct {
+	Question string
+	Answer   string
+}
+
+var contactFAQs = []FAQ{
+	{
+		Question: "Hoe snel krijg ik antwoord?",
+		Answer:   "Wij streven ernaar om binnen 4 uur te reageren op werkdagen. Voor urgente zaken kunt u ons spoednummer bellen.",
+	},
+	{
+		Question: "Zijn er kosten verbonden aan een consult?",
+		Answer:   "Het eerste consult en de offerte zijn altijd gratis. Pas na goedkeuring van de offerte brengen wij kosten in rekening.",
+	},
+	{
+		Question: "Werken jullie ook in het weekend?",
+		Answer:   "Voor spoedgevallen zijn wij 24/7 bereikbaar. Reguliere werkzaamheden plannen wij in overleg, ook in het weekend indien gewenst.",
+	},
+	{
+		Question: "Bieden jullie onderhoud contracten?",
+		Answer:   "Ja, wij bieden verschillende onderhoudscontracten aan voor zowel particulieren als bedrijven. Neem contact op voor meer informatie.",
+	},
+}
+
+var sitePages = []sitePage{
+	{
+		Path:        "/",
+		Template:    "home",
+		Kind:        kindHome,
+		ChangeFreq:  "weekly",
+		Title:       "Home",
+		Description: "ICT Eerbeek - Uw betrouwbare partner voor alle ICT-oplossingen in Eerbeek en omgeving. Netwerk & security, website ontwerp, IoT & AI oplossingen, en computerhulp.",
+	},
+	{
+		Path:        "/diensten",
+		Template:    "diensten",
+		Kind:        kindService,
+		ChangeFreq:  "monthly",
+		Title:       "Onze Diensten",
+		Description: "Ontdek ons uitgebreide aanbod van ICT-oplossingen: netwerk & security, website & logo ontwerp, IoT & AI oplossingen, en all-round computerhulp.",
+	},
+	{
+		Path:        "/over-ons",
+		Template:    "over-ons",
+		Kind:        kindContent,
+		ChangeFreq:  "monthly",
+		Title:       "Over ICT Eerbeek",
+		Description: "Leer meer over ICT Eerbeek, ons team, onze missie en onze passie voor technologie. Uw betrouwbare ICT-partner in Eerbeek.",
+	},
+	{
+		Path:        "/contact",
+		Template:    "contact",
+		Kind:        kindContent,
+		ChangeFreq:  "monthly",
+		Title:       "Contact",
+		Description: "Neem contact op met ICT Eerbeek voor al uw vragen over netwerk & security, website ontwerp, IoT & AI oplossingen, en computerhulp.",
+		FAQs:        contactFAQs,
+	},
+	{
+		Path:        "/afspraak",
+		Template:    "afspraak",
+		Kind:        kindContent,
+		ChangeFreq:  "monthly",
+		Title:       "Afspraak maken",
+		Description: "Plan online een afspraak met ICT Eerbeek: computerhulp aan huis, een netwerk- en beveiligingscheck of een kennismakingsgesprek.",
+	},
+	{
+		Path:        "/nieuwsbrief",
+		Template:    "nieuwsbrief",
+		Kind:        kindContent,
+		ChangeFreq:  "yearly",
+		Title:       "Nieuwsbrief",
+		Description: "Meld u aan voor de nieuwsbrief van ICT Eerbeek met tips over computers, netwerken en beveiliging en nieuws over onze diensten.",
+	},
+	{
+		Path:        "/privacybeleid",
+		Template:    "privacybeleid",
+		Kind:        kindLegal,
+		ChangeFreq:  "yearly",
+		Title:       "Privacybeleid",
+		Description: "Lees het privacybeleid van ICT Eerbeek. Wij respecteren uw privacy en zorgen voor een veilige verwerking van uw persoonsgegevens.",
+	},
+}
+
+// pageHandler renders a registered page.
+func pageHandler(p sitePage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.HTML(http.StatusOK, p.Template, pageData(p, nil))
+	}
+}
+
+// pageData returns the template data for page p, with data for the page
+// itself, e.g. the outcome of a form posted to it.
+func pageData(p sitePage, data any) PageData {
+	return PageData{
+		Title:       p.Title,
+		Description: p.Description,
+		Page:        p.Template,
+		Path:        p.Path,
+		Kind:        p.Kind,
+		FAQs:        p.FAQs,
+		SEO:         pageSEO(p),
+		Data:        data,
+	}
+}
+
+// findPage returns the registered page at path.
+func findPage(path string) sitePage {
+	for _, p := range sitePages {
+		if p.Path == path {
+			return p
+		}
+	}
+	panic("no page registered at " + path)
+}
+
+// privatePage returns the data for a page outside the registry, such as one
+// reached through a personal link in an e-mail. These are never indexed.
+func privatePage(path, title, template string, data any) PageData {
+	return pageData(sitePage{
+		Path:     path,
+		Template: template,
+		Kind:     kindContent,
+		Title:    title,
+		SEO:      SEO{Robots: "noindex, nofollow", OGImage: site.URL(ogImagePath(sitePages[0]))},
+	}, data)
+}
+
+// contentModTime returns when the template behind a page last changed.
+// Embedded files carry no modification time, so the build time of the
+// binary stands in for them.
+func contentModTime(fsys fs.FS, p sitePage) time.Time {
+	if info, err := fs.Stat(fsys, p.Template+".html"); err == nil && !info.ModTime().IsZero() {
+		return info.ModTime()
+	}
+	if exe, err := os.Executable(); err == nil {
+		if info, err := os.Stat(exe); err == nil {
+			return info.ModTime()
+		}
+	}
+	return time.Time{}
+}
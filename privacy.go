@@ -0,0 +1,725 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// DataRequest records a request under the GDPR to see or erase the data
+// held about someone. It keeps a keyed hash of the address instead of the
+// address itself, so the record outlives an erasure without undoing it.
+type DataRequest struct {
+	ID        uint   `gorm:"primaryKey"`
+	Kind      string `gorm:"not null"` // inzage or verwijdering
+	Channel   string `gorm:"not null"` // beheer or zelfservice
+	EmailHash string `gorm:"not null;index"`
+	Summary   string // what was exported or erased
+	IP        string
+	CreatedAt time.Time
+}
+
+// Data request kinds and channels.
+const (
+	dataRequestExport  = "inzage"
+	dataRequestErasure = "verwijdering"
+
+	channelAdmin       = "beheer"
+	channelSelfService = "zelfservice"
+)
+
+const (
+	tokenDataExport  = "gegevens-inzage"
+	tokenDataErasure = "gegevens-verwijderen"
+	dataRequestTTL   = time.Hour
+	maxDataMails     = 3 // per address and kind per hour
+)
+
+// dataRequestHash identifies an address in DataRequest records.
+func dataRequestHash(email string) string {
+	h := hmac.New(sha256.New, site.Secret)
+	h.Write([]byte("gegevensverzoek\x00" + normalizeEmail(email)))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// PersonalData is everything held about one e-mail address. Chat messages
+// are not listed: the chat assistant keeps no transcripts.
+type PersonalData struct {
+	Email            string
+	Klantaccount     *Customer
+	Sessies          []Session
+	Contactaanvragen []Contact
+	Afspraken        []Appointment
+	Offertes         []Quote
+	Tickets          []Ticket
+	Nieuwsbrief      *Subscriber
+	Maillogboek      []MailEvent
+	Emails           []OutboxMessage
+	Toestemmingen    []ConsentRecord
+}
+
+// collectPersonalData gathers the data held about email from every table.
+func collectPersonalData(tx *gorm.DB, email string) (PersonalData, error) {
+	email = normalizeEmail(email)
+	d := PersonalData{Email: email}
+	var customer Customer
+	if tx.Where("email = ?", email).Limit(1).Find(&customer).RowsAffected > 0 {
+		d.Klantaccount = &customer
+		if err := tx.Where("kind = ? AND user_id = ?", customerSessions.Name, customer.ID).Find(&d.Sessies).Error; err != nil {
+			return d, err
+		}
+	}
+	var subscriber Subscriber
+	if tx.Where("email = ?", email).Limit(1).Find(&subscriber).RowsAffected > 0 {
+		d.Nieuwsbrief = &subscriber
+	}
+	queries := []struct {
+		dest  any
+		query *gorm.DB
+	}{
+		{&d.Contactaanvragen, byEmail(tx.Preload("Attachments"), email)},
+		{&d.Afspraken, tx.Where("lower(email) = ?", email)},
+		{&d.Offertes, tx.Preload("Lines").Where("lower(email) = ? AND status <> ?", email, quoteDraft)},
+		{&d.Tickets, byEmail(tx.Preload("Messages.Attachments"), email)},
+		{&d.Maillogboek, tx.Where("email = ?", email)},
+		{&d.Emails, tx.Omit("html").Where(`lower("to") = ?`, email)},
+		{&d.Toestemmingen, tx.Where("email = ?", email)},
+	}
+	for _, q := range queries {
+		if err := q.query.Order("created_at").Find(q.dest).Error; err != nil {
+			return d, err
+		}
+	}
+	return d, nil
+}
+
+// Empty reports whether nothing is held about the address.
+func (d PersonalData) Empty() bool {
+	return d.Klantaccount == nil && d.Nieuwsbrief == nil && len(d.Contactaanvragen) == 0 &&
+		len(d.Afspraken) == 0 && len(d.Offertes) == 0 && len(d.Tickets) == 0 &&
+		len(d.Maillogboek) == 0 && len(d.Emails) == 0 && len(d.Toestemmingen) == 0
+}
+
+// Summary lists what is held, one line per kind of record.
+func (d PersonalData) Summary() []string {
+	var lines []string
+	add := func(n int, what string) {
+		if n > 0 {
+			lines = append(lines, fmt.Sprintf("%d %s", n, what))
+		}
+	}
+	if d.Klantaccount != nil {
+		add(1, "klantaccount")
+	}
+	add(len(d.Sessies), "inlogsessies")
+	add(len(d.Contactaanvragen), "contactaanvragen")
+	add(len(d.Afspraken), "afspraken")
+	add(len(d.Offertes), "offertes")
+	add(len(d.Tickets), "tickets")
+	if d.Nieuwsbrief != nil {
+		add(1, "nieuwsbriefaanmelding")
+	}
+	add(len(d.Maillogboek), "meldingen van de mailprovider")
+	add(len(d.Emails), "verstuurde e-mails")
+	add(len(d.Toestemmingen), "vastgelegde toestemmingen")
+	add(len(d.attachments()), "bijlagen")
+	return lines
+}
+
+// attachments returns the files sent with contact requests and tickets.
+// Files of a support request belong to both, but are listed once.
+func (d PersonalData) attachments() []Attachment {
+	var all []Attachment
+	seen := make(map[uint]bool)
+	add := func(list []Attachment) {
+		for _, a := range list {
+			if !seen[a.ID] {
+				seen[a.ID] = true
+				all = append(all, a)
+			}
+		}
+	}
+	for _, c := range d.Contactaanvragen {
+		add(c.Attachments)
+	}
+	for _, t := range d.Tickets {
+		for _, m := range t.Messages {
+			add(m.Attachments)
+		}
+	}
+	return all
+}
+
+// writeExport writes the data as a ZIP archive: everything in one JSON
+// file, every table as a CSV file for spreadsheets, and the uploaded files.
+func writeExport(ctx context.Context, w io.Writer, d PersonalData) error {
+	zw := &exportZip{zip.NewWriter(w), time.Now()}
+	f, err := zw.Create("gegevens.json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(d); err != nil {
+		return err
+	}
+
+	var lines []QuoteLine
+	var messages []TicketMessage
+	for _, q := range d.Offertes {
+		lines = append(lines, q.Lines...)
+	}
+	for _, t := range d.Tickets {
+		messages = append(messages, t.Messages...)
+	}
+	var customers []Customer
+	if d.Klantaccount != nil {
+		customers = append(customers, *d.Klantaccount)
+	}
+	var subscribers []Subscriber
+	if d.Nieuwsbrief != nil {
+		subscribers = append(subscribers, *d.Nieuwsbrief)
+	}
+	attachments := d.attachments()
+	tables := []struct {
+		name string
+		rows any
+	}{
+		{"klantaccount.csv", customers},
+		{"sessies.csv", d.Sessies},
+		{"contactaanvragen.csv", d.Contactaanvragen},
+		{"afspraken.csv", d.Afspraken},
+		{"offertes.csv", d.Offertes},
+		{"offerteregels.csv", lines},
+		{"tickets.csv", d.Tickets},
+		{"ticketberichten.csv", messages},
+		{"bijlagen.csv", attachments},
+		{"nieuwsbrief.csv", subscribers},
+		{"maillogboek.csv", d.Maillogboek},
+		{"emails.csv", d.Emails},
+		{"toestemmingen.csv", d.Toestemmingen},
+	}
+	for _, t := range tables {
+		if err := writeCSV(zw, "csv/"+t.name, t.rows); err != nil {
+			return err
+		}
+	}
+
+	for _, a := range attachments {
+		if err := copyAttachment(ctx, zw, a); err != nil {
+			return err
+		}
+	}
+
+	f, err = zw.Create("LEESMIJ.txt")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(f, `Gegevens van %s bij %s
+Samengesteld op %s.
+
+gegevens.json bevat alle gegevens in één bestand. De map csv bevat dezelfde
+gegevens per soort, te openen met een spreadsheetprogramma. De map bijlagen
+bevat de bestanden die u ons heeft gestuurd of die wij u via een ticket
+stuurden.
+
+Gesprekken met de chatassistent op de website worden niet bewaard en staan
+daarom niet in dit overzicht.
+
+Vragen? Mail naar %s.
+`, d.Email, company.Name, dateTimeNL(time.Now()), company.PrivacyEmail)
+	return zw.Close()
+}
+
+// exportZip dates the files in the archive; zip.Writer.Create leaves
+// them at 1980.
+type exportZip struct {
+	*zip.Writer
+	modified time.Time
+}
+
+func (z *exportZip) Create(name string) (io.Writer, error) {
+	return z.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: z.modified})
+}
+
+func copyAttachment(ctx context.Context, zw *exportZip, a Attachment) error {
+	r, err := store.Open(ctx, a.StorageKey)
+	if err != nil {
+		log.Printf("privacy: attachment %d: %v", a.ID, err)
+		return nil
+	}
+	defer r.Close()
+	f, err := zw.Create(path.Join("bijlagen", strconv.FormatUint(uint64(a.ID), 10)+"-"+safeFilename(a.Filename)))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	return err
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// writeCSV writes a slice of records as a CSV file with a column for every
+// exported field holding a plain value, leaving out internal fields that
+// gegevens.json leaves out too; related records get files of their own.
+func writeCSV(zw *exportZip, name string, rows any) error {
+	v := reflect.ValueOf(rows)
+	if v.Len() == 0 {
+		return nil
+	}
+	t := v.Type().Elem()
+	var header []string
+	var fields []int
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		ft := f.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if !f.IsExported() || f.Tag.Get("json") == "-" || ft.Kind() == reflect.Slice || (ft.Kind() == reflect.Struct && ft != timeType) {
+			continue
+		}
+		header = append(header, f.Name)
+		fields = append(fields, i)
+	}
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write(header)
+	for i := 0; i < v.Len(); i++ {
+		record := make([]string, len(fields))
+		for j, field := range fields {
+			record[j] = csvValue(v.Index(i).Field(field))
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func csvValue(v reflect.Value) string {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	switch x := v.Interface().(type) {
+	case time.Time:
+		if x.IsZero() {
+			return ""
+		}
+		return x.UTC().Format(time.RFC3339)
+	case string:
+		// keep spreadsheets from reading text as a formula
+		if x != "" && strings.ContainsRune("=+-@", rune(x[0])) {
+			return "'" + x
+		}
+		return x
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+// erasePersonalData removes the data in d. Records that only
+// matter for statistics are anonymised; accepted quotes are kept, as the
+// tax rules require keeping them for seven years. It returns what was done,
+// one line per kind of record; stored files are removed after the
+// transaction commits.
+func erasePersonalData(tx *gorm.DB, d PersonalData) ([]string, []Attachment, error) {
+	var done []string
+	report := func(n int, what string) {
+		if n > 0 {
+			done = append(done, fmt.Sprintf("%d %s", n, what))
+		}
+	}
+	email := d.Email
+	var steps []func() error
+	step := func(f func() error) { steps = append(steps, f) }
+
+	var contactIDs, ticketIDs, messageIDs []uint
+	for _, c := range d.Contactaanvragen {
+		contactIDs = append(contactIDs, c.ID)
+	}
+	for _, t := range d.Tickets {
+		ticketIDs = append(ticketIDs, t.ID)
+		for _, m := range t.Messages {
+			messageIDs = append(messageIDs, m.ID)
+		}
+	}
+	attachments := d.attachments()
+
+	if d.Klantaccount != nil {
+		step(func() error {
+			if err := tx.Where("kind = ? AND user_id = ?", customerSessions.Name, d.Klantaccount.ID).Delete(&Session{}).Error; err != nil {
+				return err
+			}
+			return tx.Delete(d.Klantaccount).Error
+		})
+		report(1, "klantaccount verwijderd")
+	}
+	if len(attachments) > 0 {
+		step(func() error {
+			return tx.Where("contact_id IN ? OR ticket_message_id IN ?", append(contactIDs, 0), append(messageIDs, 0)).
+				Delete(&Attachment{}).Error
+		})
+		report(len(attachments), "bijlagen verwijderd")
+	}
+	if len(ticketIDs) > 0 {
+		step(func() error {
+			if err := tx.Where("ticket_id IN ?", ticketIDs).Delete(&TicketMessage{}).Error; err != nil {
+				return err
+			}
+			return tx.Where("id IN ?", ticketIDs).Delete(&Ticket{}).Error
+		})
+		report(len(ticketIDs), "tickets verwijderd")
+	}
+
+	// draft quotes were never sent, but carry the address all the same
+	var kept, removed []uint
+	step(func() error {
+		var quotes []Quote
+		if err := tx.Where("lower(email) = ?", email).Find(&quotes).Error; err != nil {
+			return err
+		}
+		for _, q := range quotes {
+			if q.Status == quoteAccepted {
+				kept = append(kept, q.ID)
+			} else {
+				removed = append(removed, q.ID)
+			}
+		}
+		if len(kept) > 0 {
+			if err := tx.Model(&Quote{}).Where("id IN ?", kept).UpdateColumn("contact_id", nil).Error; err != nil {
+				return err
+			}
+		}
+		if len(removed) > 0 {
+			if err := tx.Where("quote_id IN ?", removed).Delete(&QuoteLine{}).Error; err != nil {
+				return err
+			}
+			return tx.Where("id IN ?", removed).Delete(&Quote{}).Error
+		}
+		return nil
+	})
+
+	if len(contactIDs) > 0 {
+		step(func() error {
+			// records of others can point to the request, e.g. a quote sent
+			// to a colleague
+			for _, model := range []any{&Quote{}, &Ticket{}, &Subscriber{}, &ConsentRecord{}} {
+				if err := tx.Model(model).Where("contact_id IN ?", contactIDs).UpdateColumn("contact_id", nil).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Where("id IN ?", contactIDs).Delete(&Contact{}).Error
+		})
+		report(len(contactIDs), "contactaanvragen verwijderd")
+	}
+	if len(d.Afspraken) > 0 {
+		// the slots stay booked in the history; future visits are called off
+		step(func() error {
+			now := time.Now().UTC()
+			err := tx.Model(&Appointment{}).
+				Where("lower(email) = ? AND starts_at > ? AND status <> ?", email, now, appointmentCancelled).
+				Updates(map[string]any{"status": appointmentCancelled, "cancelled_at": now, "sequence": gorm.Expr("sequence + 1")}).Error
+			if err != nil {
+				return err
+			}
+			return tx.Model(&Appointment{}).Where("lower(email) = ?", email).Updates(map[string]any{
+				"naam": "Verwijderd", "email": "", "telefoon": "", "adres": "", "bericht": "",
+			}).Error
+		})
+		report(len(d.Afspraken), "afspraken geanonimiseerd")
+	}
+	if d.Nieuwsbrief != nil {
+		step(func() error { return tx.Delete(d.Nieuwsbrief).Error })
+		report(1, "nieuwsbriefaanmelding verwijderd")
+	}
+	if len(d.Maillogboek) > 0 {
+		step(func() error {
+			return tx.Model(&MailEvent{}).Where("email = ?", email).Updates(map[string]any{"email": "", "detail": ""}).Error
+		})
+		report(len(d.Maillogboek), "meldingen van de mailprovider geanonimiseerd")
+	}
+	if len(d.Emails) > 0 {
+		// campaign mail is kept without content for the campaign statistics
+		step(func() error {
+			err := tx.Model(&OutboxMessage{}).Where(`lower("to") = ? AND campaign_id IS NOT NULL`, email).
+				Updates(map[string]any{"to": "", "text": "", "html": "", "headers": ""}).Error
+			if err != nil {
+				return err
+			}
+			var ids []uint
+			if err := tx.Model(&OutboxMessage{}).Where(`lower("to") = ?`, email).Pluck("id", &ids).Error; err != nil {
+				return err
+			}
+			if len(ids) == 0 {
+				return nil
+			}
+			if err := tx.Where("outbox_message_id IN ?", ids).Delete(&OutboxAttachment{}).Error; err != nil {
+				return err
+			}
+			return tx.Where("id IN ?", ids).Delete(&OutboxMessage{}).Error
+		})
+		report(len(d.Emails), "e-mails verwijderd")
+	}
+	if len(d.Toestemmingen) > 0 {
+		step(func() error { return tx.Where("email = ?", email).Delete(&ConsentRecord{}).Error })
+		report(len(d.Toestemmingen), "vastgelegde toestemmingen verwijderd")
+	}
+
+	for _, f := range steps {
+		if err := f(); err != nil {
+			return nil, nil, err
+		}
+	}
+	report(len(removed), "offertes verwijderd")
+	report(len(kept), "geaccepteerde offertes bewaard (wettelijke bewaarplicht van 7 jaar)")
+	return done, attachments, nil
+}
+
+// recordDataRequest adds the audit record of a handled request.
+func recordDataRequest(tx *gorm.DB, c *gin.Context, kind, channel, email string, summary []string) error {
+	if len(summary) == 0 {
+		summary = []string{"geen gegevens gevonden"}
+	}
+	r := DataRequest{
+		Kind:      kind,
+		Channel:   channel,
+		EmailHash: dataRequestHash(email),
+		Summary:   strings.Join(summary, "; "),
+		IP:        c.ClientIP(),
+	}
+	action := "gegevens.inzage"
+	if kind == dataRequestErasure {
+		action = "gegevens.verwijderd"
+	}
+	err := tx.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&r).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, action, "betrokkene", r.EmailHash, auditChanges{"Samenvatting": {nil, r.Summary}})
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("privacy: %s via %s handled (%s)", kind, channel, r.Summary)
+	return nil
+}
+
+// sendExport answers with the ZIP export of d and records the request.
+func sendExport(c *gin.Context, d PersonalData, channel string) {
+	if err := recordDataRequest(db, c, dataRequestExport, channel, d.Email, d.Summary()); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="gegevens-%s.zip"`, time.Now().Format("2006-01-02")))
+	c.Header("Cache-Control", "no-store")
+	if err := writeExport(c, c.Writer, d); err != nil {
+		// the response has started; all that is left is to log it
+		log.Printf("privacy: export: %v", err)
+	}
+}
+
+// erase erases the data held about email and records the request.
+func erase(c *gin.Context, email, channel string) ([]string, error) {
+	var done []string
+	var files []Attachment
+	err := db.Transaction(func(tx *gorm.DB) error {
+		d, err := collectPersonalData(tx, email)
+		if err != nil {
+			return err
+		}
+		if done, files, err = erasePersonalData(tx, d); err != nil {
+			return err
+		}
+		return recordDataRequest(tx, c, dataRequestErasure, channel, email, done)
+	})
+	if err != nil {
+		return nil, err
+	}
+	discardAttachments(files)
+	return done, nil
+}
+
+// adminPrivacyHandler handles GET /admin/privacy: look up what is held
+// about an address, and the log of requests handled.
+func adminPrivacyHandler(c *gin.Context) {
+	email := normalizeEmail(c.Query("email"))
+	data := gin.H{"Email": email}
+	requests := db.Order("created_at DESC").Limit(100)
+	if email != "" {
+		d, err := collectPersonalData(db, email)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		data["Found"] = d.Summary()
+		requests = requests.Where("email_hash = ?", dataRequestHash(email))
+	}
+	var handled []DataRequest
+	if err := requests.Find(&handled).Error; err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	data["Requests"] = handled
+	renderAdmin(c, http.StatusOK, "privacy", "privacy", "Privacyverzoeken", data)
+}
+
+// adminPrivacyExportHandler handles POST /admin/privacy/export.
+func adminPrivacyExportHandler(c *gin.Context) {
+	email := normalizeEmail(c.PostForm("email"))
+	d, err := collectPersonalData(db, email)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	sendExport(c, d, channelAdmin)
+}
+
+// adminPrivacyEraseHandler handles POST /admin/privacy/verwijderen.
+func adminPrivacyEraseHandler(c *gin.Context) {
+	email := normalizeEmail(c.PostForm("email"))
+	if !validEmail(email) || c.PostForm("bevestig") == "" {
+		redirectAdmin(c, "/admin/privacy?email="+email, "Vink aan dat u de gegevens wilt verwijderen.")
+		return
+	}
+	done, err := erase(c, email, channelAdmin)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if len(done) == 0 {
+		redirectAdmin(c, "/admin/privacy", "Er waren geen gegevens van "+email+".")
+		return
+	}
+	redirectAdmin(c, "/admin/privacy", "Verwijderd voor "+email+": "+strings.Join(done, ", ")+".")
+}
+
+// privacyGroup returns the router group for the self-service requests.
+func privacyGroup(r *gin.Engine) *gin.RouterGroup {
+	return r.Group("/privacy/gegevens", adminHeaders, sameOriginPosts)
+}
+
+func renderPrivacyRequest(c *gin.Context, status int, data gin.H) {
+	c.HTML(status, "privacy-gegevens", privatePage(c.Request.URL.Path, "Uw gegevens", "privacy-gegevens", data))
+}
+
+// privacyRequestHandler handles GET /privacy/gegevens.
+func privacyRequestHandler(c *gin.Context) {
+	renderPrivacyRequest(c, http.StatusOK, gin.H{})
+}
+
+// privacyRequestPostHandler handles POST /privacy/gegevens: the link to
+// see or erase the data is mailed to the address, which proves the request
+// comes from its owner. The answer is the same whether or not we hold
+// anything about the address.
+func privacyRequestPostHandler(c *gin.Context) {
+	email := normalizeEmail(c.PostForm("email"))
+	kind := c.PostForm("soort")
+	if !validEmail(email) || (kind != dataRequestExport && kind != dataRequestErasure) {
+		renderPrivacyRequest(c, http.StatusBadRequest, gin.H{"Error": "Vul een geldig e-mailadres in en kies wat u wilt doen.", "Email": email})
+		return
+	}
+	d, err := collectPersonalData(db, email)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if !d.Empty() {
+		mail := dataRequestMail(email, kind)
+		var recent int64
+		err := db.Model(&OutboxMessage{}).
+			Where(`lower("to") = ? AND subject = ? AND created_at > ?`, email, mail.Subject, time.Now().Add(-time.Hour).UTC()).
+			Count(&recent).Error
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		if recent < maxDataMails {
+			if err := queueMail(db, mail); err != nil {
+				c.AbortWithError(http.StatusInternalServerError, err)
+				return
+			}
+		}
+	}
+	renderPrivacyRequest(c, http.StatusOK, gin.H{"Sent": email, "Minutes": int(dataRequestTTL.Minutes())})
+}
+
+func dataRequestMail(email, kind string) *OutboxMessage {
+	subject, action, purpose, path := "Inzage in uw gegevens bij "+company.Name, "downloadt u een overzicht van", tokenDataExport, "/privacy/gegevens/inzage"
+	if kind == dataRequestErasure {
+		subject, action, purpose, path = "Verwijderen van uw gegevens bij "+company.Name, "laat u verwijderen", tokenDataErasure, "/privacy/gegevens/verwijderen"
+	}
+	return &OutboxMessage{
+		To:      email,
+		Subject: subject,
+		Text: fmt.Sprintf(`Beste klant,
+
+U heeft gevraagd naar de gegevens die %s over %s bewaart. Met deze link %s alle gegevens bij dit e-mailadres:
+%s
+
+De link is %d minuten geldig. Heeft u hier niet om gevraagd? Dan kunt u deze e-mail negeren; er gebeurt dan niets.
+
+Met vriendelijke groet,
+%s
+`, company.Name, email, action, site.URL(path+"?token="+signToken(purpose, email, dataRequestTTL)),
+			int(dataRequestTTL.Minutes()), company.Name),
+	}
+}
+
+// privacyActionHandler handles GET and POST /privacy/gegevens/inzage and
+// /privacy/gegevens/verwijderen. Opening the link shows what will happen;
+// only posting it acts, as mail scanners open links too.
+func privacyActionHandler(purpose string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.Query("token")
+		if token == "" {
+			token = c.PostForm("token")
+		}
+		email, err := verifyToken(purpose, token)
+		if err != nil {
+			renderPrivacyRequest(c, http.StatusForbidden, gin.H{"Error": "Deze link is ongeldig of verlopen. Vraag hieronder een nieuwe aan."})
+			return
+		}
+		d, err := collectPersonalData(db, email)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		if c.Request.Method == http.MethodGet {
+			renderPrivacyRequest(c, http.StatusOK, gin.H{"Token": token, "Email": email, "Purpose": purpose, "Found": d.Summary()})
+			return
+		}
+		if purpose == tokenDataExport {
+			sendExport(c, d, channelSelfService)
+			return
+		}
+		done, err := erase(c, email, channelSelfService)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		renderPrivacyRequest(c, http.StatusOK, gin.H{"Erased": done, "Email": email})
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+// quotePDF renders quotes as A4 PDF documents in the site's colours, with
+// the Go fonts the Open Graph images use as well.
+type quotePDF struct {
+	logo     []byte
+	logoType string
+}
+
+func newQuotePDF(fsys fs.FS, logo string) (*quotePDF, error) {
+	b, err := fs.ReadFile(fsys, logo)
+	if err != nil {
+		return nil, err
+	}
+	return &quotePDF{logo: b, logoType: strings.TrimPrefix(strings.ToUpper(path.Ext(logo)), ".")}, nil
+}
+
+// Brand colours, see the :root variables in style.css.
+var (
+	pdfGreen = [3]int{0x7C, 0xB3, 0x42}
+	pdfBlue  = [3]int{0x21, 0x96, 0xF3}
+	pdfDark  = [3]int{0x21, 0x21, 0x21}
+	pdfMuted = [3]int{0x75, 0x75, 0x75}
+	pdfLight = [3]int{0xF5, 0xF5, 0xF5}
+)
+
+// render returns the quote as a PDF document.
+func (p *quotePDF) render(q Quote) ([]byte, error) {
+	const margin = 20.0
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(margin, margin, margin)
+	pdf.SetAutoPageBreak(true, 30)
+	pdf.AddUTF8FontFromBytes("Go", "", goregular.TTF)
+	pdf.AddUTF8FontFromBytes("Go", "B", gobold.TTF)
+	pdf.SetTitle(fmt.Sprintf("Offerte %s", q.Number), true)
+	pdf.SetAuthor(company.Name, true)
+	pdf.SetCreator(company.Name, true)
+	pdf.SetCreationDate(q.CreatedAt)
+	pdf.AliasNbPages("")
+	pageWidth, _ := pdf.GetPageSize()
+	contentWidth := pageWidth - 2*margin
+
+	text := func(style string, size float64, color [3]int) {
+		pdf.SetFont("Go", style, size)
+		pdf.SetTextColor(color[0], color[1], color[2])
+	}
+
+	pdf.SetHeaderFunc(func() {
+		pdf.LinearGradient(0, 0, pageWidth, 8, pdfGreen[0], pdfGreen[1], pdfGreen[2], pdfBlue[0], pdfBlue[1], pdfBlue[2], 0, 0, 1, 0)
+		pdf.SetY(margin)
+	})
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-20)
+		text("", 8, pdfMuted)
+		var parts []string
+		parts = append(parts, company.Name, company.AddressLine(), company.Email, company.PhoneDisplay)
+		if company.KvK != "" {
+			parts = append(parts, "KvK "+company.KvK)
+		}
+		if company.BTW != "" {
+			parts = append(parts, "btw "+company.BTW)
+		}
+		pdf.CellFormat(contentWidth, 4, strings.Join(parts, "  ·  "), "T", 1, "C", false, 0, "")
+		pdf.CellFormat(contentWidth, 4, fmt.Sprintf("Pagina %d van {nb}", pdf.PageNo()), "", 0, "C", false, 0, "")
+	})
+	pdf.AddPage()
+
+	// Logo and company details
+	pdf.RegisterImageOptionsReader("logo", fpdf.ImageOptions{ImageType: p.logoType}, bytes.NewReader(p.logo))
+	pdf.ImageOptions("logo", margin, margin, 0, 22, false, fpdf.ImageOptions{ImageType: p.logoType}, 0, "")
+	pdf.SetXY(pageWidth/2, margin)
+	text("B", 14, pdfDark)
+	pdf.CellFormat(contentWidth/2, 7, company.Name, "", 2, "R", false, 0, "")
+	text("", 9, pdfMuted)
+	for _, line := range []string{company.AddressLine(), company.Email, company.PhoneDisplay, site.BaseURL} {
+		pdf.CellFormat(contentWidth/2, 4.5, line, "", 2, "R", false, 0, "")
+	}
+
+	// Customer and quote details
+	pdf.SetY(margin + 38)
+	top := pdf.GetY()
+	text("", 10, pdfDark)
+	for _, line := range []string{q.Bedrijf, q.Naam, q.Adres, q.Email} {
+		if line != "" {
+			pdf.CellFormat(contentWidth/2, 5, line, "", 2, "L", false, 0, "")
+		}
+	}
+	bottom := pdf.GetY()
+	pdf.SetXY(pageWidth/2, top)
+	issued := q.CreatedAt
+	if q.SentAt != nil {
+		issued = *q.SentAt
+	}
+	for _, row := range [][2]string{
+		{"Offertenummer", q.Number},
+		{"Datum", dateNL(issued)},
+		{"Geldig tot", dateNL(q.ValidUntil)},
+	} {
+		pdf.SetX(pageWidth / 2)
+		text("", 10, pdfMuted)
+		pdf.CellFormat(contentWidth/4, 5, row[0], "", 0, "L", false, 0, "")
+		text("B", 10, pdfDark)
+		pdf.CellFormat(contentWidth/4, 5, row[1], "", 1, "R", false, 0, "")
+	}
+	pdf.SetY(max(bottom, pdf.GetY()) + 10)
+
+	text("B", 18, pdfDark)
+	pdf.MultiCell(contentWidth, 8, "Offerte: "+q.Onderwerp, "", "L", false)
+	pdf.Ln(2)
+	if q.Toelichting != "" {
+		text("", 10, pdfDark)
+		pdf.MultiCell(contentWidth, 5, q.Toelichting, "", "L", false)
+		pdf.Ln(4)
+	}
+
+	// Line items
+	widths := []float64{contentWidth - 95, 20, 30, 15, 30}
+	header := []string{"Omschrijving", "Aantal", "Prijs", "Btw", "Totaal"}
+	align := []string{"L", "R", "R", "R", "R"}
+	pdf.SetFillColor(pdfGreen[0], pdfGreen[1], pdfGreen[2])
+	text("B", 9, [3]int{255, 255, 255})
+	for i, h := range header {
+		pdf.CellFormat(widths[i], 8, h, "", 0, align[i], true, 0, "")
+	}
+	pdf.Ln(-1)
+	text("", 9, pdfDark)
+	pdf.SetFillColor(pdfLight[0], pdfLight[1], pdfLight[2])
+	for i, l := range q.Lines {
+		lines := pdf.SplitText(l.Description, widths[0]-2)
+		height := float64(len(lines)) * 5
+		if height < 7 {
+			height = 7
+		}
+		if pdf.GetY()+height > 267 {
+			pdf.AddPage()
+		}
+		x, y := pdf.GetXY()
+		if i%2 == 1 {
+			pdf.Rect(x, y, contentWidth, height, "F")
+		}
+		pdf.MultiCell(widths[0], height/float64(len(lines)), l.Description, "", "L", false)
+		pdf.SetXY(x+widths[0], y)
+		for j, v := range []string{formatQuantity(l.Quantity), formatEuro(l.UnitPrice), fmt.Sprintf("%d%%", l.VATRate), formatEuro(l.Total())} {
+			pdf.CellFormat(widths[j+1], height, v, "", 0, "R", false, 0, "")
+		}
+		pdf.SetXY(x, y+height)
+	}
+
+	// Totals
+	totals := q.Totals()
+	pdf.Ln(4)
+	labelWidth, amountWidth := 60.0, 30.0
+	row := func(label, amount string, bold bool) {
+		pdf.SetX(margin + contentWidth - labelWidth - amountWidth)
+		style := ""
+		if bold {
+			style = "B"
+		}
+		text(style, 10, pdfDark)
+		pdf.CellFormat(labelWidth, 6, label, "", 0, "L", false, 0, "")
+		pdf.CellFormat(amountWidth, 6, amount, "", 1, "R", false, 0, "")
+	}
+	row("Subtotaal excl. btw", formatEuro(totals.Subtotal), false)
+	for _, v := range totals.VAT {
+		row(fmt.Sprintf("Btw %d%% over %s", v.Rate, formatEuro(v.Base)), formatEuro(v.Amount), false)
+	}
+	pdf.SetX(margin + contentWidth - labelWidth - amountWidth)
+	pdf.SetDrawColor(pdfGreen[0], pdfGreen[1], pdfGreen[2])
+	pdf.Line(pdf.GetX(), pdf.GetY()+1, margin+contentWidth, pdf.GetY()+1)
+	pdf.Ln(2)
+	row("Totaal incl. btw", formatEuro(totals.Total), true)
+
+	// Acceptance
+	pdf.Ln(10)
+	text("", 10, pdfDark)
+	switch q.Status {
+	case quoteAccepted:
+		pdf.MultiCell(contentWidth, 5, fmt.Sprintf("Deze offerte is op %s om %s geaccepteerd door %s.",
+			dateNL(*q.AcceptedAt), q.AcceptedAt.In(openingHoursLocation).Format("15:04"), q.AcceptedBy), "", "L", false)
+	default:
+		pdf.MultiCell(contentWidth, 5, fmt.Sprintf(
+			"Deze offerte is geldig tot en met %s. U kunt de offerte online accepteren via de link in onze e-mail, "+
+				"of neem contact met ons op via %s of %s.", dateNL(q.ValidUntil), company.Email, company.PhoneDisplay), "", "L", false)
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// dateNL formats t as e.g. "27-04-2026".
+func dateNL(t time.Time) string {
+	return t.In(openingHoursLocation).Format("02-01-2006")
+}
+
+// dateTimeNL formats t as e.g. "27-04-2026 14:30".
+func dateTimeNL(t time.Time) string {
+	return t.In(openingHoursLocation).Format("02-01-2006 15:04")
+}
@@ -0,0 +1,571 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Quote is an offerte: priced line items sent to a customer, who can accept
+// it online until it expires.
+type Quote struct {
+	ID          uint   `gorm:"primaryKey"`
+	Number      string `gorm:"not null;uniqueIndex"` // e.g. OF-2026-0007
+	ContactID   *uint  `gorm:"index"`                // the contact request it answers, if any
+	Contact     *Contact
+	Naam        string `gorm:"not null"`
+	Bedrijf     string
+	Email       string `gorm:"not null"`
+	Adres       string
+	Onderwerp   string `gorm:"not null"`
+	Toelichting string
+	Lines       []QuoteLine `gorm:"constraint:OnDelete:CASCADE"`
+	Status      string      `gorm:"not null;index"`
+	ValidUntil  time.Time   `gorm:"not null"`
+	SentAt      *time.Time
+	AcceptedAt  *time.Time
+	AcceptedBy  string // name typed by the customer when accepting
+	AcceptedIP  string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// QuoteLine is one item on a quote. Amounts are in euro cents.
+type QuoteLine struct {
+	ID          uint    `gorm:"primaryKey"`
+	QuoteID     uint    `gorm:"not null;index"`
+	Position    int     `gorm:"not null"`
+	Description string  `gorm:"not null"`
+	Quantity    float64 `gorm:"not null"`
+	UnitPrice   int64   `gorm:"not null"` // excluding VAT
+	VATRate     int     `gorm:"not null"` // percent
+}
+
+// Quote statuses. A sent quote becomes expired once its validity ends.
+const (
+	quoteDraft    = "concept"
+	quoteSent     = "verzonden"
+	quoteAccepted = "geaccepteerd"
+	quoteExpired  = "verlopen"
+)
+
+// vatRates are the Dutch VAT rates a quote line can carry.
+var vatRates = []int{21, 9}
+
+// quoteValidity is how long a quote is valid by default.
+const quoteValidityDays = 30
+
+// tokenQuote is the token purpose of the link customers view a quote with.
+const tokenQuote = "offerte"
+
+// Total returns the line amount excluding VAT, in cents.
+func (l QuoteLine) Total() int64 {
+	return int64(math.Round(l.Quantity * float64(l.UnitPrice)))
+}
+
+// VATLine is the VAT due at one rate.
+type VATLine struct {
+	Rate   int
+	Base   int64
+	Amount int64
+}
+
+// QuoteTotals are the amounts at the bottom of a quote, in cents.
+type QuoteTotals struct {
+	Subtotal int64
+	VAT      []VATLine
+	Total    int64
+}
+
+// Totals adds up the lines. VAT is calculated per rate over the sum of the
+// lines at that rate and rounded to the cent, as on an invoice.
+func (q Quote) Totals() QuoteTotals {
+	var t QuoteTotals
+	base := make(map[int]int64)
+	for _, l := range q.Lines {
+		t.Subtotal += l.Total()
+		base[l.VATRate] += l.Total()
+	}
+	for rate, amount := range base {
+		vat := int64(math.Round(float64(amount) * float64(rate) / 100))
+		t.VAT = append(t.VAT, VATLine{Rate: rate, Base: amount, Amount: vat})
+		t.Total += vat
+	}
+	sort.Slice(t.VAT, func(i, j int) bool { return t.VAT[i].Rate > t.VAT[j].Rate })
+	t.Total += t.Subtotal
+	return t
+}
+
+// Editable reports whether the quote can still be changed.
+func (q Quote) Editable() bool {
+	return q.Status == quoteDraft
+}
+
+// Acceptable reports whether the customer can accept the quote now.
+func (q Quote) Acceptable() bool {
+	return q.Status == quoteSent && !q.expired()
+}
+
+func (q Quote) expired() bool {
+	return time.Now().After(q.ValidUntil.AddDate(0, 0, 1))
+}
+
+// expireQuotes marks sent quotes past their validity as expired.
+func expireQuotes(tx *gorm.DB) error {
+	return tx.Model(&Quote{}).
+		Where("status = ? AND valid_until < ?", quoteSent, startOfDay(time.Now()).UTC()).
+		Update("status", quoteExpired).Error
+}
+
+// nextQuoteNumber returns the next number in the current year's series.
+// Numbers are padded to four digits, so past 9999 they get longer: the
+// longest number is sorted first, as "…-10000" sorts below "…-9999".
+func nextQuoteNumber(tx *gorm.DB) (string, error) {
+	prefix := fmt.Sprintf("OF-%d-", time.Now().In(openingHoursLocation).Year())
+	var last Quote
+	err := tx.Where("number LIKE ?", prefix+"%").Order("length(number) DESC, number DESC").First(&last).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return prefix + "0001", nil
+	} else if err != nil {
+		return "", err
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(last.Number, prefix))
+	if err != nil {
+		return "", fmt.Errorf("last quote number %q: %w", last.Number, err)
+	}
+	return fmt.Sprintf("%s%04d", prefix, n+1), nil
+}
+
+// formatEuro formats cents as e.g. "€ 1.234,56".
+func formatEuro(cents int64) string {
+	sign := ""
+	if cents < 0 {
+		sign, cents = "-", -cents
+	}
+	euros := strconv.FormatInt(cents/100, 10)
+	for i := len(euros) - 3; i > 0; i -= 3 {
+		euros = euros[:i] + "." + euros[i:]
+	}
+	return fmt.Sprintf("€ %s%s,%02d", sign, euros, cents%100)
+}
+
+// formatAmount formats cents for a form field, e.g. "1.234,56".
+func formatAmount(cents int64) string {
+	return strings.TrimPrefix(formatEuro(cents), "€ ")
+}
+
+// parseEuro parses an amount as typed in a form, "1.234,56" or "1234.56",
+// into cents.
+func parseEuro(s string) (int64, error) {
+	s = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(s), "€"))
+	if strings.Contains(s, ",") {
+		s = strings.ReplaceAll(s, ".", "")
+		s = strings.Replace(s, ",", ".", 1)
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("ongeldig bedrag %q", s)
+	}
+	return int64(math.Round(f * 100)), nil
+}
+
+// formatQuantity writes a quantity the Dutch way, without needless decimals.
+func formatQuantity(q float64) string {
+	return strings.Replace(strconv.FormatFloat(q, 'f', -1, 64), ".", ",", 1)
+}
+
+// quoteLink returns the link a customer views and accepts the quote with.
+func quoteLink(q Quote) string {
+	ttl := time.Until(q.ValidUntil) + 90*24*time.Hour
+	return site.URL("/offerte?token=" + signID(tokenQuote, q.ID, ttl))
+}
+
+// applyQuoteForm copies the posted form onto q, replacing its lines.
+func applyQuoteForm(c *gin.Context, q *Quote) error {
+	q.Naam = strings.TrimSpace(c.PostForm("naam"))
+	q.Bedrijf = strings.TrimSpace(c.PostForm("bedrijf"))
+	q.Email = strings.TrimSpace(c.PostForm("email"))
+	q.Adres = strings.TrimSpace(c.PostForm("adres"))
+	q.Onderwerp = strings.TrimSpace(c.PostForm("onderwerp"))
+	q.Toelichting = strings.TrimSpace(c.PostForm("toelichting"))
+	switch {
+	case q.Naam == "":
+		return errors.New("vul de naam van de klant in")
+	case !validEmail(q.Email):
+		return errors.New("vul een geldig e-mailadres in")
+	case q.Onderwerp == "":
+		return errors.New("vul een onderwerp in")
+	}
+	validUntil, err := time.ParseInLocation(time.DateOnly, c.PostForm("geldig_tot"), openingHoursLocation)
+	if err != nil {
+		return errors.New("vul een geldige datum in bij geldig tot")
+	}
+	q.ValidUntil = validUntil.UTC()
+
+	descriptions := c.PostFormArray("omschrijving")
+	quantities := c.PostFormArray("aantal")
+	prices := c.PostFormArray("prijs")
+	rates := c.PostFormArray("btw")
+	q.Lines = nil
+	for i, description := range descriptions {
+		description = strings.TrimSpace(description)
+		if description == "" {
+			continue
+		}
+		if i >= len(quantities) || i >= len(prices) || i >= len(rates) {
+			return errors.New("onvolledige regel")
+		}
+		quantity, err := strconv.ParseFloat(strings.Replace(strings.TrimSpace(quantities[i]), ",", ".", 1), 64)
+		if err != nil || quantity <= 0 {
+			return fmt.Errorf("regel %q: ongeldig aantal", description)
+		}
+		price, err := parseEuro(prices[i])
+		if err != nil {
+			return fmt.Errorf("regel %q: %v", description, err)
+		}
+		rate, _ := strconv.Atoi(rates[i])
+		if !validVATRate(rate) {
+			return fmt.Errorf("regel %q: ongeldig btw-tarief", description)
+		}
+		q.Lines = append(q.Lines, QuoteLine{
+			Position:    len(q.Lines) + 1,
+			Description: description,
+			Quantity:    quantity,
+			UnitPrice:   price,
+			VATRate:     rate,
+		})
+	}
+	if len(q.Lines) == 0 {
+		return errors.New("voeg minstens één regel toe")
+	}
+	return nil
+}
+
+func validVATRate(rate int) bool {
+	for _, r := range vatRates {
+		if r == rate {
+			return true
+		}
+	}
+	return false
+}
+
+// adminQuotesHandler handles GET /admin/offertes.
+func adminQuotesHandler(c *gin.Context) {
+	if err := expireQuotes(db); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	var quotes []Quote
+	if err := db.Preload("Lines").Order("created_at DESC").Find(&quotes).Error; err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	renderAdmin(c, http.StatusOK, "offertes", "offertes", "Offertes", quotes)
+}
+
+// adminNewQuoteHandler handles GET /admin/offertes/nieuw, optionally
+// prefilled from a contact request with ?contact=<id>.
+func adminNewQuoteHandler(c *gin.Context) {
+	q := Quote{
+		Status:     quoteDraft,
+		ValidUntil: startOfDay(time.Now()).AddDate(0, 0, quoteValidityDays),
+		Lines:      []QuoteLine{{Quantity: 1, VATRate: 21}},
+	}
+	if id, err := strconv.ParseUint(c.Query("contact"), 10, 64); err == nil {
+		var contact Contact
+		if err := db.First(&contact, id).Error; err == nil {
+			contactID := contact.ID
+			q.ContactID, q.Contact = &contactID, &contact
+			q.Naam, q.Bedrijf, q.Email = contact.Naam, contact.Bedrijf, contact.Email
+			q.Onderwerp = "Offerte"
+			q.Toelichting = "Naar aanleiding van uw aanvraag doen wij u graag de volgende offerte toekomen."
+		}
+	}
+	renderAdmin(c, http.StatusOK, "offerte", "offertes", "Nieuwe offerte", gin.H{"Quote": q, "VATRates": vatRates})
+}
+
+// adminCreateQuoteHandler handles POST /admin/offertes.
+func adminCreateQuoteHandler(c *gin.Context) {
+	q := Quote{Status: quoteDraft}
+	if id, err := strconv.ParseUint(c.PostForm("contact_id"), 10, 64); err == nil {
+		contactID := uint(id)
+		q.ContactID = &contactID
+	}
+	if err := applyQuoteForm(c, &q); err != nil {
+		renderAdmin(c, http.StatusBadRequest, "offerte", "offertes", "Nieuwe offerte",
+			gin.H{"Quote": q, "VATRates": vatRates, "Error": err.Error()})
+		return
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if q.Number, err = nextQuoteNumber(tx); err != nil {
+			return err
+		}
+		if err := tx.Create(&q).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, "offerte.aangemaakt", "offerte", fmt.Sprint(q.ID), auditDiff(nil, auditFields(q)))
+	})
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	redirectAdmin(c, fmt.Sprintf("/admin/offertes/%d", q.ID), "Offerte "+q.Number+" aangemaakt.")
+}
+
+// loadQuote loads the quote named by the :id parameter, or responds with
+// 404.
+func loadQuote(c *gin.Context) (Quote, bool) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	q, err := findQuote(uint(id))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return q, false
+	}
+	return q, true
+}
+
+// findQuote loads a quote with its lines and contact, marking it expired
+// when its validity has ended.
+func findQuote(id uint) (Quote, error) {
+	var q Quote
+	err := db.Preload("Lines", func(tx *gorm.DB) *gorm.DB { return tx.Order("position") }).
+		Preload("Contact").First(&q, id).Error
+	if err != nil {
+		return q, err
+	}
+	if q.Status == quoteSent && q.expired() {
+		q.Status = quoteExpired
+		db.Model(&q).Update("status", quoteExpired)
+	}
+	return q, nil
+}
+
+// adminQuoteHandler handles GET /admin/offertes/:id.
+func adminQuoteHandler(c *gin.Context) {
+	q, ok := loadQuote(c)
+	if !ok {
+		return
+	}
+	renderAdmin(c, http.StatusOK, "offerte", "offertes", "Offerte "+q.Number,
+		gin.H{"Quote": q, "VATRates": vatRates, "Link": quoteLink(q)})
+}
+
+// adminUpdateQuoteHandler handles POST /admin/offertes/:id. Only drafts can
+// be changed; a sent quote is what the customer agreed to.
+func adminUpdateQuoteHandler(c *gin.Context) {
+	q, ok := loadQuote(c)
+	if !ok {
+		return
+	}
+	if !q.Editable() {
+		redirectAdmin(c, fmt.Sprintf("/admin/offertes/%d", q.ID), "Een verzonden offerte kan niet meer worden gewijzigd.")
+		return
+	}
+	before := auditFields(q)
+	if err := applyQuoteForm(c, &q); err != nil {
+		renderAdmin(c, http.StatusBadRequest, "offerte", "offertes", "Offerte "+q.Number,
+			gin.H{"Quote": q, "VATRates": vatRates, "Error": err.Error()})
+		return
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("quote_id = ?", q.ID).Delete(&QuoteLine{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Session(&gorm.Session{FullSaveAssociations: true}).Omit("Contact").Save(&q).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, "offerte.gewijzigd", "offerte", fmt.Sprint(q.ID), auditDiff(before, auditFields(q)))
+	})
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	redirectAdmin(c, fmt.Sprintf("/admin/offertes/%d", q.ID), "Offerte opgeslagen.")
+}
+
+// adminSendQuoteHandler handles POST /admin/offertes/:id/verzenden: the
+// customer gets the quote as PDF with a link to accept it online.
+func adminSendQuoteHandler(pdf *quotePDF) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q, ok := loadQuote(c)
+		if !ok {
+			return
+		}
+		if q.Status != quoteDraft && q.Status != quoteSent {
+			redirectAdmin(c, fmt.Sprintf("/admin/offertes/%d", q.ID), "Deze offerte kan niet meer worden verzonden.")
+			return
+		}
+		before := auditFields(q)
+		now := time.Now().UTC()
+		q.Status, q.SentAt = quoteSent, &now
+		doc, err := pdf.render(q)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&q).Updates(map[string]any{"status": q.Status, "sent_at": q.SentAt}).Error; err != nil {
+				return err
+			}
+			if err := recordAudit(tx, c, "offerte.verzonden", "offerte", fmt.Sprint(q.ID), auditDiff(before, auditFields(q))); err != nil {
+				return err
+			}
+			return queueMail(tx, quoteMail(q, doc))
+		})
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		redirectAdmin(c, fmt.Sprintf("/admin/offertes/%d", q.ID), "Offerte verzonden naar "+q.Email+".")
+	}
+}
+
+// adminQuotePDFHandler handles GET /admin/offertes/:id/pdf.
+func adminQuotePDFHandler(pdf *quotePDF) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q, ok := loadQuote(c)
+		if !ok {
+			return
+		}
+		servePDF(c, pdf, q)
+	}
+}
+
+func servePDF(c *gin.Context, pdf *quotePDF, q Quote) {
+	doc, err := pdf.render(q)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="%s.pdf"`, q.Number))
+	c.Data(http.StatusOK, "application/pdf", doc)
+}
+
+func quoteMail(q Quote, pdf []byte) *OutboxMessage {
+	totals := q.Totals()
+	return &OutboxMessage{
+		To:      q.Email,
+		Subject: fmt.Sprintf("Offerte %s: %s", q.Number, q.Onderwerp),
+		Text: fmt.Sprintf(`Beste %s,
+
+Hierbij ontvangt u onze offerte %s voor "%s", als PDF in de bijlage.
+
+Totaal: %s inclusief btw
+Geldig tot: %s
+
+U kunt de offerte bekijken en online accepteren via:
+%s
+
+Heeft u vragen? Bel ons op %s of beantwoord deze e-mail.
+
+Met vriendelijke groet,
+%s
+`, q.Naam, q.Number, q.Onderwerp, formatEuro(totals.Total), dutchDate(q.ValidUntil),
+			quoteLink(q), company.PhoneDisplay, company.Name),
+		Attachments: []OutboxAttachment{{
+			Filename:    q.Number + ".pdf",
+			ContentType: "application/pdf",
+			Data:        pdf,
+		}},
+	}
+}
+
+// quoteFromToken loads the quote a customer link points to.
+func quoteFromToken(c *gin.Context) (Quote, string, bool) {
+	token := c.Query("token")
+	if token == "" {
+		token = c.PostForm("token")
+	}
+	id, err := verifyID(tokenQuote, token)
+	if err != nil {
+		c.HTML(http.StatusForbidden, "offerte", privatePage("/offerte", "Offerte", "offerte", gin.H{"Error": err.Error()}))
+		return Quote{}, "", false
+	}
+	q, err := findQuote(id)
+	if err != nil || q.Status == quoteDraft {
+		c.HTML(http.StatusNotFound, "offerte", privatePage("/offerte", "Offerte", "offerte", gin.H{"Error": "Deze offerte bestaat niet meer."}))
+		return Quote{}, "", false
+	}
+	return q, token, true
+}
+
+// quoteHandler handles GET /offerte?token=...: the customer's view of a
+// quote.
+func quoteHandler(c *gin.Context) {
+	q, token, ok := quoteFromToken(c)
+	if !ok {
+		return
+	}
+	c.HTML(http.StatusOK, "offerte", privatePage("/offerte", "Offerte "+q.Number, "offerte", gin.H{"Quote": q, "Token": token}))
+}
+
+// quotePDFHandler handles GET /offerte/pdf?token=....
+func quotePDFHandler(pdf *quotePDF) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q, _, ok := quoteFromToken(c)
+		if !ok {
+			return
+		}
+		servePDF(c, pdf, q)
+	}
+}
+
+// quoteAcceptHandler handles POST /offerte/accepteren. The customer types
+// their name to accept; name, time and address are kept as evidence.
+func quoteAcceptHandler(c *gin.Context) {
+	q, token, ok := quoteFromToken(c)
+	if !ok {
+		return
+	}
+	data := gin.H{"Quote": q, "Token": token}
+	name := strings.TrimSpace(c.PostForm("naam"))
+	switch {
+	case !q.Acceptable():
+		data["Error"] = "Deze offerte kan niet meer worden geaccepteerd."
+	case name == "" || c.PostForm("akkoord") == "":
+		data["Error"] = "Vul uw naam in en vink aan dat u akkoord gaat met de offerte."
+	}
+	if data["Error"] != nil {
+		c.HTML(http.StatusBadRequest, "offerte", privatePage("/offerte", "Offerte "+q.Number, "offerte", data))
+		return
+	}
+
+	now := time.Now().UTC()
+	q.Status, q.AcceptedAt, q.AcceptedBy, q.AcceptedIP = quoteAccepted, &now, name, c.ClientIP()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&Quote{}).Where("id = ? AND status = ?", q.ID, quoteSent).Updates(map[string]any{
+			"status": q.Status, "accepted_at": q.AcceptedAt, "accepted_by": q.AcceptedBy, "accepted_ip": q.AcceptedIP,
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errors.New("deze offerte is al verwerkt")
+		}
+		return queueMail(tx, &OutboxMessage{
+			To:      company.Email,
+			Subject: fmt.Sprintf("Offerte %s geaccepteerd door %s", q.Number, name),
+			Text: fmt.Sprintf("Offerte %s (%s) is op %s om %s geaccepteerd door %s.\nTotaal: %s inclusief btw.\n",
+				q.Number, q.Onderwerp, dutchDate(now.In(openingHoursLocation)), now.In(openingHoursLocation).Format("15:04"),
+				name, formatEuro(q.Totals().Total)),
+		})
+	})
+	if err != nil {
+		data["Error"] = err.Error()
+		c.HTML(http.StatusConflict, "offerte", privatePage("/offerte", "Offerte "+q.Number, "offerte", data))
+		return
+	}
+	data["Quote"] = q
+	data["Notice"] = "Bedankt! Wij hebben uw akkoord ontvangen en nemen contact met u op om de werkzaamheden in te plannen."
+	c.HTML(http.StatusOK, "offerte", privatePage("/offerte", "Offerte "+q.Number, "offerte", data))
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestQuoteTotals(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []QuoteLine
+		want  QuoteTotals
+	}{
+		{"empty", nil, QuoteTotals{}},
+		{
+			// per line the VAT would be 12,59 + 2,10 = 14,69
+			"VAT rounded per rate, not per line",
+			[]QuoteLine{
+				{Quantity: 3, UnitPrice: 1999, VATRate: 21},
+				{Quantity: 1, UnitPrice: 1001, VATRate: 21},
+			},
+			QuoteTotals{Subtotal: 6998, VAT: []VATLine{{21, 6998, 1470}}, Total: 8468},
+		},
+		{
+			"two rates, fractional quantity",
+			[]QuoteLine{
+				{Quantity: 2.5, UnitPrice: 3333, VATRate: 9},
+				{Quantity: 1, UnitPrice: 10000, VATRate: 21},
+			},
+			QuoteTotals{Subtotal: 18333, VAT: []VATLine{{21, 10000, 2100}, {9, 8333, 750}}, Total: 21183},
+		},
+		{
+			"discount line",
+			[]QuoteLine{
+				{Quantity: 1, UnitPrice: 5000, VATRate: 21},
+				{Quantity: 1, UnitPrice: -1000, VATRate: 21},
+			},
+			QuoteTotals{Subtotal: 4000, VAT: []VATLine{{21, 4000, 840}}, Total: 4840},
+		},
+	}
+	for _, tt := range tests {
+		if got := (Quote{Lines: tt.lines}).Totals(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Totals() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFormatEuro(t *testing.T) {
+	tests := []struct {
+		cents int64
+		want  string
+	}{
+		{0, "€ 0,00"},
+		{5, "€ 0,05"},
+		{99999, "€ 999,99"},
+		{123456, "€ 1.234,56"},
+		{100000000, "€ 1.000.000,00"},
+		{-123456, "€ -1.234,56"},
+	}
+	for _, tt := range tests {
+		if got := formatEuro(tt.cents); got != tt.want {
+			t.Errorf("formatEuro(%d) = %q, want %q", tt.cents, got, tt.want)
+		}
+	}
+}
+
+func TestParseEuro(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{"1.234,56", 123456, true},
+		{"1234,56", 123456, true},
+		{"1234.56", 123456, true},
+		{"€ 1.234,56", 123456, true},
+		{" 12,5 ", 1250, true},
+		{"12", 1200, true},
+		{"-10,00", -1000, true},
+		{"0,1", 10, true},
+		{"", 0, false},
+		{"twaalf", 0, false},
+		{"NaN", 0, false},
+		{"Inf", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseEuro(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseEuro(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseEuroReadsFormatAmount(t *testing.T) {
+	for _, cents := range []int64{0, 1, 99, 100, 123456, 100000001, -2550} {
+		if got, err := parseEuro(formatAmount(cents)); err != nil || got != cents {
+			t.Errorf("parseEuro(%q) = %d, %v, want %d", formatAmount(cents), got, err, cents)
+		}
+	}
+}
+
+func TestNextQuoteNumber(t *testing.T) {
+	testDB(t, &Quote{}, &QuoteLine{})
+	prefix := fmt.Sprintf("OF-%d-", time.Now().In(openingHoursLocation).Year())
+	tests := []struct {
+		existing []string
+		want     string
+	}{
+		{nil, prefix + "0001"},
+		{[]string{"OF-2020-0007"}, prefix + "0001"},
+		{[]string{prefix + "0001", prefix + "0002"}, prefix + "0003"},
+		{[]string{prefix + "9998", prefix + "9999"}, prefix + "10000"},
+		{[]string{prefix + "9999", prefix + "10000", prefix + "0999"}, prefix + "10001"},
+	}
+	for _, tt := range tests {
+		if err := db.Where("1 = 1").Delete(&Quote{}).Error; err != nil {
+			t.Fatal(err)
+		}
+		for _, number := range tt.existing {
+			q := Quote{Number: number, Naam: "Klant", Email: "klant@example.nl", Status: quoteDraft, ValidUntil: time.Now()}
+			if err := db.Create(&q).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got, err := nextQuoteNumber(db); err != nil || got != tt.want {
+			t.Errorf("after %v: nextQuoteNumber = %q, %v, want %q", tt.existing, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin/render"
+)
+
+// layoutName is the template every page is rendered through.
+const layoutName = "base.html"
+
+// pageRenderer is a gin HTMLRender that renders every page through the shared
+// base layout. Each page gets its own template set (layout, partials and the
+// page itself) so the blocks one page defines cannot leak into another, and
+// only page names can be rendered: fragments are never served on their own.
+type pageRenderer struct {
+	fsys   fs.FS
+	funcs  template.FuncMap
+	reload bool
+
+	mu      sync.RWMutex
+	pages   map[string]*template.Template
+	modTime time.Time
+}
+
+// loadPages parses the layout and partials in fsys once per page template.
+// Pages are the *.html files in the root of fsys other than the layout; they
+// are addressed by their file name without extension, e.g. "contact". The
+// same goes for the other sections, each with its own layout. With
+// reload set the templates are parsed again whenever a file changes.
+func loadPages(fsys fs.FS, funcs template.FuncMap, reload bool) (*pageRenderer, error) {
+	r := &pageRenderer{fsys: fsys, funcs: funcs, reload: reload}
+	modTime, err := latestModTime(fsys)
+	if err != nil {
+		return nil, err
+	}
+	if r.pages, err = parsePages(fsys, funcs); err != nil {
+		return nil, err
+	}
+	r.modTime = modTime
+	return r, nil
+}
+
+// sections are the directories holding page templates. Each has its own
+// base.html layout; pages outside the root are addressed with their
+// directory, e.g. "admin/offertes".
+var sections = []string{".", "admin"}
+
+func parsePages(fsys fs.FS, funcs template.FuncMap) (map[string]*template.Template, error) {
+	partials, err := fs.Glob(fsys, "partials/*.html")
+	if err != nil {
+		return nil, err
+	}
+
+	pages := make(map[string]*template.Template)
+	for _, dir := range sections {
+		layout := path.Join(dir, layoutName)
+		files, err := fs.Glob(fsys, path.Join(dir, "*.html"))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if file == layout {
+				continue
+			}
+			set := append([]string{layout}, partials...)
+			t, err := template.New(layoutName).Funcs(funcs).ParseFS(fsys, append(set, file)...)
+			if err != nil {
+				return nil, fmt.Errorf("parse page %s: %w", file, err)
+			}
+			if t.Lookup("content") == nil {
+				return nil, fmt.Errorf("page %s does not define a content block", file)
+			}
+			pages[strings.TrimSuffix(file, ".html")] = t
+		}
+	}
+	return pages, nil
+}
+
+// latestModTime returns the most recent modification time of any file in
+// fsys. Embedded files all report the zero time, which never changes.
+func latestModTime(fsys fs.FS) (time.Time, error) {
+	var latest time.Time
+	err := fs.WalkDir(fsys, ".", func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+		return nil
+	})
+	return latest, err
+}
+
+// refresh re-parses the templates when any of them changed on disk. A broken
+// template is logged and the previous set keeps being served.
+func (r *pageRenderer) refresh() {
+	modTime, err := latestModTime(r.fsys)
+	if err != nil {
+		log.Printf("templates: %v", err)
+		return
+	}
+	r.mu.RLock()
+	stale := modTime.After(r.modTime)
+	r.mu.RUnlock()
+	if !stale {
+		return
+	}
+
+	pages, err := parsePages(r.fsys, r.funcs)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.modTime = modTime
+	if err != nil {
+		log.Printf("templates: %v", err)
+		return
+	}
+	r.pages = pages
+	log.Printf("templates: reloaded")
+}
+
+// Instance implements render.HTMLRender.
+func (r *pageRenderer) Instance(name string, data any) render.Render {
+	if r.reload {
+		r.refresh()
+	}
+	r.mu.RLock()
+	t, ok := r.pages[name]
+	r.mu.RUnlock()
+	if !ok {
+		return pageRender{err: fmt.Errorf("unknown page %q", name)}
+	}
+	return pageRender{template: t, data: data}
+}
+
+// pageRender executes a page into a buffer first so a template error never
+// results in a half-written response.
+type pageRender struct {
+	template *template.Template
+	data     any
+	err      error
+}
+
+func (p pageRender) Render(w http.ResponseWriter) error {
+	if p.err != nil {
+		return p.err
+	}
+	data := p.data
+	if page, ok := data.(PageData); ok {
+		// pages differ with the cookie choice of the visitor
+		if cw, ok := w.(consentWriter); ok {
+			page.Consent = cw.consent
+			data = page
+		}
+		w.Header().Add("Vary", "Cookie")
+	}
+	var buf bytes.Buffer
+	if err := p.template.ExecuteTemplate(&buf, layoutName, data); err != nil {
+		return err
+	}
+	p.WriteContentType(w)
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+func (p pageRender) WriteContentType(w http.ResponseWriter) {
+	header := w.Header()
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", "text/html; charset=utf-8")
+	}
+}
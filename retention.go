@@ -0,0 +1,440 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// retentionConfig says how long personal data is kept. The defaults in
+// config/retention.json follow the retention periods in the privacy policy.
+type retentionConfig struct {
+	// Interval is how often the policies are applied.
+	Interval configDuration `json:"interval"`
+	// DryRun only logs what the policies would remove.
+	DryRun   bool              `json:"dry_run"`
+	Policies []retentionPolicy `json:"policies"`
+}
+
+// retentionPolicy removes the records of one table once they are older
+// than Months.
+type retentionPolicy struct {
+	Table  string `json:"table"`
+	Months int    `json:"months"`
+	Action string `json:"action"` // delete or anonymize
+	Reason string `json:"reason"` // the promise in the privacy policy it keeps
+}
+
+// Retention actions.
+const (
+	retentionDelete    = "delete"
+	retentionAnonymize = "anonymize"
+)
+
+var retention retentionConfig
+
+func initRetention(path string) {
+	b := defaultRetentionConfig
+	if path != "" {
+		var err error
+		if b, err = os.ReadFile(path); err != nil {
+			log.Fatalf("Failed to read retention configuration: %v", err)
+		}
+	}
+	dec := json.NewDecoder(strings.NewReader(string(b)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&retention); err != nil {
+		log.Fatalf("Invalid retention configuration: %v", err)
+	}
+	if err := retention.validate(); err != nil {
+		log.Fatalf("Invalid retention configuration:\n%v", err)
+	}
+}
+
+func (c retentionConfig) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	check(c.Interval.Duration >= time.Minute, "interval must be at least 1m")
+	seen := make(map[string]bool)
+	for i, p := range c.Policies {
+		t, known := retentionTables[p.Table]
+		check(known, "policies[%d]: unknown table %q, expected one of %s", i, p.Table, strings.Join(retentionTableNames(), ", "))
+		check(!seen[p.Table], "policies[%d]: more than one policy for %s", i, p.Table)
+		seen[p.Table] = true
+		check(p.Months > 0, "policies[%d]: months must be positive", i)
+		switch p.Action {
+		case retentionDelete:
+		case retentionAnonymize:
+			check(!known || t.anonymize != nil, "policies[%d]: %s cannot be anonymized, only deleted", i, p.Table)
+		default:
+			check(false, "policies[%d]: action must be delete or anonymize, got %q", i, p.Action)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// retentionTable knows how to find and remove the expired records of one
+// table. Removing may leave files to delete once the transaction commits.
+type retentionTable struct {
+	expired   func(tx *gorm.DB, cutoff time.Time) ([]uint, error)
+	delete    func(tx *gorm.DB, ids []uint) ([]Attachment, error)
+	anonymize func(tx *gorm.DB, ids []uint) ([]Attachment, error) // nil if it cannot be anonymized
+}
+
+// recentAddresses returns the addresses with any contact since cutoff: a
+// contact request, ticket activity, an appointment, a quote or a login.
+// Communication data is kept for everyone in it, however old. Contact
+// requests and tickets are encrypted, so their addresses are read here
+// rather than in the query. The list is never empty: NOT IN an empty list
+// matches nothing.
+func recentAddresses(tx *gorm.DB, cutoff time.Time) ([]string, error) {
+	var addresses []string
+	err := tx.Raw(`SELECT lower(email) FROM appointments WHERE starts_at >= @cutoff OR updated_at >= @cutoff
+		UNION SELECT lower(email) FROM quotes WHERE updated_at >= @cutoff
+		UNION SELECT email FROM customers WHERE last_login_at >= @cutoff OR updated_at >= @cutoff`,
+		map[string]any{"cutoff": cutoff}).Scan(&addresses).Error
+	if err != nil {
+		return nil, err
+	}
+	var contacts []Contact
+	if err := tx.Select("email").Where("created_at >= ?", cutoff).Find(&contacts).Error; err != nil {
+		return nil, err
+	}
+	for _, c := range contacts {
+		addresses = append(addresses, normalizeEmail(c.Email))
+	}
+	var tickets []Ticket
+	if err := tx.Select("email").Where("updated_at >= ?", cutoff).Find(&tickets).Error; err != nil {
+		return nil, err
+	}
+	for _, t := range tickets {
+		addresses = append(addresses, normalizeEmail(t.Email))
+	}
+	return append(addresses, ""), nil
+}
+
+// expiredByAddress returns the IDs of model matching the conditions whose
+// address had no contact since cutoff.
+func expiredByAddress(tx *gorm.DB, model any, cutoff time.Time, query string, args ...any) ([]uint, error) {
+	recent, err := recentAddresses(tx, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	switch model.(type) {
+	case *Contact, *Ticket:
+		var indexes []string
+		for _, email := range recent {
+			indexes = append(indexes, dataKeys.emailIndexes(email)...)
+		}
+		return expiredIDs(tx, model, query+" AND email <> '' AND email_index NOT IN ?", append(args, indexes)...)
+	}
+	return expiredIDs(tx, model, query+" AND email <> '' AND lower(email) NOT IN ?", append(args, recent)...)
+}
+
+// expiredIDs returns the IDs of model matching the conditions.
+func expiredIDs(tx *gorm.DB, model any, query string, args ...any) ([]uint, error) {
+	var ids []uint
+	err := tx.Model(model).Where(query, args...).Order("id").Pluck("id", &ids).Error
+	return ids, err
+}
+
+// detachAttachments deletes the attachments linked to ids through column
+// and returns them, so their files can be removed. Files also linked
+// through other belong to a record that stays; they are only unlinked.
+func detachAttachments(tx *gorm.DB, column, other string, ids []uint) ([]Attachment, error) {
+	var shared []uint
+	err := tx.Model(&Attachment{}).Where(column+" IN ? AND "+other+" IS NOT NULL", ids).Pluck("id", &shared).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(shared) > 0 {
+		if err := tx.Model(&Attachment{}).Where("id IN ?", shared).Update(column, nil).Error; err != nil {
+			return nil, err
+		}
+	}
+	var files []Attachment
+	if err := tx.Where(column+" IN ?", ids).Find(&files).Error; err != nil || len(files) == 0 {
+		return nil, err
+	}
+	return files, tx.Delete(&files).Error
+}
+
+var retentionTables = map[string]retentionTable{
+	"contacts": {
+		expired: func(tx *gorm.DB, cutoff time.Time) ([]uint, error) {
+			return expiredByAddress(tx, &Contact{}, cutoff, "created_at < ?", cutoff)
+		},
+		delete: func(tx *gorm.DB, ids []uint) ([]Attachment, error) {
+			files, err := detachAttachments(tx, "contact_id", "ticket_message_id", ids)
+			if err != nil {
+				return nil, err
+			}
+			for _, model := range []any{&Quote{}, &Ticket{}, &Subscriber{}, &ConsentRecord{}} {
+				if err := tx.Model(model).Where("contact_id IN ?", ids).UpdateColumn("contact_id", nil).Error; err != nil {
+					return nil, err
+				}
+			}
+			return files, tx.Where("id IN ?", ids).Delete(&Contact{}).Error
+		},
+		anonymize: func(tx *gorm.DB, ids []uint) ([]Attachment, error) {
+			files, err := detachAttachments(tx, "contact_id", "ticket_message_id", ids)
+			if err != nil {
+				return nil, err
+			}
+			return files, tx.Model(&Contact{}).Where("id IN ?", ids).Updates(map[string]any{
+				"naam": "Verwijderd", "bedrijf": "", "email": "", "email_index": "", "telefoon": "", "bericht": "",
+			}).Error
+		},
+	},
+	"tickets": {
+		expired: func(tx *gorm.DB, cutoff time.Time) ([]uint, error) {
+			return expiredByAddress(tx, &Ticket{}, cutoff, "updated_at < ? AND status IN ?", cutoff, []string{ticketSolved, ticketClosed})
+		},
+		delete: func(tx *gorm.DB, ids []uint) ([]Attachment, error) {
+			var messages []uint
+			if err := tx.Model(&TicketMessage{}).Where("ticket_id IN ?", ids).Pluck("id", &messages).Error; err != nil {
+				return nil, err
+			}
+			files, err := detachAttachments(tx, "ticket_message_id", "contact_id", append(messages, 0))
+			if err != nil {
+				return nil, err
+			}
+			if err := tx.Where("ticket_id IN ?", ids).Delete(&TicketMessage{}).Error; err != nil {
+				return nil, err
+			}
+			return files, tx.Where("id IN ?", ids).Delete(&Ticket{}).Error
+		},
+		anonymize: func(tx *gorm.DB, ids []uint) ([]Attachment, error) {
+			var messages []uint
+			if err := tx.Model(&TicketMessage{}).Where("ticket_id IN ?", ids).Pluck("id", &messages).Error; err != nil {
+				return nil, err
+			}
+			files, err := detachAttachments(tx, "ticket_message_id", "contact_id", append(messages, 0))
+			if err != nil {
+				return nil, err
+			}
+			err = tx.Model(&TicketMessage{}).Where("ticket_id IN ?", ids).
+				Updates(map[string]any{"body": "", "author": gorm.Expr("CASE WHEN from_customer THEN 'Verwijderd' ELSE author END")}).Error
+			if err != nil {
+				return nil, err
+			}
+			return files, tx.Model(&Ticket{}).Where("id IN ?", ids).Updates(map[string]any{
+				"naam": "Verwijderd", "bedrijf": "", "email": "", "email_index": "", "telefoon": "", "onderwerp": "Verwijderd",
+			}).Error
+		},
+	},
+	"appointments": {
+		expired: func(tx *gorm.DB, cutoff time.Time) ([]uint, error) {
+			return expiredByAddress(tx, &Appointment{}, cutoff, "starts_at < ?", cutoff)
+		},
+		delete: func(tx *gorm.DB, ids []uint) ([]Attachment, error) {
+			return nil, tx.Where("id IN ?", ids).Delete(&Appointment{}).Error
+		},
+		anonymize: func(tx *gorm.DB, ids []uint) ([]Attachment, error) {
+			return nil, tx.Model(&Appointment{}).Where("id IN ?", ids).Updates(map[string]any{
+				"naam": "Verwijderd", "email": "", "telefoon": "", "adres": "", "bericht": "",
+			}).Error
+		},
+	},
+	"customers": {
+		expired: func(tx *gorm.DB, cutoff time.Time) ([]uint, error) {
+			recent, err := recentAddresses(tx, cutoff)
+			if err != nil {
+				return nil, err
+			}
+			return expiredIDs(tx, &Customer{}, "email NOT IN ?", recent)
+		},
+		delete: func(tx *gorm.DB, ids []uint) ([]Attachment, error) {
+			if err := tx.Where("kind = ? AND user_id IN ?", customerSessions.Name, ids).Delete(&Session{}).Error; err != nil {
+				return nil, err
+			}
+			return nil, tx.Where("id IN ?", ids).Delete(&Customer{}).Error
+		},
+	},
+	"quotes": {
+		expired: func(tx *gorm.DB, cutoff time.Time) ([]uint, error) {
+			return expiredIDs(tx, &Quote{}, "updated_at < ? AND email <> ''", cutoff)
+		},
+		delete: func(tx *gorm.DB, ids []uint) ([]Attachment, error) {
+			if err := tx.Where("quote_id IN ?", ids).Delete(&QuoteLine{}).Error; err != nil {
+				return nil, err
+			}
+			return nil, tx.Where("id IN ?", ids).Delete(&Quote{}).Error
+		},
+		anonymize: func(tx *gorm.DB, ids []uint) ([]Attachment, error) {
+			return nil, tx.Model(&Quote{}).Where("id IN ?", ids).Updates(map[string]any{
+				"naam": "Verwijderd", "bedrijf": "", "email": "", "adres": "", "accepted_by": "", "accepted_ip": "", "contact_id": nil,
+			}).Error
+		},
+	},
+	"outbox_messages": {
+		expired: func(tx *gorm.DB, cutoff time.Time) ([]uint, error) {
+			return expiredIDs(tx, &OutboxMessage{}, `created_at < ? AND status <> ? AND "to" <> ''`, cutoff, outboxQueued)
+		},
+		delete: func(tx *gorm.DB, ids []uint) ([]Attachment, error) {
+			if err := tx.Where("outbox_message_id IN ?", ids).Delete(&OutboxAttachment{}).Error; err != nil {
+				return nil, err
+			}
+			return nil, tx.Where("id IN ?", ids).Delete(&OutboxMessage{}).Error
+		},
+		anonymize: func(tx *gorm.DB, ids []uint) ([]Attachment, error) {
+			if err := tx.Where("outbox_message_id IN ?", ids).Delete(&OutboxAttachment{}).Error; err != nil {
+				return nil, err
+			}
+			return nil, tx.Model(&OutboxMessage{}).Where("id IN ?", ids).Updates(map[string]any{
+				"to": "", "text": "", "html": "", "headers": "", "last_error": "",
+			}).Error
+		},
+	},
+	"subscribers": {
+		// active subscribers stay until they unsubscribe
+		expired: func(tx *gorm.DB, cutoff time.Time) ([]uint, error) {
+			return expiredIDs(tx, &Subscriber{}, "status <> ? AND updated_at < ?", subscriberActive, cutoff)
+		},
+		delete: func(tx *gorm.DB, ids []uint) ([]Attachment, error) {
+			return nil, tx.Where("id IN ?", ids).Delete(&Subscriber{}).Error
+		},
+	},
+	"mail_events": {
+		expired: func(tx *gorm.DB, cutoff time.Time) ([]uint, error) {
+			return expiredIDs(tx, &MailEvent{}, "created_at < ? AND email <> ''", cutoff)
+		},
+		delete: func(tx *gorm.DB, ids []uint) ([]Attachment, error) {
+			return nil, tx.Where("id IN ?", ids).Delete(&MailEvent{}).Error
+		},
+		anonymize: func(tx *gorm.DB, ids []uint) ([]Attachment, error) {
+			return nil, tx.Model(&MailEvent{}).Where("id IN ?", ids).Updates(map[string]any{"email": "", "detail": ""}).Error
+		},
+	},
+	"data_requests": {
+		expired: func(tx *gorm.DB, cutoff time.Time) ([]uint, error) {
+			return expiredIDs(tx, &DataRequest{}, "created_at < ?", cutoff)
+		},
+		delete: func(tx *gorm.DB, ids []uint) ([]Attachment, error) {
+			return nil, tx.Where("id IN ?", ids).Delete(&DataRequest{}).Error
+		},
+		anonymize: func(tx *gorm.DB, ids []uint) ([]Attachment, error) {
+			return nil, tx.Model(&DataRequest{}).Where("id IN ?", ids).Update("ip", "").Error
+		},
+	},
+	"analytics_days": {
+		// visitor hashes and raw page views are gone after a day already
+		expired: func(tx *gorm.DB, cutoff time.Time) ([]uint, error) {
+			return expiredIDs(tx, &AnalyticsDay{}, "day < ?", analyticsDate(cutoff))
+		},
+		delete: func(tx *gorm.DB, ids []uint) ([]Attachment, error) {
+			return nil, tx.Where("id IN ?", ids).Delete(&AnalyticsDay{}).Error
+		},
+	},
+	"admin_login_attempts": {
+		expired: func(tx *gorm.DB, cutoff time.Time) ([]uint, error) {
+			return expiredIDs(tx, &AdminLoginAttempt{}, "created_at < ?", cutoff)
+		},
+		delete: func(tx *gorm.DB, ids []uint) ([]Attachment, error) {
+			return nil, tx.Where("id IN ?", ids).Delete(&AdminLoginAttempt{}).Error
+		},
+	},
+	"consent_records": {
+		// consent to the newsletter is proof for as long as it is sent
+		expired: func(tx *gorm.DB, cutoff time.Time) ([]uint, error) {
+			return expiredIDs(tx, &ConsentRecord{}, "created_at < ? AND NOT (purpose = ? AND email IN (?))", cutoff, consentNewsletter,
+				tx.Model(&Subscriber{}).Select("email").Where("status = ?", subscriberActive))
+		},
+		delete: func(tx *gorm.DB, ids []uint) ([]Attachment, error) {
+			return nil, tx.Where("id IN ?", ids).Delete(&ConsentRecord{}).Error
+		},
+	},
+}
+
+// retentionResult is what one policy did, or would do in a dry run.
+type retentionResult struct {
+	Policy retentionPolicy
+	Cutoff time.Time
+	IDs    []uint
+}
+
+func (r retentionResult) String() string {
+	return fmt.Sprintf("%s: %s %d (older than %s; %s)", r.Policy.Table, r.Policy.Action, len(r.IDs), r.Cutoff.Format(time.DateOnly), r.Policy.Reason)
+}
+
+// applyRetention applies every policy, each in its own transaction. With
+// dryRun set nothing is changed and the results say what would be.
+func applyRetention(now time.Time, dryRun bool) ([]retentionResult, error) {
+	var results []retentionResult
+	for _, p := range retention.Policies {
+		t := retentionTables[p.Table]
+		r := retentionResult{Policy: p, Cutoff: now.AddDate(0, -p.Months, 0).UTC()}
+		var files []Attachment
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			if r.IDs, err = t.expired(tx, r.Cutoff); err != nil || dryRun || len(r.IDs) == 0 {
+				return err
+			}
+			if p.Action == retentionAnonymize {
+				files, err = t.anonymize(tx, r.IDs)
+			} else {
+				files, err = t.delete(tx, r.IDs)
+			}
+			return err
+		})
+		if err != nil {
+			return results, fmt.Errorf("%s: %w", p.Table, err)
+		}
+		discardAttachments(files)
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// runRetention applies the retention policies until the process exits.
+func runRetention() {
+	for {
+		results, err := applyRetention(time.Now(), retention.DryRun)
+		if err != nil {
+			log.Printf("retention: %v", err)
+		}
+		for _, r := range results {
+			switch {
+			case len(r.IDs) == 0:
+			case retention.DryRun:
+				log.Printf("retention (dry run): %s, ids %v", r, r.IDs)
+			default:
+				log.Printf("retention: %s", r)
+			}
+		}
+		time.Sleep(retention.Interval.Duration)
+	}
+}
+
+// printRetentionReport prints what the policies would remove now.
+func printRetentionReport() error {
+	results, err := applyRetention(time.Now(), true)
+	for _, r := range results {
+		fmt.Println(r)
+		if len(r.IDs) > 0 {
+			fmt.Printf("  ids: %v\n", r.IDs)
+		}
+	}
+	return err
+}
+
+// retentionTableNames lists the tables policies can be set for.
+func retentionTableNames() []string {
+	names := make([]string, 0, len(retentionTables))
+	for name := range retentionTables {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefaultRetentionConfig(t *testing.T) {
+	var c retentionConfig
+	if err := json.Unmarshal(defaultRetentionConfig, &c); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRetentionConfigValidate(t *testing.T) {
+	valid := retentionPolicy{Table: "appointments", Months: 24, Action: retentionAnonymize}
+	tests := []struct {
+		name     string
+		interval time.Duration
+		policies []retentionPolicy
+		want     string // part of the error, empty if valid
+	}{
+		{"valid", time.Hour, []retentionPolicy{valid}, ""},
+		{"interval too short", time.Second, []retentionPolicy{valid}, "interval"},
+		{"unknown table", time.Hour, []retentionPolicy{{Table: "audit_events", Months: 12, Action: retentionDelete}}, "unknown table"},
+		{"twice", time.Hour, []retentionPolicy{valid, valid}, "more than one policy"},
+		{"no months", time.Hour, []retentionPolicy{{Table: "appointments", Action: retentionDelete}}, "months"},
+		{"unknown action", time.Hour, []retentionPolicy{{Table: "appointments", Months: 12, Action: "archive"}}, "action"},
+		{"cannot anonymize", time.Hour, []retentionPolicy{{Table: "customers", Months: 12, Action: retentionAnonymize}}, "only deleted"},
+	}
+	for _, tt := range tests {
+		c := retentionConfig{Interval: configDuration{tt.interval}, Policies: tt.policies}
+		err := c.validate()
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s: err = %v, want one about %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestApplyRetentionCutoffs(t *testing.T) {
+	testDB(t, &AdminLoginAttempt{}, &Appointment{}, &Contact{}, &Attachment{}, &Ticket{}, &TicketMessage{},
+		&Quote{}, &QuoteLine{}, &Customer{})
+	saved := retention
+	t.Cleanup(func() { retention = saved })
+	retention.Policies = []retentionPolicy{
+		{Table: "admin_login_attempts", Months: 6, Action: retentionDelete},
+		{Table: "appointments", Months: 24, Action: retentionAnonymize},
+	}
+
+	now := time.Date(2026, time.October, 18, 12, 0, 0, 0, time.UTC)
+	attempts := []AdminLoginAttempt{
+		{Username: "jan", IP: "192.0.2.1", CreatedAt: now.AddDate(0, -6, 0).Add(-time.Minute)},
+		{Username: "jan", IP: "192.0.2.1", CreatedAt: now.AddDate(0, -6, 0).Add(time.Minute)},
+	}
+	appointment := func(email string, starts time.Time) Appointment {
+		return Appointment{Type: "onsite", Technician: "jan", StartsAt: starts, EndsAt: starts.Add(time.Hour),
+			Naam: "Klant", Email: email, Status: appointmentConfirmed, CreatedAt: starts, UpdatedAt: starts}
+	}
+	appointments := []Appointment{
+		appointment("oud@example.nl", now.AddDate(-3, 0, 0)),
+		appointment("recent@example.nl", now.AddDate(-1, 0, 0)),
+		// old, but the customer had a quote since, so it is kept
+		appointment("terug@example.nl", now.AddDate(-3, 0, 0)),
+	}
+	quote := Quote{Number: "OF-2026-0001", Naam: "Klant", Email: "Terug@example.nl", Status: quoteSent,
+		ValidUntil: now, CreatedAt: now.AddDate(0, -1, 0), UpdatedAt: now.AddDate(0, -1, 0)}
+	for _, record := range []any{&attempts, &appointments, &quote} {
+		if err := db.Create(record).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, dryRun := range []bool{true, false} {
+		results, err := applyRetention(now, dryRun)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []struct {
+			cutoff time.Time
+			ids    []uint
+		}{
+			{time.Date(2026, time.April, 18, 12, 0, 0, 0, time.UTC), []uint{attempts[0].ID}},
+			{time.Date(2024, time.October, 18, 12, 0, 0, 0, time.UTC), []uint{appointments[0].ID}},
+		}
+		if len(results) != len(want) {
+			t.Fatalf("dry run %v: %d results, want %d", dryRun, len(results), len(want))
+		}
+		for i, r := range results {
+			if !r.Cutoff.Equal(want[i].cutoff) || !slices.Equal(r.IDs, want[i].ids) {
+				t.Errorf("dry run %v: %s: cutoff %s, ids %v, want %s, %v", dryRun, r.Policy.Table, r.Cutoff, r.IDs, want[i].cutoff, want[i].ids)
+			}
+		}
+
+		var n int64
+		if err := db.Model(&AdminLoginAttempt{}).Count(&n).Error; err != nil {
+			t.Fatal(err)
+		}
+		var kept []Appointment
+		if err := db.Order("id").Find(&kept).Error; err != nil {
+			t.Fatal(err)
+		}
+		anonymized := kept[0].Email == "" && kept[0].Naam == "Verwijderd"
+		if dryRun && (n != 2 || anonymized) {
+			t.Errorf("dry run changed records: %d attempts left, appointment %+v", n, kept[0])
+		}
+		if !dryRun && (n != 1 || !anonymized || kept[1].Email == "" || kept[2].Email == "") {
+			t.Errorf("after retention: %d attempts left, appointments %+v", n, kept)
+		}
+	}
+}
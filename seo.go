@@ -0,0 +1,79 @@
+package main
+
+// SEO holds the search engine and social sharing metadata of a page.
+type SEO struct {
+	Canonical     string
+	Robots        string
+	Keywords      string
+	OGType        string
+	OGTitle       string
+	OGDescription string
+	OGImage       string
+	TwitterCard   string
+	Alternates    []Alternate
+}
+
+// Alternate is a language variant of a page, emitted as hreflang link.
+type Alternate struct {
+	Lang string
+	URL  string
+}
+
+// siteLanguage is the language all pages are written in.
+const siteLanguage = "nl"
+
+const defaultKeywords = "ICT, Eerbeek, netwerk, security, website ontwerp, logo ontwerp, IoT, AI, computerhulp"
+
+// pageSEO returns the metadata of a registered page: defaults derived from
+// the page and the configured base URL, with any fields set in p.SEO taking
+// precedence.
+func pageSEO(p sitePage) SEO {
+	canonical := site.URL(p.Path)
+	seo := SEO{
+		Canonical:     canonical,
+		Robots:        "index, follow",
+		Keywords:      defaultKeywords,
+		OGType:        "website",
+		OGTitle:       p.Title + " - " + company.Name,
+		OGDescription: p.Description,
+		OGImage:       site.URL(ogImagePath(p)),
+		TwitterCard:   "summary_large_image",
+		Alternates: []Alternate{
+			{Lang: siteLanguage, URL: canonical},
+			{Lang: "x-default", URL: canonical},
+		},
+	}
+	if !site.Indexable() {
+		seo.Robots = "noindex, nofollow"
+	}
+
+	o := p.SEO
+	if o.Canonical != "" {
+		seo.Canonical = o.Canonical
+	}
+	if o.Robots != "" && site.Indexable() {
+		seo.Robots = o.Robots
+	}
+	if o.Keywords != "" {
+		seo.Keywords = o.Keywords
+	}
+	if o.OGType != "" {
+		seo.OGType = o.OGType
+	}
+	if o.OGTitle != "" {
+		seo.OGTitle = o.OGTitle
+	}
+	if o.OGDescription != "" {
+		seo.OGDescription = o.OGDescription
+	}
+	if o.OGImage != "" {
+		seo.OGImage = o.OGImage
+	}
+	if o.TwitterCard != "" {
+		seo.TwitterCard = o.TwitterCard
+	}
+	if o.Alternates != nil {
+		seo.Alternates = o.Alternates
+	}
+	return seo
+}
@@ -0,0 +1,94 @@
+package main
+
+// ServiceOffering is one of the concrete services within a Service.
+type ServiceOffering struct {
+	Name        string
+	Description string
+}
+
+// Service is one of the four service lines of ICT Eerbeek. The home page,
+// the diensten page, the footer and the structured data are all rendered
+// from services.
+type Service struct {
+	Slug      string // anchor on the diensten page
+	Name      string
+	Icon      string // icon name, see static/icons
+	Color     string // CSS colour variable, without the leading --
+	Summary   string
+	Intro     string
+	Offerings []ServiceOffering
+}
+
+// URL returns the path of the service on the diensten page.
+func (s Service) URL() string {
+	return "/diensten#" + s.Slug
+}
+
+var services = []Service{
+	{
+		Slug:    "netwerk-security",
+		Name:    "Netwerk & Security",
+		Icon:    "shield-alt",
+		Color:   "primary-blue",
+		Summary: "Professionele netwerkoplossingen en beveiligingssystemen om uw bedrijf te beschermen tegen cyberdreigingen en optimale prestaties te garanderen.",
+		Intro:   "In de digitale wereld van vandaag is een betrouwbaar netwerk en sterke beveiliging essentieel voor elk bedrijf. Wij bieden uitgebreide netwerkoplossingen en beveiligingsdiensten om uw bedrijf te beschermen.",
+		Offerings: []ServiceOffering{
+			{Name: "Netwerkinstallatie", Description: "Professionele installatie van bedrijfsnetwerken, inclusief bekabeling, switches, routers en access points voor optimale connectiviteit."},
+			{Name: "Firewall Configuratie", Description: "Implementatie en configuratie van geavanceerde firewalls om uw netwerk te beschermen tegen externe bedreigingen."},
+			{Name: "VPN Oplossingen", Description: "Veilige externe toegang tot uw bedrijfsnetwerk via Virtual Private Network oplossingen voor thuiswerkers."},
+			{Name: "Security Monitoring", Description: "24/7 monitoring van uw netwerk om verdachte activiteiten te detecteren en direct actie te ondernemen."},
+		},
+	},
+	{
+		Slug:    "website-logo",
+		Name:    "Website & Logo Ontwerp",
+		Icon:    "palette",
+		Color:   "primary-green",
+		Summary: "Creatieve en professionele website- en logo-ontwerpen die uw merk versterken en uw online aanwezigheid verbeteren.",
+		Intro:   "Uw online aanwezigheid is cruciaal voor het succes van uw bedrijf. Wij creëren professionele websites en memorabele logo's die uw merk versterken en klanten aantrekken.",
+		Offerings: []ServiceOffering{
+			{Name: "Responsive Webdesign", Description: "Moderne websites die perfect werken op alle apparaten, van desktop tot smartphone, met focus op gebruikerservaring."},
+			{Name: "E-commerce Oplossingen", Description: "Volledige webshops met betalingssystemen, voorraadbeheersystemen en klantenportalen voor online verkoop."},
+			{Name: "Logo & Branding", Description: "Creatieve logo-ontwerpen en complete huisstijlen die uw bedrijf onderscheiden van de concurrentie."},
+			{Name: "SEO Optimalisatie", Description: "Zoekmachine optimalisatie om uw website beter vindbaar te maken in Google en andere zoekmachines."},
+		},
+	},
+	{
+		Slug:    "iot-ai",
+		Name:    "IoT & AI Oplossingen",
+		Icon:    "microchip",
+		Color:   "primary-purple",
+		Summary: "Innovatieve Internet of Things en Artificial Intelligence oplossingen om uw bedrijfsprocessen te automatiseren en optimaliseren.",
+		Intro:   "Stap in de toekomst met onze innovatieve Internet of Things en Artificial Intelligence oplossingen. Automatiseer processen, verzamel waardevolle data en optimaliseer uw bedrijfsvoering.",
+		Offerings: []ServiceOffering{
+			{Name: "Smart Building Systemen", Description: "Intelligente gebouwbeheersystemen voor verlichting, klimaatbeheersing en beveiliging met IoT-sensoren."},
+			{Name: "Industriële Automatisering", Description: "IoT-oplossingen voor productieprocessen, kwaliteitscontrole en voorspellend onderhoud in de industrie."},
+			{Name: "AI Chatbots", Description: "Intelligente chatbots voor klantenservice die 24/7 beschikbaar zijn en veel voorkomende vragen automatisch beantwoorden."},
+			{Name: "Data Analytics", Description: "AI-gedreven data-analyse om patronen te herkennen, trends te voorspellen en betere bedrijfsbeslissingen te nemen."},
+		},
+	},
+	{
+		Slug:    "computerhulp",
+		Name:    "All-round Computerhulp",
+		Icon:    "tools",
+		Color:   "primary-brown",
+		Summary: "Uitgebreide computerondersteuning voor particulieren en bedrijven, van hardware reparaties tot software installaties en training.",
+		Intro:   "Van hardware reparaties tot software installaties, wij bieden uitgebreide computerondersteuning voor particulieren en bedrijven. Geen probleem is te klein of te groot.",
+		Offerings: []ServiceOffering{
+			{Name: "Hardware Reparatie", Description: "Reparatie van computers, laptops, printers en andere hardware met snelle diagnose en eerlijke prijzen."},
+			{Name: "Software Installatie", Description: "Installatie en configuratie van besturingssystemen, applicaties en drivers voor optimale prestaties."},
+			{Name: "Data Recovery", Description: "Herstel van verloren data van harde schijven, USB-sticks en andere opslagmedia met geavanceerde technieken."},
+			{Name: "IT Training", Description: "Persoonlijke training en workshops om uw digitale vaardigheden te verbeteren en efficiënter te werken."},
+		},
+	},
+}
+
+// serviceBySlug returns the service with the given slug.
+func serviceBySlug(slug string) (Service, bool) {
+	for _, s := range services {
+		if s.Slug == slug {
+			return s, true
+		}
+	}
+	return Service{}, false
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Session is a server-side login session. The cookie holds a random token;
+// only its hash is stored, so a copy of the database cannot be used to
+// take over sessions.
+type Session struct {
+	ID         string    `gorm:"primaryKey"` // SHA-256 of the cookie token
+	Kind       string    `gorm:"not null;index"`
+	UserID     uint      `gorm:"not null;index"`
+	ExpiresAt  time.Time `gorm:"not null;index"`
+	LastSeenAt time.Time
+	IP         string
+	UserAgent  string
+	CreatedAt  time.Time
+}
+
+// sessionKind describes one kind of session: who logs in, the cookie it
+// uses and how long it lasts without activity.
+type sessionKind struct {
+	Name     string
+	Cookie   string
+	Path     string
+	Idle     time.Duration
+	SameSite http.SameSite // default lax
+}
+
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// secureCookies reports whether cookies get the Secure flag; only when the
+// site is served over https, so logging in works on a plain http dev server.
+func secureCookies() bool {
+	return strings.HasPrefix(site.BaseURL, "https://")
+}
+
+// start creates a session for user and sets its cookie.
+func (k sessionKind) start(c *gin.Context, userID uint) error {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	now := time.Now().UTC()
+	s := Session{
+		ID:         hashSessionToken(token),
+		Kind:       k.Name,
+		UserID:     userID,
+		ExpiresAt:  now.Add(k.Idle),
+		LastSeenAt: now,
+		IP:         c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+	}
+	if err := db.Create(&s).Error; err != nil {
+		return err
+	}
+	k.setCookie(c, token, int(k.Idle.Seconds()))
+	return nil
+}
+
+func (k sessionKind) setCookie(c *gin.Context, value string, maxAge int) {
+	sameSite := k.SameSite
+	if sameSite == 0 {
+		sameSite = http.SameSiteLaxMode
+	}
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     k.Cookie,
+		Value:    value,
+		Path:     k.Path,
+		MaxAge:   maxAge,
+		Secure:   secureCookies(),
+		HttpOnly: true,
+		SameSite: sameSite,
+	})
+}
+
+// current returns the session of the request, if any. Sessions are
+// extended while in use, at most once a minute to spare the database.
+func (k sessionKind) current(c *gin.Context) (Session, bool) {
+	token, err := c.Cookie(k.Cookie)
+	if err != nil || token == "" {
+		return Session{}, false
+	}
+	var s Session
+	err = db.Where("id = ? AND kind = ? AND expires_at > ?", hashSessionToken(token), k.Name, time.Now().UTC()).
+		First(&s).Error
+	if err != nil {
+		return Session{}, false
+	}
+	if now := time.Now().UTC(); now.Sub(s.LastSeenAt) > time.Minute {
+		s.LastSeenAt, s.ExpiresAt = now, now.Add(k.Idle)
+		db.Model(&s).Updates(map[string]any{"last_seen_at": s.LastSeenAt, "expires_at": s.ExpiresAt})
+		k.setCookie(c, token, int(k.Idle.Seconds()))
+	}
+	return s, true
+}
+
+// end removes the session of the request and its cookie.
+func (k sessionKind) end(c *gin.Context) error {
+	k.setCookie(c, "", -1)
+	token, err := c.Cookie(k.Cookie)
+	if err != nil {
+		return nil
+	}
+	return db.Where("id = ?", hashSessionToken(token)).Delete(&Session{}).Error
+}
+
+// endAll removes every session of user, such as after a password change.
+func (k sessionKind) endAll(tx *gorm.DB, userID uint) error {
+	return tx.Where("kind = ? AND user_id = ?", k.Name, userID).Delete(&Session{}).Error
+}
+
+// deleteExpiredSessions removes sessions that can no longer be used.
+func deleteExpiredSessions() error {
+	return db.Where("expires_at < ?", time.Now().UTC()).Delete(&Session{}).Error
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc        string `xml:"loc"`
+	LastMod    string `xml:"lastmod,omitempty"`
+	ChangeFreq string `xml:"changefreq,omitempty"`
+	Priority   string `xml:"priority"`
+}
+
+// sitemapHandler serves /sitemap.xml listing every registered page.
+func sitemapHandler(templateFS fs.FS) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		set := sitemapURLSet{Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+		for _, p := range sitePages {
+			u := sitemapURL{
+				Loc:        site.URL(p.Path),
+				ChangeFreq: p.ChangeFreq,
+				Priority:   fmt.Sprintf("%.1f", sitemapPriority[p.Kind]),
+			}
+			if mod := contentModTime(templateFS, p); !mod.IsZero() {
+				u.LastMod = mod.UTC().Format("2006-01-02")
+			}
+			set.URLs = append(set.URLs, u)
+		}
+
+		out, err := xml.MarshalIndent(set, "", "  ")
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.Data(http.StatusOK, "application/xml; charset=utf-8", append([]byte(xml.Header), out...))
+	}
+}
+
+// robotsDisallow lists endpoints that are never useful in search results.
+var robotsDisallow = []string{"/chat", "/img/", "/api/", "/afspraak/", "/offerte", "/ticket", "/account", "/nieuwsbrief/", "/privacy/gegevens", "/cookies", "/admin/"}
+
+// robotsHandler serves /robots.txt. Anything but production blocks all
+// crawling so staging copies never end up in search results.
+func robotsHandler(c *gin.Context) {
+	var b strings.Builder
+	b.WriteString("User-agent: *\n")
+	if !site.Indexable() {
+		b.WriteString("Disallow: /\n")
+	} else {
+		for _, path := range robotsDisallow {
+			fmt.Fprintf(&b, "Disallow: %s\n", path)
+		}
+		fmt.Fprintf(&b, "\nSitemap: %s\n", site.URL("/sitemap.xml"))
+	}
+	c.String(http.StatusOK, b.String())
+}
+
+// noIndexOutsideProduction asks crawlers that ignore robots.txt not to index
+// non-production deployments either.
+func noIndexOutsideProduction(c *gin.Context) {
+	if !site.Indexable() {
+		c.Header("X-Robots-Tag", "noindex, nofollow")
+	}
+	c.Next()
+}
@@ -0,0 +1,27 @@
+// Admin area helpers
+document.addEventListener('DOMContentLoaded', function() {
+    // Quote editor: add an empty line item
+    const addLine = document.getElementById('add-line');
+    const lines = document.querySelector('#quote-lines tbody');
+    if (addLine && lines) {
+        addLine.addEventListener('click', function() {
+            const last = lines.querySelector('tr:last-child');
+            const row = last.cloneNode(true);
+            row.querySelectorAll('input').forEach(input => {
+                input.value = input.name === 'aantal' ? '1' : '';
+            });
+            row.querySelector('.amount').textContent = '';
+            lines.appendChild(row);
+            row.querySelector('input').focus();
+        });
+    }
+
+    // Ask before forms that cannot be undone, such as sending a campaign
+    document.querySelectorAll('form[data-confirm]').forEach(form => {
+        form.addEventListener('submit', function(event) {
+            if (!window.confirm(form.dataset.confirm)) {
+                event.preventDefault();
+            }
+        });
+    });
+});
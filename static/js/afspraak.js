@@ -0,0 +1,95 @@
+// Appointment booking: load free slots for the chosen type and reserve one.
+document.addEventListener('DOMContentLoaded', function() {
+    const form = document.getElementById('appointment-form');
+    const slotsContainer = document.getElementById('appointment-slots');
+    if (!form || !slotsContainer) {
+        return;
+    }
+
+    function loadSlots() {
+        const type = form.querySelector('input[name="type"]:checked');
+        if (!type) {
+            return;
+        }
+        slotsContainer.innerHTML = '<p>Beschikbare tijden worden geladen...</p>';
+        fetch('/api/afspraak/slots?type=' + encodeURIComponent(type.value))
+            .then(response => response.json())
+            .then(result => renderSlots(result.days || []))
+            .catch(error => {
+                console.error('Error:', error);
+                slotsContainer.innerHTML = '<p>De beschikbare tijden konden niet worden geladen. Probeer het later opnieuw.</p>';
+            });
+    }
+
+    function renderSlots(days) {
+        slotsContainer.innerHTML = '';
+        if (days.length === 0) {
+            slotsContainer.innerHTML = '<p>Er zijn op dit moment geen tijden beschikbaar. Neem contact met ons op.</p>';
+            return;
+        }
+        days.forEach(day => {
+            const group = document.createElement('fieldset');
+            group.className = 'appointment-day';
+            const legend = document.createElement('legend');
+            legend.textContent = day.label;
+            group.appendChild(legend);
+            day.slots.forEach(slot => {
+                const label = document.createElement('label');
+                label.className = 'appointment-slot';
+                const input = document.createElement('input');
+                input.type = 'radio';
+                input.name = 'start';
+                input.value = slot.start;
+                input.required = true;
+                label.appendChild(input);
+                label.appendChild(document.createTextNode(slot.label));
+                group.appendChild(label);
+            });
+            slotsContainer.appendChild(group);
+        });
+    }
+
+    form.querySelectorAll('input[name="type"]').forEach(input => {
+        input.addEventListener('change', loadSlots);
+    });
+    loadSlots();
+
+    form.addEventListener('submit', function(e) {
+        e.preventDefault();
+        const data = Object.fromEntries(new FormData(this));
+        data.privacy = this.querySelector('input[name="privacy"]').checked;
+        if (!data.start) {
+            showMessage('Kies een tijdstip.', 'error', 'appointment-form');
+            return;
+        }
+
+        const submitButton = this.querySelector('.submit-button');
+        submitButton.disabled = true;
+        fetch('/afspraak', {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+            },
+            body: JSON.stringify(data)
+        })
+        .then(response => response.json().then(result => ({ok: response.ok, status: response.status, result: result})))
+        .then(({ok, status, result}) => {
+            if (ok) {
+                showMessage(result.message, 'success', 'appointment-form');
+                this.reset();
+            } else {
+                showMessage(result.error || 'Er is een fout opgetreden. Probeer het later opnieuw.', 'error', 'appointment-form');
+            }
+            if (ok || status === 409) {
+                loadSlots();
+            }
+        })
+        .catch(error => {
+            console.error('Error:', error);
+            showMessage('Er is een fout opgetreden. Probeer het later opnieuw.', 'error', 'appointment-form');
+        })
+        .finally(() => {
+            submitButton.disabled = false;
+        });
+    });
+});
@@ -71,21 +71,21 @@ document.addEventListener('DOMContentLoaded', function() {
             submitButton.textContent = 'Verzenden...';
             submitButton.disabled = true;
             
-            // Send form data
+            // Send form data; multipart so attachments come along
             fetch('/contact', {
                 method: 'POST',
-                headers: {
-                    'Content-Type': 'application/json',
-                },
-                body: JSON.stringify(data)
+                body: formData
             })
             .then(response => response.json())
             .then(result => {
-                if (result.success) {
+                if (result.ticket) {
+                    showMessage('Bedankt voor uw bericht! Uw ticketnummer is ' + result.ticket + '. U ontvangt een e-mail met een link om uw ticket te volgen.', 'success');
+                    this.reset();
+                } else if (result.message) {
                     showMessage('Bedankt voor uw bericht! We nemen zo spoedig mogelijk contact met u op.', 'success');
                     this.reset();
                 } else {
-                    showMessage('Er is een fout opgetreden. Probeer het later opnieuw.', 'error');
+                    showMessage(result.error || 'Er is een fout opgetreden. Probeer het later opnieuw.', 'error');
                 }
             })
             .catch(error => {
@@ -130,7 +130,7 @@ function isValidEmail(email) {
     return emailRegex.test(email);
 }
 
-function showMessage(message, type) {
+function showMessage(message, type, formId) {
     // Remove existing messages
     const existingMessages = document.querySelectorAll('.form-message');
     existingMessages.forEach(msg => msg.remove());
@@ -153,7 +153,7 @@ function showMessage(message, type) {
     `;
     
     // Insert message
-    const form = document.getElementById('contact-form');
+    const form = document.getElementById(formId || 'contact-form');
     if (form) {
         form.insertBefore(messageDiv, form.firstChild);
         
@@ -172,7 +172,8 @@ window.addEventListener('beforeunload', function() {
 // Back to top button
 function createBackToTopButton() {
     const button = document.createElement('button');
-    button.innerHTML = '<i class="fas fa-arrow-up"></i>';
+    button.innerHTML = '<svg class="icon" aria-hidden="true" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><line x1="12" y1="19" x2="12" y2="5"/><polyline points="5 12 12 5 19 12"/></svg>';
+    button.setAttribute('aria-label', 'Terug naar boven');
     button.className = 'back-to-top';
     button.style.cssText = `
         position: fixed;
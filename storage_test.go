@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testStorageRoundTrip stores, reads, replaces and deletes a file in s.
+func testStorageRoundTrip(t *testing.T, s Storage) {
+	t.Helper()
+	ctx := context.Background()
+	key := newStorageKey("test")
+	read := func() (string, error) {
+		r, err := s.Open(ctx, key)
+		if err != nil {
+			return "", err
+		}
+		defer r.Close()
+		b, err := io.ReadAll(r)
+		return string(b), err
+	}
+
+	for _, content := range []string{"eerste versie", "tweede"} {
+		if err := s.Put(ctx, key, strings.NewReader(content), int64(len(content)), "text/plain"); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := read(); err != nil || got != content {
+			t.Errorf("after Put(%q): read %q, %v", content, got, err)
+		}
+	}
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := read(); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("after Delete: err = %v, want %v", err, fs.ErrNotExist)
+	}
+	if err := s.Delete(ctx, key); err != nil {
+		t.Errorf("deleting a missing file: %v", err)
+	}
+
+	for _, bad := range []string{"", "../buiten", "/absoluut", `test\pad`, "test/../../buiten"} {
+		if err := s.Put(ctx, bad, strings.NewReader("x"), 1, "text/plain"); !errors.Is(err, errInvalidKey) {
+			t.Errorf("Put(%q): err = %v, want %v", bad, err, errInvalidKey)
+		}
+		if _, err := s.Open(ctx, bad); !errors.Is(err, errInvalidKey) {
+			t.Errorf("Open(%q): err = %v, want %v", bad, err, errInvalidKey)
+		}
+		if err := s.Delete(ctx, bad); !errors.Is(err, errInvalidKey) {
+			t.Errorf("Delete(%q): err = %v, want %v", bad, err, errInvalidKey)
+		}
+	}
+}
+
+func TestLocalStorage(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newLocalStorage(filepath.Join(dir, "uploads"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStorageRoundTrip(t, s)
+
+	// nothing is left behind, not even the temporary files of Put
+	err = filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			t.Errorf("file left behind: %s", name)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestS3Storage runs against a real bucket, such as a local MinIO, when
+// S3_ENDPOINT and the other settings of initStorage are set.
+func TestS3Storage(t *testing.T) {
+	endpoint := os.Getenv("S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("S3_ENDPOINT not set")
+	}
+	bucket := os.Getenv("S3_BUCKET")
+	if bucket == "" {
+		bucket = "ict-eerbeek-test"
+	}
+	s, err := newS3Storage(endpoint, bucket, os.Getenv("S3_REGION"),
+		os.Getenv("S3_ACCESS_KEY"), os.Getenv("S3_SECRET_KEY"), os.Getenv("S3_INSECURE") != "true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStorageRoundTrip(t, s)
+}
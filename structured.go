@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+)
+
+// The types below are the subset of schema.org we emit as JSON-LD.
+
+type ldPostalAddress struct {
+	Type            string `json:"@type"`
+	StreetAddress   string `json:"streetAddress,omitempty"`
+	PostalCode      string `json:"postalCode,omitempty"`
+	AddressLocality string `json:"addressLocality"`
+	AddressRegion   string `json:"addressRegion,omitempty"`
+	AddressCountry  string `json:"addressCountry"`
+}
+
+type ldGeo struct {
+	Type      string  `json:"@type"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+type ldOpeningHours struct {
+	Type      string   `json:"@type"`
+	DayOfWeek []string `json:"dayOfWeek"`
+	Opens     string   `json:"opens"`
+	Closes    string   `json:"closes"`
+}
+
+type ldLocalBusiness struct {
+	Type         string           `json:"@type"`
+	ID           string           `json:"@id"`
+	Name         string           `json:"name"`
+	Description  string           `json:"description"`
+	URL          string           `json:"url"`
+	Logo         string           `json:"logo,omitempty"`
+	Image        string           `json:"image,omitempty"`
+	Email        string           `json:"email"`
+	Telephone    string           `json:"telephone"`
+	Address      ldPostalAddress  `json:"address"`
+	Geo          ldGeo            `json:"geo"`
+	OpeningHours []ldOpeningHours `json:"openingHoursSpecification"`
+	SameAs       []string         `json:"sameAs,omitempty"`
+	VATID        string           `json:"vatID,omitempty"`
+	Identifier   *ldPropertyValue `json:"identifier,omitempty"`
+}
+
+type ldPropertyValue struct {
+	Type       string `json:"@type"`
+	PropertyID string `json:"propertyID"`
+	Value      string `json:"value"`
+}
+
+type ldRef struct {
+	ID string `json:"@id"`
+}
+
+type ldPlace struct {
+	Type string `json:"@type"`
+	Name string `json:"name"`
+}
+
+type ldService struct {
+	Type        string          `json:"@type"`
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	URL         string          `json:"url"`
+	Provider    ldRef           `json:"provider"`
+	AreaServed  ldPlace         `json:"areaServed"`
+	Catalog     *ldOfferCatalog `json:"hasOfferCatalog,omitempty"`
+}
+
+type ldOfferCatalog struct {
+	Type  string    `json:"@type"`
+	Name  string    `json:"name"`
+	Items []ldOffer `json:"itemListElement"`
+}
+
+type ldOffer struct {
+	Type        string    `json:"@type"`
+	ItemOffered ldService `json:"itemOffered"`
+}
+
+type ldListItem struct {
+	Type     string `json:"@type"`
+	Position int    `json:"position"`
+	Name     string `json:"name"`
+	Item     string `json:"item"`
+}
+
+type ldBreadcrumbList struct {
+	Type  string       `json:"@type"`
+	Items []ldListItem `json:"itemListElement"`
+}
+
+type ldAnswer struct {
+	Type string `json:"@type"`
+	Text string `json:"text"`
+}
+
+type ldQuestion struct {
+	Type   string   `json:"@type"`
+	Name   string   `json:"name"`
+	Answer ldAnswer `json:"acceptedAnswer"`
+}
+
+type ldFAQPage struct {
+	Type      string       `json:"@type"`
+	Questions []ldQuestion `json:"mainEntity"`
+}
+
+// businessID identifies the LocalBusiness node other nodes refer to.
+func businessID() string {
+	return site.URL("/#organization")
+}
+
+func localBusinessLD(p CompanyProfile, logoURL string) ldLocalBusiness {
+	lb := ldLocalBusiness{
+		Type:        "LocalBusiness",
+		ID:          businessID(),
+		Name:        p.Name,
+		Description: p.Description,
+		URL:         site.URL("/"),
+		Logo:        logoURL,
+		Image:       logoURL,
+		Email:       p.Email,
+		Telephone:   p.Phone,
+		Address: ldPostalAddress{
+			Type:            "PostalAddress",
+			StreetAddress:   p.Address.Street,
+			PostalCode:      p.Address.PostalCode,
+			AddressLocality: p.Address.Locality,
+			AddressRegion:   p.Address.Region,
+			AddressCountry:  p.Address.Country,
+		},
+		Geo:   ldGeo{Type: "GeoCoordinates", Latitude: p.Geo.Latitude, Longitude: p.Geo.Longitude},
+		VATID: p.BTW,
+	}
+	for _, l := range p.SocialLinks {
+		lb.SameAs = append(lb.SameAs, l.URL)
+	}
+	if p.KvK != "" {
+		lb.Identifier = &ldPropertyValue{Type: "PropertyValue", PropertyID: "KvK", Value: p.KvK}
+	}
+	for _, h := range p.OpeningHours {
+		spec := ldOpeningHours{Type: "OpeningHoursSpecification", Opens: h.Opens, Closes: h.Closes}
+		for _, d := range h.Days {
+			spec.DayOfWeek = append(spec.DayOfWeek, d.String())
+		}
+		lb.OpeningHours = append(lb.OpeningHours, spec)
+	}
+	return lb
+}
+
+func serviceLD(s Service, area string) ldService {
+	ld := ldService{
+		Type:        "Service",
+		Name:        s.Name,
+		Description: s.Intro,
+		URL:         site.URL(s.URL()),
+		Provider:    ldRef{ID: businessID()},
+		AreaServed:  ldPlace{Type: "City", Name: area},
+		Catalog:     &ldOfferCatalog{Type: "OfferCatalog", Name: s.Name},
+	}
+	for _, o := range s.Offerings {
+		ld.Catalog.Items = append(ld.Catalog.Items, ldOffer{
+			Type: "Offer",
+			ItemOffered: ldService{
+				Type:        "Service",
+				Name:        o.Name,
+				Description: o.Description,
+				URL:         ld.URL,
+				Provider:    ld.Provider,
+				AreaServed:  ld.AreaServed,
+			},
+		})
+	}
+	return ld
+}
+
+func breadcrumbLD(p PageData) ldBreadcrumbList {
+	list := ldBreadcrumbList{
+		Type:  "BreadcrumbList",
+		Items: []ldListItem{{Type: "ListItem", Position: 1, Name: "Home", Item: site.URL("/")}},
+	}
+	if p.Path != "/" {
+		list.Items = append(list.Items, ldListItem{Type: "ListItem", Position: 2, Name: p.Title, Item: site.URL(p.Path)})
+	}
+	return list
+}
+
+func faqLD(faqs []FAQ) ldFAQPage {
+	page := ldFAQPage{Type: "FAQPage"}
+	for _, f := range faqs {
+		page.Questions = append(page.Questions, ldQuestion{
+			Type:   "Question",
+			Name:   f.Question,
+			Answer: ldAnswer{Type: "Answer", Text: f.Answer},
+		})
+	}
+	return page
+}
+
+// structuredDataFunc returns the structuredData template function. It emits
+// the JSON-LD graph for a page: the LocalBusiness on every page, the
+// services on service pages, a breadcrumb trail and, where the page has
+// one, its FAQ.
+func structuredDataFunc(assets *assetManifest) func(PageData) template.JS {
+	return func(p PageData) template.JS {
+		graph := []any{localBusinessLD(company, site.URL(assets.URL(company.Logo)))}
+		if p.Kind == kindService {
+			for _, s := range services {
+				graph = append(graph, serviceLD(s, company.Address.Locality))
+			}
+		}
+		graph = append(graph, breadcrumbLD(p))
+		if len(p.FAQs) > 0 {
+			graph = append(graph, faqLD(p.FAQs))
+		}
+
+		// json.Marshal escapes <, > and &, so the result cannot close the
+		// surrounding script element.
+		b, err := json.Marshal(map[string]any{
+			"@context": "https://schema.org",
+			"@graph":   graph,
+		})
+		if err != nil {
+			log.Printf("structured data: %v", err)
+			return ""
+		}
+		return template.JS(b)
+	}
+}
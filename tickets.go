@@ -0,0 +1,515 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Ticket is a support request from an existing customer. Contact form
+// submissions about "ondersteuning" become tickets; the conversation that
+// follows is kept as messages on the ticket.
+type Ticket struct {
+	ID          uint            `gorm:"primaryKey"`
+	Number      string          `gorm:"not null;uniqueIndex"` // e.g. TK-2026-0042
+	ContactID   *uint           `gorm:"index"`                // the contact request that opened it, if any
+	Naam        string          `gorm:"not null;serializer:encrypted"`
+	Bedrijf     string          `gorm:"serializer:encrypted"`
+	Email       string          `gorm:"not null;serializer:encrypted"`
+	EmailIndex  string          `gorm:"index"` // blind index of Email, see byEmail
+	Telefoon    string          `gorm:"serializer:encrypted"`
+	Onderwerp   string          `gorm:"not null;serializer:encrypted"` // first line of the message
+	Status      string          `gorm:"not null;index"`
+	Priority    string          `gorm:"not null"` // one of the responseTargets urgencies
+	ReactieVoor time.Time       // response deadline for the priority
+	Messages    []TicketMessage `gorm:"constraint:OnDelete:CASCADE"`
+	ClosedAt    *time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// TicketMessage is one entry in a ticket's thread. Internal notes are only
+// shown to staff.
+type TicketMessage struct {
+	ID           uint         `gorm:"primaryKey"`
+	TicketID     uint         `gorm:"not null;index"`
+	Author       string       `gorm:"not null;serializer:encrypted"`
+	FromCustomer bool         `gorm:"not null"`
+	Internal     bool         `gorm:"not null"`
+	Body         string       `gorm:"not null;serializer:encrypted"`
+	Attachments  []Attachment `gorm:"constraint:OnDelete:CASCADE"`
+	CreatedAt    time.Time
+}
+
+// Ticket statuses. A customer reply reopens a ticket that waits for them
+// or was solved; closed tickets stay closed.
+const (
+	ticketNew      = "nieuw"
+	ticketOpen     = "open"
+	ticketWaiting  = "wachtend" // waiting for the customer
+	ticketSolved   = "opgelost"
+	ticketClosed   = "gesloten"
+	ticketSubject  = "ondersteuning" // contact form Onderwerp that opens a ticket
+	tokenTicket    = "ticket"
+	ticketLinkDays = 180
+)
+
+// ticketStatuses lists the statuses in the order staff pick them.
+var ticketStatuses = []string{ticketNew, ticketOpen, ticketWaiting, ticketSolved, ticketClosed}
+
+// priorities lists the ticket priorities from low to high; they are the
+// urgencies of the contact form.
+var priorities = []string{"laag", "normaal", "hoog", "urgent"}
+
+// ticketPriority maps a contact form urgency to a priority.
+func ticketPriority(urgency string) string {
+	if slices.Contains(priorities, urgency) {
+		return urgency
+	}
+	return "normaal"
+}
+
+// Open reports whether the ticket still expects work.
+func (t Ticket) Open() bool {
+	return t.Status != ticketSolved && t.Status != ticketClosed
+}
+
+// Overdue reports whether the response deadline passed without a reply.
+func (t Ticket) Overdue() bool {
+	return t.Status == ticketNew && !t.ReactieVoor.IsZero() && time.Now().After(t.ReactieVoor)
+}
+
+// PublicMessages returns the thread as the customer sees it.
+func (t Ticket) PublicMessages() []TicketMessage {
+	var messages []TicketMessage
+	for _, m := range t.Messages {
+		if !m.Internal {
+			messages = append(messages, m)
+		}
+	}
+	return messages
+}
+
+// nextTicketNumber returns the next number in the current year's series.
+// Numbers are padded to four digits, so past 9999 they get longer: the
+// longest number is sorted first, as "…-10000" sorts below "…-9999".
+func nextTicketNumber(tx *gorm.DB) (string, error) {
+	prefix := fmt.Sprintf("TK-%d-", time.Now().In(openingHoursLocation).Year())
+	var last Ticket
+	err := tx.Where("number LIKE ?", prefix+"%").Order("length(number) DESC, number DESC").First(&last).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return prefix + "0001", nil
+	} else if err != nil {
+		return "", err
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(last.Number, prefix))
+	if err != nil {
+		return "", fmt.Errorf("last ticket number %q: %w", last.Number, err)
+	}
+	return fmt.Sprintf("%s%04d", prefix, n+1), nil
+}
+
+// ticketLink returns the link a customer follows to their ticket.
+func ticketLink(t Ticket) string {
+	return site.URL("/ticket?token=" + signID(tokenTicket, t.ID, ticketLinkDays*24*time.Hour))
+}
+
+// openTicket creates a ticket for a contact request and mails the customer
+// the link to it. Files sent with the request are added to the first
+// message.
+func openTicket(tx *gorm.DB, contact Contact) (Ticket, error) {
+	number, err := nextTicketNumber(tx)
+	if err != nil {
+		return Ticket{}, err
+	}
+	contactID := contact.ID
+	t := Ticket{
+		Number:      number,
+		ContactID:   &contactID,
+		Naam:        contact.Naam,
+		Bedrijf:     contact.Bedrijf,
+		Email:       contact.Email,
+		Telefoon:    contact.Telefoon,
+		Onderwerp:   ticketTitle(contact.Bericht),
+		Status:      ticketNew,
+		Priority:    ticketPriority(contact.Urgentie),
+		ReactieVoor: contact.ReactieVoor,
+		Messages: []TicketMessage{{
+			Author:       contact.Naam,
+			FromCustomer: true,
+			Body:         contact.Bericht,
+		}},
+	}
+	if err := tx.Create(&t).Error; err != nil {
+		return Ticket{}, err
+	}
+	err = tx.Model(&Attachment{}).Where("contact_id = ?", contact.ID).
+		Update("ticket_message_id", t.Messages[0].ID).Error
+	if err != nil {
+		return Ticket{}, err
+	}
+	return t, queueMail(tx, ticketOpenedMail(t))
+}
+
+// ticketTitle uses the first line of the message as the ticket's subject.
+func ticketTitle(message string) string {
+	title, _, _ := strings.Cut(strings.TrimSpace(message), "\n")
+	if r := []rune(title); len(r) > 80 {
+		title = string(r[:77]) + "..."
+	}
+	if title == "" {
+		title = "Technische ondersteuning"
+	}
+	return title
+}
+
+// findTicket loads a ticket with its thread.
+func findTicket(id uint) (Ticket, error) {
+	var t Ticket
+	err := db.Preload("Messages", func(tx *gorm.DB) *gorm.DB { return tx.Order("created_at, id") }).
+		Preload("Messages.Attachments").First(&t, id).Error
+	return t, err
+}
+
+// loadTicket loads the ticket named by the :id parameter, or responds with
+// 404.
+func loadTicket(c *gin.Context) (Ticket, bool) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	t, err := findTicket(uint(id))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return t, false
+	}
+	return t, true
+}
+
+// adminTicketsHandler handles GET /admin/tickets. Open tickets are listed
+// first, most urgent first; ?status= filters on one status.
+func adminTicketsHandler(c *gin.Context) {
+	q := db.Order("CASE WHEN status IN ('opgelost', 'gesloten') THEN 1 ELSE 0 END").
+		Order("CASE priority WHEN 'urgent' THEN 0 WHEN 'hoog' THEN 1 WHEN 'normaal' THEN 2 ELSE 3 END").
+		Order("reactie_voor").Limit(500)
+	if status := c.Query("status"); status != "" {
+		q = q.Where("status = ?", status)
+	}
+	var tickets []Ticket
+	if err := q.Find(&tickets).Error; err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	renderAdmin(c, http.StatusOK, "tickets", "tickets", "Tickets", gin.H{
+		"Tickets":  tickets,
+		"Statuses": ticketStatuses,
+		"Status":   c.Query("status"),
+	})
+}
+
+// adminTicketHandler handles GET /admin/tickets/:id.
+func adminTicketHandler(c *gin.Context) {
+	t, ok := loadTicket(c)
+	if !ok {
+		return
+	}
+	renderAdminTicket(c, http.StatusOK, t, "")
+}
+
+func renderAdminTicket(c *gin.Context, status int, t Ticket, errMsg string) {
+	renderAdmin(c, status, "ticket", "tickets", "Ticket "+t.Number, gin.H{
+		"Ticket":     t,
+		"Statuses":   ticketStatuses,
+		"Priorities": priorities,
+		"Link":       ticketLink(t),
+		"Error":      errMsg,
+	})
+}
+
+// adminTicketReplyHandler handles POST /admin/tickets/:id: a reply to the
+// customer or an internal note, optionally changing the status.
+func adminTicketReplyHandler(c *gin.Context) {
+	t, ok := loadTicket(c)
+	if !ok {
+		return
+	}
+	body := strings.TrimSpace(c.PostForm("bericht"))
+	internal := c.PostForm("soort") == "notitie"
+	status := c.PostForm("status")
+	if !slices.Contains(ticketStatuses, status) {
+		status = t.Status
+	}
+	uploads, err := formUploads(c)
+	if err != nil {
+		renderAdminTicket(c, http.StatusBadRequest, t, err.Error())
+		return
+	}
+	if body == "" && len(uploads) == 0 && status == t.Status {
+		renderAdminTicket(c, http.StatusBadRequest, t, "Schrijf een bericht of kies een andere status.")
+		return
+	}
+	attachments, err := storeUploads(c, uploads)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	admin, _ := adminUser(c)
+	flash := "Status bijgewerkt."
+	action, changes, oldStatus := "ticket.status", auditChanges{}, t.Status
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if body != "" || len(attachments) > 0 {
+			m := TicketMessage{
+				TicketID:    t.ID,
+				Author:      admin.DisplayName(),
+				Internal:    internal,
+				Body:        body,
+				Attachments: attachments,
+			}
+			if err := tx.Create(&m).Error; err != nil {
+				return err
+			}
+			// the message itself stays with the ticket, where it can be erased
+			changes["Bericht"] = [2]any{nil, fmt.Sprintf("#%d", m.ID)}
+			if len(attachments) > 0 {
+				changes["Bijlagen"] = [2]any{nil, len(attachments)}
+			}
+			if internal {
+				action = "ticket.notitie"
+				flash = "Notitie toegevoegd."
+			} else {
+				action = "ticket.beantwoord"
+				flash = "Antwoord verzonden naar " + t.Email + "."
+				if status == ticketNew {
+					status = ticketWaiting
+				}
+				if err := queueMail(tx, ticketReplyMail(t, m)); err != nil {
+					return err
+				}
+			}
+		}
+		if err := updateTicketStatus(tx, &t, status); err != nil {
+			return err
+		}
+		if status != oldStatus {
+			changes["Status"] = [2]any{oldStatus, status}
+		}
+		return recordAudit(tx, c, action, "ticket", fmt.Sprint(t.ID), changes)
+	})
+	if err != nil {
+		discardAttachments(attachments)
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	redirectAdmin(c, fmt.Sprintf("/admin/tickets/%d", t.ID), flash)
+}
+
+// adminTicketPriorityHandler handles POST /admin/tickets/:id/prioriteit.
+// The response deadline moves with the priority.
+func adminTicketPriorityHandler(c *gin.Context) {
+	t, ok := loadTicket(c)
+	if !ok {
+		return
+	}
+	priority := c.PostForm("prioriteit")
+	if !slices.Contains(priorities, priority) {
+		renderAdminTicket(c, http.StatusBadRequest, t, "Onbekende prioriteit.")
+		return
+	}
+	deadline := responseDeadline(priority, t.CreatedAt).UTC()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&t).Omit(clause.Associations).Updates(map[string]any{
+			"priority":     priority,
+			"reactie_voor": deadline,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return recordAudit(tx, c, "ticket.prioriteit", "ticket", fmt.Sprint(t.ID), auditDiff(
+			auditFields(map[string]any{"Priority": t.Priority, "ReactieVoor": t.ReactieVoor.UTC()}),
+			auditFields(map[string]any{"Priority": priority, "ReactieVoor": deadline}),
+		))
+	})
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	redirectAdmin(c, fmt.Sprintf("/admin/tickets/%d", t.ID), "Prioriteit bijgewerkt.")
+}
+
+// updateTicketStatus saves a status change, keeping ClosedAt in step.
+func updateTicketStatus(tx *gorm.DB, t *Ticket, status string) error {
+	updates := map[string]any{"status": status, "updated_at": time.Now().UTC()}
+	if status == ticketClosed || status == ticketSolved {
+		if t.ClosedAt == nil {
+			updates["closed_at"] = time.Now().UTC()
+		}
+	} else {
+		updates["closed_at"] = nil
+	}
+	t.Status = status
+	return tx.Model(t).Omit(clause.Associations).Updates(updates).Error
+}
+
+// ticketFromToken loads the ticket a customer link points to.
+func ticketFromToken(c *gin.Context) (Ticket, string, bool) {
+	token := c.Query("token")
+	if token == "" {
+		token = c.PostForm("token")
+	}
+	id, err := verifyID(tokenTicket, token)
+	if err != nil {
+		c.HTML(http.StatusForbidden, "ticket", privatePage("/ticket", "Ticket", "ticket", gin.H{"Error": err.Error()}))
+		return Ticket{}, "", false
+	}
+	t, err := findTicket(id)
+	if err != nil {
+		c.HTML(http.StatusNotFound, "ticket", privatePage("/ticket", "Ticket", "ticket", gin.H{"Error": "Dit ticket bestaat niet meer."}))
+		return Ticket{}, "", false
+	}
+	return t, token, true
+}
+
+// ticketHandler handles GET /ticket?token=...: the customer's view of their
+// ticket.
+func ticketHandler(c *gin.Context) {
+	t, token, ok := ticketFromToken(c)
+	if !ok {
+		return
+	}
+	c.HTML(http.StatusOK, "ticket", privatePage("/ticket", "Ticket "+t.Number, "ticket", gin.H{"Ticket": t, "Token": token}))
+}
+
+// ticketReplyHandler handles POST /ticket/reageren, a customer reply.
+func ticketReplyHandler(c *gin.Context) {
+	t, token, ok := ticketFromToken(c)
+	if !ok {
+		return
+	}
+	data := gin.H{"Ticket": t, "Token": token}
+	body := strings.TrimSpace(c.PostForm("bericht"))
+	uploads, err := formUploads(c)
+	switch {
+	case t.Status == ticketClosed:
+		data["Error"] = "Dit ticket is gesloten. Neem contact met ons op als u nog vragen heeft."
+	case err != nil:
+		data["Error"] = err.Error()
+	case body == "":
+		data["Error"] = "Schrijf een bericht."
+	}
+	if data["Error"] != nil {
+		c.HTML(http.StatusBadRequest, "ticket", privatePage("/ticket", "Ticket "+t.Number, "ticket", data))
+		return
+	}
+	attachments, err := storeUploads(c, uploads)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	m := TicketMessage{
+		TicketID:     t.ID,
+		Author:       t.Naam,
+		FromCustomer: true,
+		Body:         body,
+		Attachments:  attachments,
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&m).Error; err != nil {
+			return err
+		}
+		status := t.Status
+		if status == ticketWaiting || status == ticketSolved {
+			status = ticketOpen
+		}
+		if err := updateTicketStatus(tx, &t, status); err != nil {
+			return err
+		}
+		return queueMail(tx, &OutboxMessage{
+			To:      company.Email,
+			Subject: fmt.Sprintf("[%s] Reactie van %s: %s", t.Number, t.Naam, t.Onderwerp),
+			Text: fmt.Sprintf("%s reageerde op ticket %s:\n\n%s\n\nBekijk het ticket: %s\n",
+				t.Naam, t.Number, body, site.URL(fmt.Sprintf("/admin/tickets/%d", t.ID))),
+		})
+	})
+	if err != nil {
+		discardAttachments(attachments)
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/ticket?token="+token+"#bericht-"+strconv.FormatUint(uint64(m.ID), 10))
+}
+
+// ticketAttachmentHandler handles GET /ticket/bijlage/:id?token=...; the
+// attachment must belong to a public message of the ticket.
+func ticketAttachmentHandler(c *gin.Context) {
+	id, err := verifyID(tokenTicket, c.Query("token"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	var a Attachment
+	err = db.Joins("JOIN ticket_messages ON ticket_messages.id = attachments.ticket_message_id").
+		Where("attachments.id = ? AND ticket_messages.ticket_id = ? AND NOT ticket_messages.internal", c.Param("id"), id).
+		First(&a).Error
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	serveAttachment(c, a)
+}
+
+func ticketOpenedMail(t Ticket) *OutboxMessage {
+	return &OutboxMessage{
+		To:      t.Email,
+		Subject: fmt.Sprintf("[%s] Uw supportaanvraag is ontvangen", t.Number),
+		Text: fmt.Sprintf(`Beste %s,
+
+Wij hebben uw aanvraag voor technische ondersteuning ontvangen onder ticketnummer %s. U krijgt uiterlijk %s om %s een reactie.
+
+Via deze persoonlijke link volgt u de voortgang en kunt u reageren of bestanden toevoegen:
+%s
+
+Deel deze link niet met anderen. Bij spoed kunt u ons bellen op %s.
+
+Met vriendelijke groet,
+%s
+`, t.Naam, t.Number, dutchDate(t.ReactieVoor), t.ReactieVoor.In(openingHoursLocation).Format("15:04"),
+			ticketLink(t), company.PhoneDisplay, company.Name),
+	}
+}
+
+func ticketReplyMail(t Ticket, m TicketMessage) *OutboxMessage {
+	return &OutboxMessage{
+		To:      t.Email,
+		Subject: fmt.Sprintf("[%s] Nieuwe reactie op uw ticket: %s", t.Number, t.Onderwerp),
+		Text: fmt.Sprintf(`Beste %s,
+
+Er is een nieuwe reactie op uw ticket %s:
+
+%s
+
+Reageren of bestanden toevoegen kan via:
+%s
+
+Met vriendelijke groet,
+%s
+`, t.Naam, t.Number, m.Body, ticketLink(t), company.Name),
+	}
+}
+
+// formatFileSize writes a size in bytes as e.g. "1,4 MB".
+func formatFileSize(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return strings.Replace(strconv.FormatFloat(float64(n)/(1<<20), 'f', 1, 64), ".", ",", 1) + " MB"
+	case n >= 1<<10:
+		return strconv.FormatInt(n>>10, 10) + " kB"
+	}
+	return strconv.FormatInt(n, 10) + " bytes"
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestNextTicketNumber(t *testing.T) {
+	testDB(t, &Contact{}, &Attachment{}, &Ticket{}, &TicketMessage{})
+	prefix := fmt.Sprintf("TK-%d-", time.Now().In(openingHoursLocation).Year())
+	tests := []struct {
+		existing []string
+		want     string
+	}{
+		{nil, prefix + "0001"},
+		{[]string{"TK-2020-0007"}, prefix + "0001"},
+		{[]string{prefix + "0001", prefix + "0002"}, prefix + "0003"},
+		{[]string{prefix + "9998", prefix + "9999"}, prefix + "10000"},
+		{[]string{prefix + "9999", prefix + "10000", prefix + "0999"}, prefix + "10001"},
+	}
+	for _, tt := range tests {
+		if err := db.Where("1 = 1").Delete(&Ticket{}).Error; err != nil {
+			t.Fatal(err)
+		}
+		for _, number := range tt.existing {
+			ticket := Ticket{Number: number, Naam: "Klant", Email: "klant@example.nl", Onderwerp: "Laptop", Status: ticketNew, Priority: "normaal"}
+			if err := db.Create(&ticket).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got, err := nextTicketNumber(db); err != nil || got != tt.want {
+			t.Errorf("after %v: nextTicketNumber = %q, %v, want %q", tt.existing, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errTokenInvalid = errors.New("ongeldige link")
+	errTokenExpired = errors.New("deze link is verlopen")
+)
+
+// signToken returns a URL-safe token binding subject to purpose, signed with
+// the site secret. A token for one purpose is never accepted for another. A
+// ttl of zero means the token does not expire.
+func signToken(purpose, subject string, ttl time.Duration) string {
+	var expires int64
+	if ttl > 0 {
+		expires = time.Now().Add(ttl).Unix()
+	}
+	payload := base64.RawURLEncoding.EncodeToString([]byte(subject)) + "." + strconv.FormatInt(expires, 36)
+	return payload + "." + tokenMAC(purpose, payload)
+}
+
+// verifyToken checks a token made by signToken for purpose and returns its
+// subject.
+func verifyToken(purpose, token string) (string, error) {
+	i := strings.LastIndexByte(token, '.')
+	if i < 0 {
+		return "", errTokenInvalid
+	}
+	payload, mac := token[:i], token[i+1:]
+	if !hmac.Equal([]byte(mac), []byte(tokenMAC(purpose, payload))) {
+		return "", errTokenInvalid
+	}
+	encSubject, encExpires, ok := strings.Cut(payload, ".")
+	if !ok {
+		return "", errTokenInvalid
+	}
+	expires, err := strconv.ParseInt(encExpires, 36, 64)
+	if err != nil {
+		return "", errTokenInvalid
+	}
+	if expires != 0 && time.Now().Unix() > expires {
+		return "", errTokenExpired
+	}
+	subject, err := base64.RawURLEncoding.DecodeString(encSubject)
+	if err != nil {
+		return "", errTokenInvalid
+	}
+	return string(subject), nil
+}
+
+func tokenMAC(purpose, payload string) string {
+	h := hmac.New(sha256.New, site.Secret)
+	h.Write([]byte(purpose + "\x00" + payload))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// signID and verifyID are signToken and verifyToken for database IDs.
+func signID(purpose string, id uint, ttl time.Duration) string {
+	return signToken(purpose, strconv.FormatUint(uint64(id), 10), ttl)
+}
+
+func verifyID(purpose, token string) (uint, error) {
+	subject, err := verifyToken(purpose, token)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseUint(subject, 10, 64)
+	if err != nil {
+		return 0, errTokenInvalid
+	}
+	return uint(id), nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testSecret(t *testing.T) {
+	t.Helper()
+	saved := site.Secret
+	site.Secret = []byte("test secret")
+	t.Cleanup(func() { site.Secret = saved })
+}
+
+func TestVerifyToken(t *testing.T) {
+	testSecret(t)
+	valid := signToken("afspraak", "42", time.Hour)
+	forever := signToken("afspraak", "42", 0)
+	payload := valid[:strings.LastIndexByte(valid, '.')]
+	tests := []struct {
+		name    string
+		purpose string
+		token   string
+		want    string
+		err     error
+	}{
+		{"valid", "afspraak", valid, "42", nil},
+		{"without expiry", "afspraak", forever, "42", nil},
+		{"other purpose", "offerte", valid, "", errTokenInvalid},
+		{"changed subject", "afspraak", "NDM" + valid[strings.IndexByte(valid, '.'):], "", errTokenInvalid},
+		{"signed for other purpose", "afspraak", payload + "." + tokenMAC("offerte", payload), "", errTokenInvalid},
+		{"no signature", "afspraak", "NDI", "", errTokenInvalid},
+		{"empty", "afspraak", "", "", errTokenInvalid},
+	}
+	for _, tt := range tests {
+		got, err := verifyToken(tt.purpose, tt.token)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("%s: verifyToken = %q, %v, want %q, %v", tt.name, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestVerifyTokenExpired(t *testing.T) {
+	testSecret(t)
+	token := signToken("afspraak", "42", time.Minute)
+	subject, _, _ := strings.Cut(token, ".")
+	// the same token, signed as if it had expired a minute ago
+	payload := subject + "." + strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 36)
+	expired := payload + "." + tokenMAC("afspraak", payload)
+	if _, err := verifyToken("afspraak", token); err != nil {
+		t.Errorf("token before expiry: %v", err)
+	}
+	if _, err := verifyToken("afspraak", expired); !errors.Is(err, errTokenExpired) {
+		t.Errorf("token after expiry: err = %v, want %v", err, errTokenExpired)
+	}
+}
+
+func TestVerifyID(t *testing.T) {
+	testSecret(t)
+	if id, err := verifyID("offerte", signID("offerte", 7, time.Hour)); err != nil || id != 7 {
+		t.Errorf("verifyID = %d, %v, want 7", id, err)
+	}
+	if _, err := verifyID("offerte", signToken("offerte", "zeven", time.Hour)); !errors.Is(err, errTokenInvalid) {
+		t.Errorf("non-numeric subject: err = %v, want %v", err, errTokenInvalid)
+	}
+}
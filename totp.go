@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"image/png"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/qr"
+	"gorm.io/gorm"
+)
+
+// Time-based one-time passwords (RFC 6238) with the settings every
+// authenticator app supports: HMAC-SHA1, six digits, a new code every 30
+// seconds.
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	// totpSkew accepts the codes of one step before and after the current
+	// one, for clocks that are slightly off.
+	totpSkew = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newTOTPSecret returns a random secret of 160 bits, in base32 as entered
+// into authenticator apps.
+func newTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// totpCode returns the code for secret in time step step (RFC 4226).
+func totpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1_000_000), nil
+}
+
+// checkTOTP returns the time step of code if it is valid for secret at now
+// and later than lastStep; a code that was accepted once cannot be used
+// again. It returns 0 if the code is not valid.
+func checkTOTP(secret, code string, lastStep int64, now time.Time) int64 {
+	code = strings.Join(strings.Fields(code), "")
+	if len(code) != totpDigits {
+		return 0
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		want, err := totpCode(secret, step)
+		if err == nil && hmac.Equal([]byte(want), []byte(code)) {
+			return step
+		}
+	}
+	return 0
+}
+
+// totpURI returns the otpauth:// URI authenticator apps read from the QR
+// code, labelled with the company name and the username.
+func totpURI(u AdminUser, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", company.Name)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(company.Name + ":" + u.Username)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// qrDataURI returns content as a QR code in a data: URI, so the secret in
+// it never leaves the page that shows it.
+func qrDataURI(content string) (template.URL, error) {
+	code, err := qr.Encode(content, qr.M, qr.Auto)
+	if err != nil {
+		return "", err
+	}
+	if code, err = barcode.Scale(code, 240, 240); err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, code); err != nil {
+		return "", err
+	}
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())), nil
+}
+
+// AdminRecoveryCode is a single-use code to log in with when the
+// authenticator app is lost. Only a hash of the code is stored.
+type AdminRecoveryCode struct {
+	ID          uint   `gorm:"primaryKey"`
+	AdminUserID uint   `gorm:"not null;index"`
+	Hash        string `gorm:"not null"`
+	UsedAt      *time.Time
+	CreatedAt   time.Time
+}
+
+const recoveryCodeCount = 10
+
+// recoveryAlphabet leaves out characters that are easily mistaken for
+// one another, such as 0 and O.
+const recoveryAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRecoveryCodes replaces the recovery codes of a user and returns the
+// new ones, to be shown once.
+func newRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("admin_user_id = ?", userID).Delete(&AdminRecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	codes := make([]string, recoveryCodeCount)
+	records := make([]AdminRecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		for j := range b {
+			b[j] = recoveryAlphabet[int(b[j])%len(recoveryAlphabet)]
+		}
+		codes[i] = string(b[:5]) + "-" + string(b[5:])
+		records[i] = AdminRecoveryCode{AdminUserID: userID, Hash: hashRecoveryCode(codes[i])}
+	}
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// claimTOTPStep records step as the last time step used by the user and
+// reports whether it was later than the one recorded, so that of two
+// logins with the same code only one succeeds.
+func claimTOTPStep(tx *gorm.DB, userID uint, step int64) (bool, error) {
+	res := tx.Model(&AdminUser{}).
+		Where("id = ? AND totp_last_step < ?", userID, step).
+		UpdateColumn("totp_last_step", step)
+	return res.RowsAffected > 0, res.Error
+}
+
+// useRecoveryCode reports whether code is an unused recovery code of the
+// user, and marks it used.
+func useRecoveryCode(tx *gorm.DB, userID uint, code string) (bool, error) {
+	res := tx.Model(&AdminRecoveryCode{}).
+		Where("admin_user_id = ? AND hash = ? AND used_at IS NULL", userID, hashRecoveryCode(code)).
+		Update("used_at", time.Now().UTC())
+	return res.RowsAffected > 0, res.Error
+}
+
+// unusedRecoveryCodes counts the recovery codes a user has left.
+func unusedRecoveryCodes(userID uint) (int64, error) {
+	var n int64
+	err := db.Model(&AdminRecoveryCode{}).Where("admin_user_id = ? AND used_at IS NULL", userID).Count(&n).Error
+	return n, err
+}
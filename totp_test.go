@@ -0,0 +1,130 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the test vectors in RFC 6238,
+// "12345678901234567890", in base32.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B; six digits are the last six of the eight there
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := totpCode(rfc6238Secret, tt.unix/totpPeriod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("totpCode at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestCheckTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / totpPeriod
+	code := func(step int64) string {
+		c, err := totpCode(rfc6238Secret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	tests := []struct {
+		name     string
+		code     string
+		lastStep int64
+		want     int64
+	}{
+		{"current", code(current), 0, current},
+		{"with spaces", code(current)[:3] + " " + code(current)[3:], 0, current},
+		{"previous step", code(current - 1), 0, current - 1},
+		{"next step", code(current + 1), 0, current + 1},
+		{"too old", code(current - 2), 0, 0},
+		{"used before", code(current), current, 0},
+		{"older than last used", code(current - 1), current, 0},
+		{"wrong", "000000", 0, 0},
+		{"too short", code(current)[:5], 0, 0},
+	}
+	for _, tt := range tests {
+		if got := checkTOTP(rfc6238Secret, tt.code, tt.lastStep, now); got != tt.want {
+			t.Errorf("%s: checkTOTP = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestClaimTOTPStep(t *testing.T) {
+	testDB(t, &AdminUser{})
+	u := AdminUser{Username: "jan", Naam: "Jan", Role: "eigenaar", PasswordHash: "x", TOTPLastStep: 10}
+	if err := db.Create(&u).Error; err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		step int64
+		want bool
+	}{
+		{10, false},
+		{9, false},
+		{11, true},
+		{11, false}, // the same code again
+		{12, true},
+	}
+	for _, tt := range tests {
+		got, err := claimTOTPStep(db, u.ID, tt.step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("claimTOTPStep(%d) = %v, want %v", tt.step, got, tt.want)
+		}
+	}
+}
+
+func TestUseRecoveryCode(t *testing.T) {
+	testDB(t, &AdminRecoveryCode{})
+	codes, err := newRecoveryCodes(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("got %d codes, want %d", len(codes), recoveryCodeCount)
+	}
+	code := codes[0]
+	tests := []struct {
+		name   string
+		userID uint
+		code   string
+		want   bool
+	}{
+		{"other user", 2, code, false},
+		{"lower case without dash", 1, strings.ToLower(" " + code[:5] + code[6:] + " "), true},
+		{"used before", 1, code, false},
+		{"unknown", 1, "AAAAA-AAAAA", false},
+	}
+	for _, tt := range tests {
+		got, err := useRecoveryCode(db, tt.userID, tt.code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: useRecoveryCode = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if n, err := unusedRecoveryCodes(1); err != nil || n != recoveryCodeCount-1 {
+		t.Errorf("unusedRecoveryCodes = %d, %v, want %d", n, err, recoveryCodeCount-1)
+	}
+}